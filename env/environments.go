@@ -31,6 +31,19 @@ import (
 var (
 	PemFile          = os.Getenv("PEM_FILE")
 	EcPemFile        = os.Getenv("EC_PEM_FILE")
+	EdPemFile        = os.Getenv("ED_PEM_FILE")
+	KeysDir          = os.Getenv("KEYS_DIR")
+	KeysConfig       = os.Getenv("KEYS_CONFIG")
+	AsiceSessionTTL  = os.Getenv("ASICE_SESSION_TTL")
+	WorkDir          = os.Getenv("WORK_DIR")
+	WorkMemoryLimit  = os.Getenv("WORK_MEMORY_LIMIT")
+	TsaUrl           = os.Getenv("TSA_URL")
+	TsaCert          = os.Getenv("TSA_CERT")
+	TsaKeyId         = os.Getenv("TSA_KEY_ID")
+	TsaPolicyOid     = os.Getenv("TSA_POLICY_OID")
+	TsaAccuracy      = os.Getenv("TSA_ACCURACY")
+	TrustStore       = os.Getenv("TRUST_STORE")
+	VerifyWorkers    = os.Getenv("VERIFY_WORKERS")
 	ApiKey           = os.Getenv("API_KEY")
 	RsaAuthCert      = os.Getenv("RSA_AUTH_CERT")
 	RsaSigningCert   = os.Getenv("RSA_SIGN_CERT")
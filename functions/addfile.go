@@ -23,52 +23,117 @@
 package functions
 
 import (
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"os"
 )
 
 func HandleAddFileToAsiceRequest(w http.ResponseWriter, r *http.Request) {
-	if !isPostMethod(r) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
+	AddFileHandler(defaultWorkStorage)(w, r)
+}
 
-	if !CheckVolumeMounted("/tmp") {
-		log.Println("Volume is not available or mounted. asice/addFile method is not available")
-		return
-	}
+// AddFileHandler adds files to the container. Container is kept in memory or
+// in temporary files of the work storage, depending on request size.
+func AddFileHandler(storage *WorkStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
 
-	req, err := decodeRequest(r)
-	if err != nil {
-		http.Error(w, "Can't decode request, getting error", http.StatusBadRequest)
-		return
+		profile, err := getContainerProfile(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		compressionLevel, err := getCompressionLevel(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Same limit for JSON and multipart bodies
+		r.Body = http.MaxBytesReader(w, r.Body, maxContainerSize)
+		newAsice, newAsiceWriter, err := storage.newArchive(r.ContentLength)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		defer newAsice.Close()
+		setCompressionLevel(newAsiceWriter, compressionLevel)
+
+		if isMultipartRequest(r) {
+			err = addMultipartFilesToArchive(r, storage, newAsiceWriter, profile)
+		} else {
+			err = addJSONFilesToArchive(r, newAsiceWriter, profile)
+		}
+		if errors.Is(err, errStorageUnavailable) {
+			writeStorageError(w, err)
+			return
+		}
+		if err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		if err := newAsiceWriter.Close(); err != nil {
+			log.Printf("Error closing newAsiceWriter: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		container, err := newAsice.Reader()
+		if err != nil {
+			log.Printf("Error reading new container: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if profile != nil {
+			writeProfileContainer(w, r, container, profile)
+			return
+		}
+
+		log.Println("Provided files added to ASiC-E container")
+		writeContainerStream(w, r, container)
 	}
+}
 
-	emptyAsiceReader, err := getEmptyAsiceReader(req)
+// writeProfileContainer checks the container against the profile, which needs
+// the whole container in memory for repair.
+func writeProfileContainer(w http.ResponseWriter, r *http.Request, container io.Reader, profile *containerProfile) {
+	newAsiceFileBytes, err := io.ReadAll(container)
 	if err != nil {
-		http.Error(w, "Error reading decoded ASic-E:", http.StatusBadRequest)
+		log.Printf("Error reading new container: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	newAsiceFile, newAsiceWriter, err := createNewAsiceFile()
+	newAsiceFileBytes, violations, err := applyContainerProfile(newAsiceFileBytes, profile, isRepairRequested(r))
 	if err != nil {
+		log.Printf("Error applying container profile: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	defer os.Remove(newAsiceFile.Name())
-
-	if err := addFilesToArchive(req, emptyAsiceReader, newAsiceWriter); err != nil {
+	if len(violations) > 0 {
+		http.Error(w, profileError(profile, violations), http.StatusBadRequest)
 		return
 	}
 
-	if err := newAsiceWriter.Close(); err != nil {
-		log.Printf("Error closing newAsiceWriter: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	log.Println("Provided files added to ASiC-E container")
+	setContainerFileName(w, r, profile)
+	writeContainerResponse(w, r, newAsiceFileBytes)
+}
 
-	if err := writeResponse(w, r, newAsiceFile); err != nil {
+// writeStorageError answers with 503 when container can't be kept in memory
+// and the work directory is not available.
+func writeStorageError(w http.ResponseWriter, err error) {
+	log.Printf("Container storage error: %v", err)
+	if errors.Is(err, errStorageUnavailable) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
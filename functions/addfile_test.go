@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestMultipartBody writes container and files as multipart/form-data parts.
+func newTestMultipartBody(t *testing.T, container []byte, files map[string]string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if container != nil {
+		part, err := writer.CreateFormFile("emptyAsice", "container.asice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(container)
+	}
+	for name, content := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="signedFiles"; filename="%s"`, name))
+		header.Set("Content-Type", "application/octet-stream")
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	assert.NoError(t, writer.Close())
+	return &body, writer.FormDataContentType()
+}
+
+func TestAddFileMultipart(t *testing.T) {
+	fmt.Println("!!! Starting addFile tests on addfile.go !!!")
+	container := newTestAsice(t, map[string]string{"existing.txt": "Existing"})
+	body, contentType := newTestMultipartBody(t, container, map[string]string{"folder/test.txt": "Hello, World!"})
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile?type=binary", body)
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+	assertMimetypeEntry(t, rr.Body.Bytes(), asiceMimeType)
+	reader, err := openAsice(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := readZipFile(findZipFile(reader, "folder/test.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, World!", string(content))
+	assert.NotNil(t, findZipFile(reader, "existing.txt"))
+}
+
+func TestAddFileMultipartContainerFirst(t *testing.T) {
+	body, contentType := newTestMultipartBody(t, nil, map[string]string{"test.txt": "Hello"})
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile", body)
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "first part shall be emptyAsice")
+}
+
+func TestAddFileBase64Response(t *testing.T) {
+	container := newTestAsice(t, map[string]string{"existing.txt": "Existing"})
+	body := fmt.Sprintf(`{"emptyAsice": "%s", "signedFiles": [{"fileName": "test.txt", "encodedFile": "SGVsbG8="}]}`,
+		base64.StdEncoding.EncodeToString(container))
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile?type=base64", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var response map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	packed, err := base64.StdEncoding.DecodeString(response["packedAsice"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := openAsice(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, findZipFile(reader, "test.txt"))
+}
+
+func TestAddFileKeepsEntryMetadata(t *testing.T) {
+	modified := time.Date(2023, 3, 4, 5, 6, 8, 0, time.UTC)
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	assert.NoError(t, writeMimetype(writer, asiceMimeType))
+	header := &zip.FileHeader{Name: "stored.txt", Method: zip.Store, Modified: modified, Comment: "signed file"}
+	fileWriter, err := writer.CreateHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter.Write([]byte("Signed content"))
+	fileWriter, err = writer.Create("deflated.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileWriter.Write([]byte(strings.Repeat("Deflated content ", 100)))
+	assert.NoError(t, writer.Close())
+	container := buffer.Bytes()
+
+	body := fmt.Sprintf(`{"emptyAsice": "%s", "signedFiles": [{"fileName": "test.txt", "encodedFile": "%s"}]}`,
+		base64.StdEncoding.EncodeToString(container), base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 1000))))
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile?compressionLevel=0", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+
+	original, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{asiceMimetypeFile, "stored.txt", "deflated.txt"} {
+		before, after := findZipFile(original, name), findZipFile(result, name)
+		if !assert.NotNil(t, after, name) {
+			continue
+		}
+		assert.Equal(t, before.FileHeader, after.FileHeader, name)
+
+		beforeRaw, err := before.OpenRaw()
+		assert.NoError(t, err)
+		afterRaw, err := after.OpenRaw()
+		assert.NoError(t, err)
+		beforeBytes, _ := io.ReadAll(beforeRaw)
+		afterBytes, _ := io.ReadAll(afterRaw)
+		assert.Equal(t, beforeBytes, afterBytes, name)
+	}
+	assert.Equal(t, asiceMimetypeFile, result.File[0].Name)
+
+	// Level 0 writes the added file without compression
+	added := findZipFile(result, "test.txt")
+	if assert.NotNil(t, added) {
+		assert.GreaterOrEqual(t, added.CompressedSize64, added.UncompressedSize64)
+	}
+}
+
+func TestAddFileCompressionLevel(t *testing.T) {
+	container := newTestAsice(t, map[string]string{"existing.txt": "Existing"})
+	body := fmt.Sprintf(`{"emptyAsice": "%s", "signedFiles": [{"fileName": "test.txt", "encodedFile": "%s"}]}`,
+		base64.StdEncoding.EncodeToString(container), base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 1000))))
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile?compressionLevel=9", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		reader, err := openAsice(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		added := findZipFile(reader, "test.txt")
+		assert.Less(t, added.CompressedSize64, added.UncompressedSize64)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/asice/addFile?compressionLevel=10", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAddFileBodyLimit(t *testing.T) {
+	body := io.MultiReader(
+		strings.NewReader(`{"emptyAsice": "`),
+		&repeatReader{data: []byte(strings.Repeat("A", 1<<20)), limit: 65},
+		strings.NewReader(`", "files": []}`),
+	)
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile", body)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
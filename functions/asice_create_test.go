@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertMimetypeEntry checks that mimetype is the first entry, stored without
+// extra field and data descriptor.
+func assertMimetypeEntry(t *testing.T, container []byte, mimeType string) {
+	if !assert.True(t, len(container) > 30+len(asiceMimetypeFile)) {
+		return
+	}
+	assert.Equal(t, []byte("PK\x03\x04"), container[:4])
+	assert.Equal(t, uint16(0), binary.LittleEndian.Uint16(container[6:8]), "flags")
+	assert.Equal(t, uint16(zip.Store), binary.LittleEndian.Uint16(container[8:10]), "method")
+	assert.Equal(t, uint16(len(asiceMimetypeFile)), binary.LittleEndian.Uint16(container[26:28]), "name length")
+	assert.Equal(t, uint16(0), binary.LittleEndian.Uint16(container[28:30]), "extra field length")
+	assert.Equal(t, asiceMimetypeFile+mimeType, string(container[30:30+len(asiceMimetypeFile)+len(mimeType)]))
+}
+
+func TestHandleCreateAsiceRequest(t *testing.T) {
+	fmt.Println("!!! Starting ASiC-E creation tests on asice_create.go !!!")
+	body := fmt.Sprintf(`{"signedFiles": [
+		{"fileName": "test.txt", "encodedFile": "%s"},
+		{"fileName": "data.bin", "encodedFile": "%s", "mimeType": "application/x-custom"}
+	]}`, base64.StdEncoding.EncodeToString([]byte("Hello, World!")), base64.StdEncoding.EncodeToString([]byte{1, 2, 3}))
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/create", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleCreateAsiceRequest(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	container := rr.Body.Bytes()
+	assertMimetypeEntry(t, container, asiceMimeType)
+
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	assert.Equal(t, []string{asiceMimetypeFile, "test.txt", "data.bin", asiceManifestFile}, names)
+
+	mediaTypes, err := manifestMediaTypes(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, asiceMimeType, mediaTypes["/"])
+	assert.Equal(t, "text/plain", strings.Split(mediaTypes["test.txt"], ";")[0])
+	assert.Equal(t, "application/x-custom", mediaTypes["data.bin"])
+}
+
+func TestHandleCreateAsiceRequestInvalidFiles(t *testing.T) {
+	for _, body := range []string{
+		`{"signedFiles": []}`,
+		`{"signedFiles": [{"fileName": "mimetype", "encodedFile": "SGVsbG8="}]}`,
+		`{"signedFiles": [{"fileName": "a.txt", "encodedFile": "SGVsbG8="}, {"fileName": "a.txt", "encodedFile": "SGVsbG8="}]}`,
+		`{"signedFiles": [{"fileName": "a.txt", "encodedFile": "not base64"}]}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/asice/create", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		HandleCreateAsiceRequest(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+func TestHandleAddFileToAsiceRequestStoresMimetype(t *testing.T) {
+	// Container with compressed mimetype, as written by archive.Create
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	if err := writeZipFile(writer, asiceMimetypeFile, []byte(asiceMimeType)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeZipFile(writer, "META-INF/signatures0.xml", []byte("<signature/>")); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	body := fmt.Sprintf(`{"emptyAsice": "%s", "signedFiles": [{"fileName": "test.txt", "encodedFile": "SGVsbG8="}]}`,
+		base64.StdEncoding.EncodeToString(buffer.Bytes()))
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	assertMimetypeEntry(t, rr.Body.Bytes(), asiceMimeType)
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+// HandleRemoveAsiceFileRequest removes the data file from the container.
+func HandleRemoveAsiceFileRequest(w http.ResponseWriter, r *http.Request) {
+	if !isPostMethod(r) {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var asiceRemove requests.AsiceRemove
+	containerBytes, err := readContainerBody(w, r, &asiceRemove, func() string { return asiceRemove.Container })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileName := asiceFileName(r, asiceRemove.FileName)
+	if fileName == "" {
+		http.Error(w, "fileName is required", http.StatusBadRequest)
+		return
+	}
+
+	reader, err := openAsice(containerBytes)
+	if err != nil {
+		writeContainerError(w, err)
+		return
+	}
+
+	containerBytes, dropped, err := editAsiceDataFile(reader, fileName, nil, asiceRemove.DropSignatures || isDropSignaturesRequested(r))
+	if err != nil {
+		writeAsiceEditError(w, err)
+		return
+	}
+
+	log.Printf("File %s removed from ASiC-E container", fileName)
+	writeEditedContainer(w, r, containerBytes, dropped)
+}
+
+// HandleReplaceAsiceFileRequest replaces content of the data file.
+func HandleReplaceAsiceFileRequest(w http.ResponseWriter, r *http.Request) {
+	if !isPostMethod(r) {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var asiceReplace requests.AsiceReplace
+	containerBytes, err := readContainerBody(w, r, &asiceReplace, func() string { return asiceReplace.Container })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if asiceReplace.SignedFile.FileName == "" {
+		http.Error(w, "signedFile is required", http.StatusBadRequest)
+		return
+	}
+	content, err := base64.StdEncoding.DecodeString(asiceReplace.SignedFile.EncodedFile)
+	if err != nil {
+		http.Error(w, "Failed to decode file from base64", http.StatusBadRequest)
+		return
+	}
+
+	reader, err := openAsice(containerBytes)
+	if err != nil {
+		writeContainerError(w, err)
+		return
+	}
+
+	replacement := &asiceReplacement{Content: content, MediaType: asiceReplace.SignedFile.MimeType}
+	containerBytes, dropped, err := editAsiceDataFile(reader, asiceReplace.SignedFile.FileName, replacement, asiceReplace.DropSignatures || isDropSignaturesRequested(r))
+	if err != nil {
+		writeAsiceEditError(w, err)
+		return
+	}
+
+	log.Printf("File %s replaced in ASiC-E container", asiceReplace.SignedFile.FileName)
+	writeEditedContainer(w, r, containerBytes, dropped)
+}
+
+// asiceFileName returns file name from body, else from fileName query parameter.
+func asiceFileName(r *http.Request, fileName string) string {
+	if fileName != "" {
+		return fileName
+	}
+	return r.URL.Query().Get("fileName")
+}
+
+// isDropSignaturesRequested checks dropSignatures query parameter.
+func isDropSignaturesRequested(r *http.Request) bool {
+	return r.URL.Query().Get("dropSignatures") == "true"
+}
+
+func writeAsiceEditError(w http.ResponseWriter, err error) {
+	var signedErr *signedFileError
+	switch {
+	case errors.Is(err, errAsiceFileNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &signedErr):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// writeEditedContainer writes the container and lists removed signature files
+// in Dropped-Signatures header.
+func writeEditedContainer(w http.ResponseWriter, r *http.Request, containerBytes []byte, dropped []string) {
+	if len(dropped) > 0 {
+		log.Printf("Signatures %s removed from ASiC-E container", strings.Join(dropped, ", "))
+		w.Header().Set("Dropped-Signatures", strings.Join(dropped, ","))
+	}
+	writeContainerResponse(w, r, containerBytes)
+}
@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// newTestPartlySignedAsice returns signed container with unsigned extra.txt added.
+func newTestPartlySignedAsice(t *testing.T) []byte {
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)))
+	reader, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, file := range reader.File {
+		assert.NoError(t, writer.Copy(file))
+	}
+	assert.NoError(t, writeZipFile(writer, "extra.txt", []byte("Extra")))
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func editTestAsice(handler http.HandlerFunc, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestHandleRemoveAsiceFileRequest(t *testing.T) {
+	fmt.Println("!!! Starting ASiC-E edit tests on asice_edit.go !!!")
+	container := newTestPartlySignedAsice(t)
+	encoded := base64.StdEncoding.EncodeToString(container)
+
+	// Unsigned file is removed and signature stays valid
+	rr := editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove", fmt.Sprintf(`{"container": "%s", "fileName": "extra.txt"}`, encoded))
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		assert.Empty(t, rr.Header().Get("Dropped-Signatures"))
+		reader, err := openAsice(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, findZipFile(reader, "extra.txt"))
+		mediaTypes, err := manifestMediaTypes(reader)
+		assert.NoError(t, err)
+		assert.NotContains(t, mediaTypes, "extra.txt")
+		assert.Contains(t, mediaTypes, "test.txt")
+		assert.True(t, validateTestAsice(t, rr.Body.Bytes()).Valid)
+	}
+
+	// Signed file is refused without dropSignatures
+	rr = editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove", fmt.Sprintf(`{"container": "%s", "fileName": "test.txt"}`, encoded))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "META-INF/signatures0.xml")
+
+	rr = editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove?dropSignatures=true", fmt.Sprintf(`{"container": "%s", "fileName": "test.txt"}`, encoded))
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		assert.Equal(t, "META-INF/signatures0.xml", rr.Header().Get("Dropped-Signatures"))
+		reader, err := openAsice(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, findZipFile(reader, "test.txt"))
+		assert.Nil(t, findZipFile(reader, "META-INF/signatures0.xml"))
+		assert.NotNil(t, findZipFile(reader, "second file.txt"))
+		assertMimetypeEntry(t, rr.Body.Bytes(), asiceMimeType)
+	}
+
+	rr = editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove", fmt.Sprintf(`{"container": "%s", "fileName": "missing.txt"}`, encoded))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove", fmt.Sprintf(`{"container": "%s", "fileName": "META-INF/manifest.xml"}`, encoded))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// newTestMixedSignatureAsice returns signed container where signatures0.xml
+// holds signature S0 covering both data files and signature S1 covering only
+// second file.txt.
+func newTestMixedSignatureAsice(t *testing.T) []byte {
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)))
+	secondOnly := signTestAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)), replaceTestAsiceFile(t, container, "test.txt", nil))
+
+	readSignatures := func(container []byte, name string) []*xmlNode {
+		reader, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures, err := readAsiceSignatureFile(findZipFile(reader, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signatures
+	}
+	signatures := readSignatures(container, "META-INF/signatures0.xml")
+	signatures[0].Parent.AppendChild(readSignatures(secondOnly, "META-INF/signatures1.xml")[0])
+	merged, err := serializeXML(signatures[0].Parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", merged)
+}
+
+func TestHandleRemoveAsiceFileRequestMixedSignatureFile(t *testing.T) {
+	container := newTestMixedSignatureAsice(t)
+	validation := validateTestAsice(t, container)
+	if !assert.True(t, validation.Valid, validation) || !assert.Equal(t, 2, validation.SignatureCount) {
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(container)
+
+	rr := editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove", fmt.Sprintf(`{"container": "%s", "fileName": "test.txt"}`, encoded))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "META-INF/signatures0.xml#S0")
+
+	// Only the signature covering the file is removed from the signature file
+	rr = editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove?dropSignatures=true", fmt.Sprintf(`{"container": "%s", "fileName": "test.txt"}`, encoded))
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	assert.Equal(t, "META-INF/signatures0.xml#S0", rr.Header().Get("Dropped-Signatures"))
+	validation = validateTestAsice(t, rr.Body.Bytes())
+	assert.True(t, validation.Valid, validation)
+	if assert.Len(t, validation.Signatures, 1) {
+		assert.Equal(t, "S1", validation.Signatures[0].Id)
+		assert.Equal(t, "META-INF/signatures0.xml", validation.Signatures[0].File)
+	}
+
+	// Signature file is removed when all its signatures cover the file
+	rr = editTestAsice(HandleRemoveAsiceFileRequest, "/asice/remove?dropSignatures=true", fmt.Sprintf(`{"container": "%s", "fileName": "second file.txt"}`, encoded))
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		assert.Equal(t, "META-INF/signatures0.xml", rr.Header().Get("Dropped-Signatures"))
+		reader, err := openAsice(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, findZipFile(reader, "META-INF/signatures0.xml"))
+	}
+}
+
+func TestHandleReplaceAsiceFileRequest(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(newTestPartlySignedAsice(t))
+
+	body := fmt.Sprintf(`{"container": "%s", "signedFile": {"fileName": "extra.txt", "encodedFile": "TmV3", "mimeType": "application/x-extra"}}`, encoded)
+	rr := editTestAsice(HandleReplaceAsiceFileRequest, "/asice/replace", body)
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		reader, err := openAsice(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := readZipFile(findZipFile(reader, "extra.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "New", string(content))
+		mediaTypes, err := manifestMediaTypes(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, "application/x-extra", mediaTypes["extra.txt"])
+		assert.True(t, validateTestAsice(t, rr.Body.Bytes()).Valid)
+	}
+
+	body = fmt.Sprintf(`{"container": "%s", "signedFile": {"fileName": "test.txt", "encodedFile": "TmV3"}}`, encoded)
+	rr = editTestAsice(HandleReplaceAsiceFileRequest, "/asice/replace", body)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	body = fmt.Sprintf(`{"container": "%s", "signedFile": {"fileName": "test.txt", "encodedFile": "TmV3"}, "dropSignatures": true}`, encoded)
+	rr = editTestAsice(HandleReplaceAsiceFileRequest, "/asice/replace", body)
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		validation := validateTestAsice(t, rr.Body.Bytes())
+		assert.Equal(t, 0, validation.SignatureCount)
+	}
+}
+
+func TestHandleExtractAsiceFileRequest(t *testing.T) {
+	container := newTestPartlySignedAsice(t)
+	encoded := base64.StdEncoding.EncodeToString(container)
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/extract?fileName=test.txt", bytes.NewReader(container))
+	req.Header.Set("Content-Type", asiceMimeType)
+	rr := httptest.NewRecorder()
+	HandleExtractAsiceFileRequest(rr, req)
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		assert.Equal(t, "Hello, World!", rr.Body.String())
+		assert.Equal(t, "text/plain", rr.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="test.txt"`, rr.Header().Get("Content-Disposition"))
+	}
+
+	rr = editTestAsice(HandleExtractAsiceFileRequest, "/asice/extract?type=base64", fmt.Sprintf(`{"container": "%s", "fileName": "second file.txt"}`, encoded))
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		var file responses.AsiceFile
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&file))
+		assert.Equal(t, "second file.txt", file.FileName)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("Second")), file.EncodedFile)
+	}
+
+	rr = editTestAsice(HandleExtractAsiceFileRequest, "/asice/extract", fmt.Sprintf(`{"container": "%s"}`, encoded))
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		var files responses.AsiceFiles
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&files))
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("Extra")), files.Files["extra.txt"])
+		assert.Contains(t, files.Files, asiceManifestFile)
+		assert.Contains(t, files.Files, "META-INF/signatures0.xml")
+		assert.Len(t, files.Files, 6)
+	}
+
+	rr = editTestAsice(HandleExtractAsiceFileRequest, "/asice/extract", fmt.Sprintf(`{"container": "%s", "fileName": "missing.txt"}`, encoded))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHandleExtractAsiceFileRequestQuery(t *testing.T) {
+	container := newTestPartlySignedAsice(t)
+
+	extract := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(container))
+		req.Header.Set("Content-Type", asiceMimeType)
+		rr := httptest.NewRecorder()
+		HandleExtractAsiceFileRequest(rr, req)
+		return rr
+	}
+
+	rr := extract("/asice/extract?fileName=test.txt&type=base64")
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+		var file responses.AsiceFile
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&file))
+		assert.Equal(t, "test.txt", file.FileName)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("Hello, World!")), file.EncodedFile)
+		assert.Equal(t, "text/plain", file.MimeType)
+	}
+
+	rr = extract("/asice/extract?fileName=missing.txt")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), errAsiceFileNotFound.Error())
+}
+
+func TestHandleExtractAsiceFileRequestTooLarge(t *testing.T) {
+	// Entries up to 1 MiB are not checked for compression ratio
+	files := map[string]string{}
+	content := strings.Repeat("A", 1<<20)
+	for i := 0; i <= maxExtractAllSize>>20; i++ {
+		files[fmt.Sprintf("file%d.txt", i)] = content
+	}
+	container := newTestAsice(t, files)
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/extract", bytes.NewReader(container))
+	req.Header.Set("Content-Type", asiceMimeType)
+	rr := httptest.NewRecorder()
+	HandleExtractAsiceFileRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), codeContainerTooLarge)
+
+	req = httptest.NewRequest(http.MethodPost, "/asice/extract?fileName=file0.txt", bytes.NewReader(container))
+	req.Header.Set("Content-Type", asiceMimeType)
+	rr = httptest.NewRecorder()
+	HandleExtractAsiceFileRequest(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, content, rr.Body.String())
+}
@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// maxAsiceFinalizeSize limits finalize body, it holds only the token and the
+// signature value.
+const maxAsiceFinalizeSize = 8 << 10
+
+// AsicePrepareHandler builds XAdES signature for the signer certificate and
+// returns SignedInfo digest to be signed outside of the service.
+func AsicePrepareHandler(sessions *AsiceSessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxContainerSize)
+		var asicePrepare requests.AsicePrepare
+		if err := json.NewDecoder(r.Body).Decode(&asicePrepare); err != nil {
+			log.Printf("Failed to decode JSON: %s", err)
+			http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+			return
+		}
+
+		certificate, err := parseCertificate(asicePrepare.Certificate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		options, err := getRSASignatureOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hash, err := xadesHashAlgorithm(r, asicePrepare.HashAlgorithm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signatureMethod, err := xmlSignatureMethod(certificate.PublicKey, hash, options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		containerBytes, err := prepareContainer(asicePrepare)
+		if err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		reader, err := openAsice(containerBytes)
+		if err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		dataFiles, manifestEntries, err := asiceDataObjects(reader, hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signatureName, index := nextSignatureFileName(reader)
+		signatureID := fmt.Sprintf("S%d", index)
+		signature, err := prepareXadesSignature(xadesParameters{
+			SignatureID:     signatureID,
+			Certificate:     certificate,
+			Hash:            hash,
+			SignatureMethod: signatureMethod,
+			SigningTime:     time.Now().UTC().Truncate(time.Second),
+			DataFiles:       dataFiles,
+		})
+		if err != nil {
+			log.Printf("Error creating XAdES signature: %s", err)
+			http.Error(w, "Error creating XAdES signature", http.StatusInternalServerError)
+			return
+		}
+
+		document, err := signature.Document()
+		if err != nil {
+			log.Printf("Error creating XAdES signature: %s", err)
+			http.Error(w, "Error creating XAdES signature", http.StatusInternalServerError)
+			return
+		}
+
+		session := &asiceSession{
+			Container:       containerBytes,
+			SignatureName:   signatureName,
+			SignatureID:     signatureID,
+			Document:        document,
+			Certificate:     certificate,
+			Hash:            hash,
+			RSAOptions:      options,
+			ManifestEntries: manifestEntries,
+		}
+		token, err := sessions.Add(session)
+		if errors.Is(err, errAsiceSessionLimit) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			log.Printf("Error creating signing session: %s", err)
+			http.Error(w, "Error creating signing session", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("XAdES signature %s prepared for external signing", signatureName)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responses.AsicePrepare{
+			Token:           token,
+			Digest:          base64.StdEncoding.EncodeToString(signature.Digest()),
+			HashAlgorithm:   hash.String(),
+			SignatureMethod: signatureMethod,
+			SignedInfo:      base64.StdEncoding.EncodeToString(signature.SignedInfoBytes),
+			ExpiresAt:       session.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// AsiceFinalizeHandler adds external signature value to the prepared
+// signature and returns the signed container.
+func AsiceFinalizeHandler(sessions *AsiceSessionStore, timestamps *TimestampClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !checkTimestampRequest(w, r, timestamps) {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxAsiceFinalizeSize)
+		var asiceFinalize requests.AsiceFinalize
+		if err := json.NewDecoder(r.Body).Decode(&asiceFinalize); err != nil {
+			log.Printf("Failed to decode JSON: %s", err)
+			http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+			return
+		}
+
+		session, ok := sessions.Take(asiceFinalize.Token)
+		if !ok {
+			http.Error(w, "Signing session not found or expired", http.StatusNotFound)
+			return
+		}
+		finalized := false
+		defer func() {
+			if !finalized {
+				sessions.Restore(asiceFinalize.Token, session)
+			}
+		}()
+
+		signatureValue, err := base64.StdEncoding.DecodeString(asiceFinalize.SignatureValue)
+		if err != nil {
+			http.Error(w, "Failed to decode signature value from base64", http.StatusBadRequest)
+			return
+		}
+
+		signature, err := parseXadesSignature(session.Document, session.SignatureID, session.Hash)
+		if err != nil {
+			log.Printf("Error reading prepared XAdES signature: %s", err)
+			http.Error(w, "Error creating XAdES signature", http.StatusInternalServerError)
+			return
+		}
+
+		signatureValue, err = verifyXadesSignatureValue(session.Certificate.PublicKey, session.Hash, session.RSAOptions, signature.Digest(), signatureValue)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Signature value does not match prepared signature: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		signatureBytes, err := signature.Finalize(signatureValue)
+		if err != nil {
+			log.Printf("Error creating XAdES signature: %s", err)
+			http.Error(w, "Error creating XAdES signature", http.StatusInternalServerError)
+			return
+		}
+
+		if isTimestampRequested(r) {
+			if signatureBytes, err = timestampXadesSignature(signature, timestamps); err != nil {
+				writeTimestampError(w, err)
+				return
+			}
+		}
+
+		reader, err := openAsice(session.Container)
+		if err != nil {
+			log.Printf("Error reading prepared container: %s", err)
+			http.Error(w, "Error writing signed container", http.StatusInternalServerError)
+			return
+		}
+
+		signedContainer, err := writeAsiceWithSignature(reader, session.SignatureName, signatureBytes, createManifest(session.ManifestEntries))
+		if err != nil {
+			log.Printf("Error writing signed container: %s", err)
+			http.Error(w, "Error writing signed container", http.StatusInternalServerError)
+			return
+		}
+
+		finalized = true
+		log.Printf("XAdES signature %s finalized", session.SignatureName)
+		writeContainerResponse(w, r, signedContainer)
+	}
+}
+
+// prepareContainer returns container from request or creates a new one from
+// the signed files.
+func prepareContainer(asicePrepare requests.AsicePrepare) ([]byte, error) {
+	if asicePrepare.Container == "" {
+		return newAsiceContainer(asicePrepare.SignedFiles)
+	}
+	if len(asicePrepare.SignedFiles) > 0 {
+		return nil, errors.New("use either container or signedFiles, not both")
+	}
+
+	containerBytes, err := base64.StdEncoding.DecodeString(asicePrepare.Container)
+	if err != nil {
+		return nil, errors.New("failed to decode container from base64")
+	}
+	return containerBytes, nil
+}
@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+func prepareTestAsice(t *testing.T, sessions *AsiceSessionStore, certificate string) responses.AsicePrepare {
+	body := fmt.Sprintf(`{"signedFiles": [{"fileName": "test.txt", "encodedFile": "%s"}], "certificate": "%s"}`,
+		base64.StdEncoding.EncodeToString([]byte("Hello, World!")), certificate)
+	req := httptest.NewRequest(http.MethodPost, "/asice/prepare", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	AsicePrepareHandler(sessions)(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		t.FailNow()
+	}
+	var response responses.AsicePrepare
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func finalizeTestAsice(sessions *AsiceSessionStore, token string, signatureValue []byte) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"token": "%s", "signatureValue": "%s"}`, token, base64.StdEncoding.EncodeToString(signatureValue))
+	req := httptest.NewRequest(http.MethodPost, "/asice/finalize", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	AsiceFinalizeHandler(sessions, nil)(rr, req)
+	return rr
+}
+
+func TestAsicePrepareFinalizeRSA(t *testing.T) {
+	fmt.Println("!!! Starting ASiC-E external signing tests on asice_external.go !!!")
+	privateKey := generateTestRSAKey(t)
+	sessions := NewAsiceSessionStore(time.Minute)
+
+	prepared := prepareTestAsice(t, sessions, generateTestCertificate(t, privateKey))
+	assert.Equal(t, "SHA-256", prepared.HashAlgorithm)
+	assert.Equal(t, xmlRSASignatureMethods[crypto.SHA256], prepared.SignatureMethod)
+
+	digest, err := base64.StdEncoding.DecodeString(prepared.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureValue, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := finalizeTestAsice(sessions, prepared.Token, signatureValue)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	reader, err := openAsice(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTestXadesSignature(t, reader, "META-INF/signatures0.xml", &privateKey.PublicKey)
+	assert.NotNil(t, findZipFile(reader, asiceManifestFile))
+
+	// Token can be used only once
+	rr = finalizeTestAsice(sessions, prepared.Token, signatureValue)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAsicePrepareFinalizeECDSA(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewAsiceSessionStore(time.Minute)
+
+	prepared := prepareTestAsice(t, sessions, generateTestCertificate(t, privateKey))
+	digest, err := base64.StdEncoding.DecodeString(prepared.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wrong signature value is rejected, session stays available
+	rr := finalizeTestAsice(sessions, prepared.Token, make([]byte, 96))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// DER encoded signature is converted to P1363
+	signatureValue, err := ecdsa.SignASN1(rand.Reader, privateKey, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = finalizeTestAsice(sessions, prepared.Token, signatureValue)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	reader, err := openAsice(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := verifyTestXadesSignature(t, reader, "META-INF/signatures0.xml", &privateKey.PublicKey)
+	signatureValueElement := root.Path(xmlnsDS, "Signature", "SignatureValue")
+	decoded, err := base64.StdEncoding.DecodeString(signatureValueElement.Content())
+	assert.NoError(t, err)
+	assert.Len(t, decoded, 96)
+}
+
+func TestAsiceFinalizeConcurrent(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	sessions := NewAsiceSessionStore(time.Minute)
+
+	prepared := prepareTestAsice(t, sessions, generateTestCertificate(t, privateKey))
+	digest, err := base64.StdEncoding.DecodeString(prepared.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureValue, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one of concurrent finalizations with the same token succeeds
+	const finalizations = 8
+	codes := make(chan int, finalizations)
+	var wg sync.WaitGroup
+	for i := 0; i < finalizations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- finalizeTestAsice(sessions, prepared.Token, signatureValue).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			succeeded++
+		} else {
+			assert.Equal(t, http.StatusNotFound, code)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestAsiceFinalizeBodyLimit(t *testing.T) {
+	sessions := NewAsiceSessionStore(time.Minute)
+	body := fmt.Sprintf(`{"token": "token", "signatureValue": "%s"}`, strings.Repeat("A", maxAsiceFinalizeSize))
+	req := httptest.NewRequest(http.MethodPost, "/asice/finalize", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	AsiceFinalizeHandler(sessions, nil)(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Failed to decode JSON")
+}
+
+func TestAsiceFinalizeExpiredSession(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	sessions := NewAsiceSessionStore(time.Millisecond)
+
+	prepared := prepareTestAsice(t, sessions, generateTestCertificate(t, privateKey))
+	time.Sleep(5 * time.Millisecond)
+
+	rr := finalizeTestAsice(sessions, prepared.Token, []byte("signature"))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAsicePrepareInvalidRequest(t *testing.T) {
+	sessions := NewAsiceSessionStore(time.Minute)
+	certificate := generateTestCertificate(t, generateTestRSAKey(t))
+
+	for _, body := range []string{
+		`{"signedFiles": [], "certificate": "` + certificate + `"}`,
+		`{"signedFiles": [{"fileName": "test.txt", "encodedFile": "SGVsbG8="}], "certificate": "invalid"}`,
+		`{"signedFiles": [{"fileName": "META-INF/test.txt", "encodedFile": "SGVsbG8="}], "certificate": "` + certificate + `"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/asice/prepare", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		AsicePrepareHandler(sessions)(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
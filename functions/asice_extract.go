@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// maxExtractAllSize limits total size of files returned in one JSON map.
+// Larger containers can still be extracted file by file.
+const maxExtractAllSize = maxContainerSize
+
+// HandleExtractAsiceFileRequest returns the named file of the container or,
+// without file name, all files of the container as JSON map.
+func HandleExtractAsiceFileRequest(w http.ResponseWriter, r *http.Request) {
+	if !isPostMethod(r) {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var asiceExtract requests.AsiceExtract
+	containerBytes, err := readContainerBody(w, r, &asiceExtract, func() string { return asiceExtract.Container })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader, err := openAsice(containerBytes)
+	if err != nil {
+		writeContainerError(w, err)
+		return
+	}
+
+	fileName := asiceFileName(r, asiceExtract.FileName)
+	if fileName == "" {
+		if err := checkExtractAllSize(reader); err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		files := responses.AsiceFiles{Files: map[string]string{}}
+		for _, file := range reader.File {
+			if file.Mode().IsDir() {
+				continue
+			}
+			content, err := readZipFile(file)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			files.Files[file.Name] = base64.StdEncoding.EncodeToString(content)
+		}
+
+		log.Printf("%d files extracted from ASiC-E container", len(files.Files))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(files)
+		return
+	}
+
+	file := findZipFile(reader, fileName)
+	if file == nil || file.Mode().IsDir() {
+		http.Error(w, errAsiceFileNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	content, err := readZipFile(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mediaTypes, err := manifestMediaTypes(reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mimeType := dataFileMimeType(fileName, mediaTypes)
+
+	log.Printf("File %s extracted from ASiC-E container", fileName)
+	if r.URL.Query().Get("type") == "base64" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(responses.AsiceFile{
+			FileName:    fileName,
+			EncodedFile: base64.StdEncoding.EncodeToString(content),
+			MimeType:    mimeType,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(fileName)))
+	if _, err := w.Write(content); err != nil {
+		log.Printf("Error writing extracted file: %v", err)
+	}
+}
+
+// checkExtractAllSize checks that all files of the container fit into a
+// single JSON response.
+func checkExtractAllSize(reader *zip.Reader) error {
+	var total uint64
+	for _, file := range reader.File {
+		total += file.UncompressedSize64
+		if total > maxExtractAllSize {
+			return newContainerError(codeContainerTooLarge, "files of the container are larger than %d bytes, extract them one by one with fileName", maxExtractAllSize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+func HandleInspectAsiceRequest(w http.ResponseWriter, r *http.Request) {
+	if !isPostMethod(r) {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	profile, err := getContainerProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var asiceInspect requests.AsiceInspect
+	containerBytes, err := readContainerBody(w, r, &asiceInspect, func() string { return asiceInspect.Container })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := xadesHashAlgorithm(r, asiceInspect.HashAlgorithm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader, err := openAsice(containerBytes)
+	if err != nil {
+		writeContainerError(w, err)
+		return
+	}
+
+	inspection, err := inspectAsice(reader, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if profile != nil {
+		inspection.Profile = profile.Name
+		inspection.ProfileErrors = []string{}
+		for _, violation := range profile.check(reader) {
+			inspection.ProfileErrors = append(inspection.ProfileErrors, violation.Message)
+		}
+	}
+
+	log.Printf("ASiC-E container inspected, %d data files, %d signatures", len(inspection.DataFiles), len(inspection.Signatures))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inspection)
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// signTestAsice signs the container with /asice/sign handler.
+func signTestAsice(t *testing.T, keys *KeyRegistry, container []byte) []byte {
+	req := httptest.NewRequest(http.MethodPost, "/asice/sign", bytes.NewReader(container))
+	req.Header.Set("Content-Type", asiceMimeType)
+	rr := httptest.NewRecorder()
+	AsiceSigningHandler(keys, nil)(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		t.FailNow()
+	}
+	return rr.Body.Bytes()
+}
+
+func TestHandleInspectAsiceRequest(t *testing.T) {
+	fmt.Println("!!! Starting ASiC-E inspection tests on asice_inspect.go !!!")
+	privateKey := generateTestRSAKey(t)
+	container, err := newAsiceContainer([]requests.SignedFile{
+		{FileName: "test.txt", EncodedFile: base64.StdEncoding.EncodeToString([]byte("Hello, World!"))},
+		{FileName: "folder/data file.bin", EncodedFile: base64.StdEncoding.EncodeToString([]byte{1, 2, 3}), MimeType: "application/x-custom"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container = signTestAsice(t, newTestCmsKeyRegistry(t, privateKey), container)
+
+	body := fmt.Sprintf(`{"container": "%s"}`, base64.StdEncoding.EncodeToString(container))
+	req := httptest.NewRequest(http.MethodPost, "/asice/inspect", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleInspectAsiceRequest(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	var inspection responses.AsiceInspection
+	if err := json.NewDecoder(rr.Body).Decode(&inspection); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, asiceMimeType, inspection.MimeType)
+	if assert.Len(t, inspection.DataFiles, 2) {
+		digest := sha256.Sum256([]byte("Hello, World!"))
+		assert.Equal(t, "test.txt", inspection.DataFiles[0].Name)
+		assert.Equal(t, uint64(13), inspection.DataFiles[0].Size)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(digest[:]), inspection.DataFiles[0].Digest)
+		assert.Equal(t, crypto.SHA256.String(), inspection.DataFiles[0].DigestAlgorithm)
+		assert.Equal(t, "application/x-custom", inspection.DataFiles[1].MimeType)
+	}
+
+	if assert.Len(t, inspection.Signatures, 1) {
+		signature := inspection.Signatures[0]
+		assert.Equal(t, "META-INF/signatures0.xml", signature.File)
+		assert.Equal(t, "S0", signature.Id)
+		assert.Equal(t, xmlRSASignatureMethods[crypto.SHA256], signature.SignatureMethod)
+		assert.NotEmpty(t, signature.SigningTime)
+		assert.Equal(t, []string{"test.txt", "folder/data file.bin"}, signature.SignedFiles)
+		if assert.NotNil(t, signature.Signer) {
+			assert.Contains(t, signature.Signer.Subject, "CN=Test Signer")
+		}
+		assert.Empty(t, signature.Error)
+	}
+}
+
+func TestHandleInspectAsiceRequestBrokenSignature(t *testing.T) {
+	container, err := newAsiceContainer([]requests.SignedFile{{FileName: "test.txt", EncodedFile: "SGVsbG8="}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container, err = writeAsiceWithSignature(reader, "META-INF/signatures0.xml", []byte("<not-closed>"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/inspect?hashAlgorithm=SHA-512", bytes.NewReader(container))
+	req.Header.Set("Content-Type", "application/zip")
+	rr := httptest.NewRecorder()
+	HandleInspectAsiceRequest(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	var inspection responses.AsiceInspection
+	if err := json.NewDecoder(rr.Body).Decode(&inspection); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "SHA-512", inspection.DataFiles[0].DigestAlgorithm)
+	if assert.Len(t, inspection.Signatures, 1) {
+		assert.NotEmpty(t, inspection.Signatures[0].Error)
+	}
+}
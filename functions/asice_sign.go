@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+func AsiceSigningHandler(keys *KeyRegistry, timestamps *TimestampClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !checkTimestampRequest(w, r, timestamps) {
+			return
+		}
+
+		algorithms, err := getKeyAlgorithms(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signingKey, ok := selectKey(w, r, keys, OperationXAdES, algorithms...)
+		if !ok {
+			return
+		}
+
+		certificate, err := keyCertificate(signingKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		options, err := getRSASignatureOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var asiceSign requests.AsiceSign
+		containerBytes, err := readContainerBody(w, r, &asiceSign, func() string { return asiceSign.Container })
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		hash, err := xadesHashAlgorithm(r, asiceSign.HashAlgorithm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signatureMethod, err := xmlSignatureMethod(certificate.PublicKey, hash, options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reader, err := openAsice(containerBytes)
+		if err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		dataFiles, manifestEntries, err := asiceDataObjects(reader, hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signatureName, index := nextSignatureFileName(reader)
+		signature, err := prepareXadesSignature(xadesParameters{
+			SignatureID:     fmt.Sprintf("S%d", index),
+			Certificate:     certificate,
+			Hash:            hash,
+			SignatureMethod: signatureMethod,
+			SigningTime:     time.Now().UTC().Truncate(time.Second),
+			DataFiles:       dataFiles,
+		})
+		if err != nil {
+			log.Printf("Error creating XAdES signature: %s", err)
+			http.Error(w, "Error creating XAdES signature", http.StatusInternalServerError)
+			return
+		}
+
+		signatureValue, err := signDigestWithKey(signingKey, hash, signature.Digest(), options, "P1363")
+		if err != nil {
+			log.Printf("Error signing XAdES signature: %s", err)
+			http.Error(w, "Error creating XAdES signature", http.StatusInternalServerError)
+			return
+		}
+
+		signatureBytes, err := signature.Finalize(signatureValue)
+		if err != nil {
+			log.Printf("Error creating XAdES signature: %s", err)
+			http.Error(w, "Error creating XAdES signature", http.StatusInternalServerError)
+			return
+		}
+
+		if isTimestampRequested(r) {
+			if signatureBytes, err = timestampXadesSignature(signature, timestamps); err != nil {
+				writeTimestampError(w, err)
+				return
+			}
+		}
+
+		signedContainer, err := writeAsiceWithSignature(reader, signatureName, signatureBytes, createManifest(manifestEntries))
+		if err != nil {
+			log.Printf("Error writing signed container: %s", err)
+			http.Error(w, "Error writing signed container", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("XAdES signature %s added to ASiC-E container with key %s", signatureName, signingKey.ID)
+		writeContainerResponse(w, r, signedContainer)
+	}
+}
@@ -0,0 +1,229 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAsice creates ASiC-E container with the data files.
+func newTestAsice(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+
+	mimetype, err := writer.CreateHeader(&zip.FileHeader{Name: asiceMimetypeFile, Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mimetype.Write([]byte(asiceMimeType))
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeZipFile(writer, name, []byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// verifyTestXadesSignature checks references and signature value of the signature file.
+func verifyTestXadesSignature(t *testing.T, reader *zip.Reader, signatureName string, publicKey crypto.PublicKey) *xmlNode {
+	signatureFile := findZipFile(reader, signatureName)
+	if !assert.NotNil(t, signatureFile) {
+		t.FailNow()
+	}
+	content, err := readZipFile(signatureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseXML(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := root.Element(xmlnsDS, "Signature")
+	signedInfo := signature.Element(xmlnsDS, "SignedInfo")
+	for _, reference := range signedInfo.Elements(xmlnsDS, "Reference") {
+		var referenced []byte
+		uri := reference.Attr("URI")
+		if strings.HasPrefix(uri, "#") {
+			referenced, err = canonicalize(root.FindByID(uri[1:]), c14nExclusive, nil)
+			assert.NoError(t, err)
+		} else {
+			name, err := url.PathUnescape(uri)
+			assert.NoError(t, err)
+			file := findZipFile(reader, name)
+			if !assert.NotNil(t, file, uri) {
+				continue
+			}
+			referenced, err = readZipFile(file)
+			assert.NoError(t, err)
+		}
+		digest := crypto.SHA256.New()
+		digest.Write(referenced)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(digest.Sum(nil)), reference.Element(xmlnsDS, "DigestValue").Content())
+	}
+
+	signedInfoBytes, err := canonicalize(signedInfo, c14nExclusive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := crypto.SHA256.New()
+	digest.Write(signedInfoBytes)
+	signatureValue, err := base64.StdEncoding.DecodeString(signature.Element(xmlnsDS, "SignatureValue").Content())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest.Sum(nil), signatureValue))
+	case *ecdsa.PublicKey:
+		size := len(signatureValue) / 2
+		r := new(big.Int).SetBytes(signatureValue[:size])
+		s := new(big.Int).SetBytes(signatureValue[size:])
+		assert.True(t, ecdsa.Verify(key, digest.Sum(nil), r, s))
+	}
+	return root
+}
+
+func TestAsiceSigningHandlerRSA(t *testing.T) {
+	fmt.Println("!!! Starting ASiC-E signing tests on asice_sign.go !!!")
+	privateKey := generateTestRSAKey(t)
+	container := newTestAsice(t, map[string]string{"test.txt": "Hello, World!", "docs/report.pdf": "%PDF-1.4"})
+	body := fmt.Sprintf(`{"container": "%s"}`, base64.StdEncoding.EncodeToString(container))
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/sign", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	AsiceSigningHandler(newTestCmsKeyRegistry(t, privateKey), nil)(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	reader, err := openAsice(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, asiceMimetypeFile, reader.File[0].Name)
+	assert.Equal(t, zip.Store, reader.File[0].Method)
+
+	root := verifyTestXadesSignature(t, reader, "META-INF/signatures0.xml", &privateKey.PublicKey)
+	signedInfo := root.Path(xmlnsDS, "Signature", "SignedInfo")
+	assert.Equal(t, xmlRSASignatureMethods[crypto.SHA256], signedInfo.Element(xmlnsDS, "SignatureMethod").Attr("Algorithm"))
+	assert.Len(t, signedInfo.Elements(xmlnsDS, "Reference"), 3)
+
+	signedProperties := root.FindByID("S0-SignedProperties")
+	assert.NotEmpty(t, signedProperties.Path(xmlnsXAdES, "SignedSignatureProperties", "SigningTime").Content())
+	assert.NotNil(t, signedProperties.Path(xmlnsXAdES, "SignedSignatureProperties", "SigningCertificateV2", "Cert", "IssuerSerialV2"))
+
+	mediaTypes, err := manifestMediaTypes(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/pdf", mediaTypes["docs/report.pdf"])
+	assert.Contains(t, mediaTypes["test.txt"], "text/plain")
+}
+
+func TestAsiceSigningHandlerECDSASecondSignature(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newTestCmsKeyRegistry(t, privateKey)
+	container := newTestAsice(t, map[string]string{"test file.txt": "Hello, World!"})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/asice/sign?type=base64", bytes.NewReader(container))
+		req.Header.Set("Content-Type", asiceMimeType)
+		rr := httptest.NewRecorder()
+		AsiceSigningHandler(keys, nil)(rr, req)
+
+		if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+			return
+		}
+		var response map[string]string
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		container, err = base64.StdEncoding.DecodeString(response["packedAsice"])
+		assert.NoError(t, err)
+	}
+
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := verifyTestXadesSignature(t, reader, "META-INF/signatures1.xml", &privateKey.PublicKey)
+	reference := root.Path(xmlnsDS, "Signature", "SignedInfo", "Reference")
+	assert.Equal(t, "test%20file.txt", reference.Attr("URI"))
+	assert.NotNil(t, findZipFile(reader, "META-INF/signatures0.xml"))
+	assert.NotNil(t, root.FindByID("S1"))
+}
+
+func TestAsiceSigningHandlerInvalidContainer(t *testing.T) {
+	keys := newTestCmsKeyRegistry(t, generateTestRSAKey(t))
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/sign", strings.NewReader(`{"container": "bm90IGEgemlw"}`))
+	rr := httptest.NewRecorder()
+	AsiceSigningHandler(keys, nil)(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	empty := newTestAsice(t, nil)
+	req = httptest.NewRequest(http.MethodPost, "/asice/sign", bytes.NewReader(empty))
+	req.Header.Set("Content-Type", "application/zip")
+	rr = httptest.NewRecorder()
+	AsiceSigningHandler(keys, nil)(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "no data files")
+}
+
+func TestAsiceSigningHandlerRequiresCertificate(t *testing.T) {
+	container := newTestAsice(t, map[string]string{"test.txt": "Hello, World!"})
+	req := httptest.NewRequest(http.MethodPost, "/asice/sign", bytes.NewReader(container))
+	req.Header.Set("Content-Type", "application/zip")
+	rr := httptest.NewRecorder()
+	AsiceSigningHandler(newTestKeyRegistry(t, generateTestRSAKey(t)), nil)(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+func HandleValidateAsiceRequest(w http.ResponseWriter, r *http.Request) {
+	ValidateAsiceHandler(nil)(w, r)
+}
+
+// ValidateAsiceHandler validates signatures of the container. Signer
+// certificates are chained to roots of the trust store.
+func ValidateAsiceHandler(trustStore *x509.CertPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var asiceValidate requests.AsiceValidate
+		containerBytes, err := readContainerBody(w, r, &asiceValidate, func() string { return asiceValidate.Container })
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reader, err := openAsice(containerBytes)
+		if err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		validation := validateAsice(reader, trustStore)
+
+		log.Printf("ASiC-E container validated, %d of %d signatures valid", validation.ValidSignatureCount, validation.SignatureCount)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(validation)
+	}
+}
@@ -0,0 +1,405 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+func newTestSignedAsice(t *testing.T, keys *KeyRegistry) []byte {
+	container, err := newAsiceContainer([]requests.SignedFile{
+		{FileName: "test.txt", EncodedFile: base64.StdEncoding.EncodeToString([]byte("Hello, World!"))},
+		{FileName: "second file.txt", EncodedFile: base64.StdEncoding.EncodeToString([]byte("Second"))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signTestAsice(t, keys, container)
+}
+
+// replaceTestAsiceFile returns copy of the container with the file content replaced,
+// nil content removes the file.
+func replaceTestAsiceFile(t *testing.T, container []byte, name string, content []byte) []byte {
+	reader, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, file := range reader.File {
+		if file.Name != name {
+			assert.NoError(t, writer.Copy(file))
+		} else if content != nil {
+			assert.NoError(t, writeZipFile(writer, name, content))
+		}
+	}
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+// testAsiceSignerTrustStore trusts signer certificates of the container, for
+// tests which check signatures and not the certificate chain.
+func testAsiceSignerTrustStore(t *testing.T, container []byte) *x509.CertPool {
+	trustStore := x509.NewCertPool()
+	reader, err := openAsice(container)
+	if err != nil {
+		return trustStore
+	}
+	for _, file := range reader.File {
+		if !isAsiceSignatureFile(file.Name) {
+			continue
+		}
+		signatures, _ := readAsiceSignatureFile(file)
+		for _, signature := range signatures {
+			if certificate, err := xadesKeyInfoCertificate(signature); err == nil {
+				trustStore.AddCert(certificate)
+			}
+		}
+	}
+	return trustStore
+}
+
+func validateTestAsice(t *testing.T, container []byte) responses.AsiceValidation {
+	return validateTestAsiceWithTrustStore(t, container, testAsiceSignerTrustStore(t, container))
+}
+
+func validateTestAsiceWithTrustStore(t *testing.T, container []byte, trustStore *x509.CertPool) responses.AsiceValidation {
+	body := fmt.Sprintf(`{"container": "%s"}`, base64.StdEncoding.EncodeToString(container))
+	req := httptest.NewRequest(http.MethodPost, "/asice/validate", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	ValidateAsiceHandler(trustStore)(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		t.FailNow()
+	}
+	var validation responses.AsiceValidation
+	if err := json.NewDecoder(rr.Body).Decode(&validation); err != nil {
+		t.Fatal(err)
+	}
+	return validation
+}
+
+func TestHandleValidateAsiceRequestValid(t *testing.T) {
+	fmt.Println("!!! Starting ASiC-E validation tests on asice_validate.go !!!")
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container = signTestAsice(t, newTestCmsKeyRegistry(t, ecKey), container)
+
+	validation := validateTestAsice(t, container)
+	assert.True(t, validation.Valid, validation)
+	assert.Empty(t, validation.ContainerErrors)
+	assert.Equal(t, 2, validation.SignatureCount)
+	assert.Equal(t, 2, validation.ValidSignatureCount)
+	for _, signature := range validation.Signatures {
+		assert.Equal(t, indicationTotalPassed, signature.Indication, signature.Errors)
+		assert.Empty(t, signature.Errors)
+		assert.NotNil(t, signature.Signer)
+		assert.ElementsMatch(t, []string{"test.txt", "second file.txt"}, signature.SignedFiles)
+	}
+}
+
+func TestHandleValidateAsiceRequestChain(t *testing.T) {
+	chain := newTestCertificateChain(t, &x509.Certificate{KeyUsage: x509.KeyUsageContentCommitment})
+	registry := NewKeyRegistry()
+	assert.NoError(t, registry.Add(&SigningKey{
+		ID:          "seal",
+		PrivateKey:  chain.signerKey,
+		Certificate: base64.StdEncoding.EncodeToString(chain.signer.Raw),
+	}))
+	container := newTestSignedAsice(t, registry)
+	trustStore := x509.NewCertPool()
+	trustStore.AddCert(chain.root)
+
+	// Valid signature is indeterminate without trusted root or intermediate
+	for _, trustStore := range []*x509.CertPool{nil, x509.NewCertPool(), trustStore} {
+		validation := validateTestAsiceWithTrustStore(t, container, trustStore)
+		assert.False(t, validation.Valid)
+		assert.Equal(t, indicationIndeterminate, validation.Signatures[0].Indication)
+		assert.Equal(t, subIndicationNoCertificateChain, validation.Signatures[0].SubIndication)
+	}
+
+	// Intermediate from KeyInfo completes the chain
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := readZipFile(findZipFile(reader, "META-INF/signatures0.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := parseXML(signature)
+	intermediate := &xmlNode{Prefix: "ds", Local: "X509Certificate"}
+	intermediate.SetContent(base64.StdEncoding.EncodeToString(chain.intermediate.Raw))
+	root.Path(xmlnsDS, "Signature", "KeyInfo", "X509Data").AppendChild(intermediate)
+	modified, err := serializeXML(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validation := validateTestAsiceWithTrustStore(t, replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", modified), trustStore)
+	assert.True(t, validation.Valid, validation)
+	assert.Equal(t, indicationTotalPassed, validation.Signatures[0].Indication, validation.Signatures[0].Errors)
+}
+
+func TestHandleValidateAsiceRequestModifiedFile(t *testing.T) {
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)))
+	container = replaceTestAsiceFile(t, container, "test.txt", []byte("Hello, World?"))
+
+	validation := validateTestAsice(t, container)
+	assert.False(t, validation.Valid)
+	if assert.Len(t, validation.Signatures, 1) {
+		assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+		assert.Equal(t, subIndicationHashFailure, validation.Signatures[0].SubIndication)
+	}
+}
+
+func TestHandleValidateAsiceRequestRemovedFile(t *testing.T) {
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)))
+	container = replaceTestAsiceFile(t, container, "second file.txt", nil)
+
+	validation := validateTestAsice(t, container)
+	assert.False(t, validation.Valid)
+	assert.Contains(t, validation.ContainerErrors, "manifest lists file second file.txt which is not in container")
+	if assert.Len(t, validation.Signatures, 1) {
+		assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+		assert.Equal(t, subIndicationSignedDataNotFound, validation.Signatures[0].SubIndication)
+	}
+}
+
+func TestHandleValidateAsiceRequestModifiedSignature(t *testing.T) {
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)))
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := readZipFile(findZipFile(reader, "META-INF/signatures0.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseXML(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changed signing time breaks SignedProperties digest
+	signingTime := xadesSignedProperties(root.Element(xmlnsDS, "Signature")).Path(xmlnsXAdES, "SignedSignatureProperties", "SigningTime")
+	signingTime.SetContent("2000-01-01T00:00:00Z")
+	modified, err := serializeXML(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validation := validateTestAsice(t, replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", modified))
+	assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+	assert.Equal(t, subIndicationHashFailure, validation.Signatures[0].SubIndication)
+
+	// Changed signature value
+	root, _ = parseXML(signature)
+	signatureValue := root.Path(xmlnsDS, "Signature", "SignatureValue")
+	value, _ := base64.StdEncoding.DecodeString(signatureValue.Content())
+	value[0] ^= 0xff
+	signatureValue.SetContent(base64.StdEncoding.EncodeToString(value))
+	modified, _ = serializeXML(root)
+	validation = validateTestAsice(t, replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", modified))
+	assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+	assert.Equal(t, subIndicationSigCryptoFailure, validation.Signatures[0].SubIndication)
+
+	// Not a signature document
+	validation = validateTestAsice(t, replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", []byte("<broken")))
+	assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+	assert.Equal(t, subIndicationFormatFailure, validation.Signatures[0].SubIndication)
+}
+
+func TestHandleValidateAsiceRequestECDSADERSignature(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, ecKey))
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := readZipFile(findZipFile(reader, "META-INF/signatures0.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseXML(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same r and s in DER encoding are not accepted in XML signature
+	signatureValue := root.Path(xmlnsDS, "Signature", "SignatureValue")
+	value, _ := base64.StdEncoding.DecodeString(signatureValue.Content())
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(value[:len(value)/2]),
+		S: new(big.Int).SetBytes(value[len(value)/2:]),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureValue.SetContent(base64.StdEncoding.EncodeToString(der))
+	modified, err := serializeXML(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validation := validateTestAsice(t, replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", modified))
+	assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+	assert.Equal(t, subIndicationFormatFailure, validation.Signatures[0].SubIndication)
+}
+
+func TestHandleValidateAsiceRequestWrappedSignedProperties(t *testing.T) {
+	container := newTestSignedAsice(t, newTestCmsKeyRegistry(t, generateTestRSAKey(t)))
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := readZipFile(findZipFile(reader, "META-INF/signatures0.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := parseXML(signature)
+	original, _ := parseXML(signature)
+
+	// Original SignedProperties are moved before the modified ones, so the
+	// reference by Id finds the original element
+	signatureElement := root.Element(xmlnsDS, "Signature")
+	signedProperties := xadesSignedProperties(signatureElement)
+	signedProperties.Path(xmlnsXAdES, "SignedSignatureProperties", "SigningTime").SetContent("2000-01-01T00:00:00Z")
+	signatureElement.Element(xmlnsDS, "KeyInfo").AppendChild(xadesSignedProperties(original.Element(xmlnsDS, "Signature")))
+	modified, err := serializeXML(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validation := validateTestAsice(t, replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", modified))
+	assert.False(t, validation.Valid)
+	if assert.Len(t, validation.Signatures, 1) {
+		assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+		assert.Equal(t, subIndicationFormatFailure, validation.Signatures[0].SubIndication)
+		assert.Contains(t, validation.Signatures[0].Errors[0], "is used by more than one element")
+	}
+
+	// Without duplicate Id the reference does not point to checked properties
+	root, _ = parseXML(signature)
+	original, _ = parseXML(signature)
+	signatureElement = root.Element(xmlnsDS, "Signature")
+	signedProperties = xadesSignedProperties(signatureElement)
+	signedProperties.Attrs = nil
+	signatureElement.Element(xmlnsDS, "KeyInfo").AppendChild(xadesSignedProperties(original.Element(xmlnsDS, "Signature")))
+	modified, _ = serializeXML(root)
+	validation = validateTestAsice(t, replaceTestAsiceFile(t, container, "META-INF/signatures0.xml", modified))
+	assert.Equal(t, indicationTotalFailed, validation.Signatures[0].Indication)
+	assert.Equal(t, subIndicationFormatFailure, validation.Signatures[0].SubIndication)
+}
+
+func TestHandleValidateAsiceRequestIndeterminate(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	keys := newTestCmsKeyRegistry(t, privateKey)
+	key, _ := keys.Get("seal")
+	certificate, err := keyCertificate(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container, err := newAsiceContainer([]requests.SignedFile{{FileName: "test.txt", EncodedFile: "SGVsbG8="}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := openAsice(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFiles, _, err := asiceDataObjects(reader, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signing time after certificate expiration
+	signature, err := prepareXadesSignature(xadesParameters{
+		SignatureID:     "S0",
+		Certificate:     certificate,
+		Hash:            crypto.SHA256,
+		SignatureMethod: xmlRSASignatureMethods[crypto.SHA256],
+		SigningTime:     certificate.NotAfter.Add(time.Hour),
+		DataFiles:       dataFiles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureValue, err := signDigestWithKey(key, crypto.SHA256, signature.Digest(), rsaSignatureOptions{Method: signatureMethodPKCS1v15}, "P1363")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureBytes, err := signature.Finalize(signatureValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container, err = writeAsiceWithSignature(reader, "META-INF/signatures0.xml", signatureBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validation := validateTestAsice(t, container)
+	assert.False(t, validation.Valid)
+	assert.Empty(t, validation.ContainerErrors)
+	if assert.Len(t, validation.Signatures, 1) {
+		assert.Equal(t, indicationIndeterminate, validation.Signatures[0].Indication)
+		assert.Equal(t, subIndicationOutOfBounds, validation.Signatures[0].SubIndication)
+	}
+}
+
+func TestHandleValidateAsiceRequestUnsigned(t *testing.T) {
+	container, err := newAsiceContainer([]requests.SignedFile{{FileName: "test.txt", EncodedFile: "SGVsbG8="}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validation := validateTestAsice(t, container)
+	assert.False(t, validation.Valid)
+	assert.Contains(t, validation.ContainerErrors, "container has no signatures")
+}
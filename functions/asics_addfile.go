@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+func HandleAddFileToAsicsRequest(w http.ResponseWriter, r *http.Request) {
+	AddFileToAsicsHandler(defaultWorkStorage)(w, r)
+}
+
+// AddFileToAsicsHandler adds the data object to ASiC-S container, usually one
+// holding a detached signature or timestamp of that file.
+func AddFileToAsicsHandler(storage *WorkStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		compressionLevel, err := getCompressionLevel(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxContainerSize)
+		var asicsAddFile requests.AsicsAddFile
+		if err := json.NewDecoder(r.Body).Decode(&asicsAddFile); err != nil {
+			log.Printf("Failed to decode JSON: %s", err)
+			http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+			return
+		}
+		req := requests.Request{EmptyAsice: asicsAddFile.Container, SignedFiles: asicsAddFile.SignedFiles}
+
+		asicsReader, err := getEmptyAsiceReader(req)
+		if isContainerError(err) {
+			writeContainerError(w, err)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error reading decoded ASiC-S", http.StatusBadRequest)
+			return
+		}
+		if err := checkAsicsAddedFiles(asicsReader, req.SignedFiles); err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		newAsics, newAsicsWriter, err := storage.newArchive(r.ContentLength)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		defer newAsics.Close()
+		setCompressionLevel(newAsicsWriter, compressionLevel)
+
+		if err := addFilesToArchive(req, asicsReader, newAsicsWriter, nil); err != nil {
+			writeContainerError(w, err)
+			return
+		}
+
+		if err := newAsicsWriter.Close(); err != nil {
+			log.Printf("Error closing newAsicsWriter: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		container, err := newAsics.Reader()
+		if err != nil {
+			log.Printf("Error reading new container: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		log.Println("Provided file added to ASiC-S container")
+		writeContainerStream(w, r, container)
+	}
+}
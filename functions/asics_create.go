@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+func HandleCreateAsicsRequest(w http.ResponseWriter, r *http.Request) {
+	if !isPostMethod(r) {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxContainerSize)
+	var asicsCreate requests.AsicsCreate
+	if err := json.NewDecoder(r.Body).Decode(&asicsCreate); err != nil {
+		log.Printf("Failed to decode JSON: %s", err)
+		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+		return
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(asicsCreate.Signature)
+	if err != nil {
+		http.Error(w, "Failed to decode signature from base64", http.StatusBadRequest)
+		return
+	}
+
+	containerBytes, err := newAsicsContainer(asicsCreate.SignedFiles, signature)
+	if err != nil {
+		writeContainerError(w, err)
+		return
+	}
+
+	log.Println("ASiC-S container created")
+	writeContainerResponse(w, r, containerBytes)
+}
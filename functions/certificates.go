@@ -27,6 +27,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/unknovs/hash-sign/routes/responses"
 )
 
 const logMessage = "%s?key=%s&type=%s responded"
@@ -36,27 +38,47 @@ func writeError(w http.ResponseWriter, status int, message string) {
 	fmt.Fprintf(w, `{"error": "%s"}`, message)
 }
 
+// HandleCertificatesRequest returns certificates from environment only.
 func HandleCertificatesRequest(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
-	key := query.Get("key")
-	certType := query.Get("type")
+	CertificatesHandler(nil)(w, r)
+}
 
-	if !isGetMethod(r) {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
+// CertificatesHandler returns certificates from environment and certificates
+// linked to keys in the registry.
+func CertificatesHandler(keys *KeyRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		key := query.Get("key")
+		keyId := query.Get("keyId")
+		certType := query.Get("type")
 
-	if !certificatesExist() {
-		writeError(w, http.StatusNotFound, "No certificates found in environment")
-		return
-	}
+		if !isGetMethod(r) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
 
-	response, err := getCertificatesResponse(key, certType, r)
-	if err != nil {
-		writeError(w, http.StatusNotFound, err.Error())
-		return
+		if !certificatesExist(keys) {
+			writeError(w, http.StatusNotFound, "No certificates found in environment")
+			return
+		}
+
+		var response responses.CertificatesResponse
+		var err error
+		if keyId != "" {
+			response, err = getKeyCertificateResponse(keys, keyId, r)
+		} else {
+			response, err = getCertificatesResponse(keys, key, certType, r)
+		}
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		writeCertificatesResponse(w, response)
 	}
+}
 
+func writeCertificatesResponse(w http.ResponseWriter, response responses.CertificatesResponse) {
 	jsonBytes, err := json.Marshal(response)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+func CmsSigningHandler(keys *KeyRegistry, timestamps *TimestampClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !checkTimestampRequest(w, r, timestamps) {
+			return
+		}
+
+		algorithms, err := getKeyAlgorithms(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signingKey, ok := selectKey(w, r, keys, OperationCMS, algorithms...)
+		if !ok {
+			return
+		}
+
+		certificate, err := keyCertificate(signingKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		options, err := getRSASignatureOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var hash crypto.Hash
+		var digest []byte
+		if isBinaryRequest(r) {
+			hash, digest, err = hashRequestBody(w, r)
+		} else {
+			var cmsSign requests.CmsSign
+			if err := json.NewDecoder(r.Body).Decode(&cmsSign); err != nil {
+				log.Printf("Failed to decode JSON: %s", err)
+				http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+				return
+			}
+			hash, digest, err = requestDigest(cmsSign.Digest, cmsSign.Content, cmsSign.HashAlgorithm)
+		}
+		if err == nil {
+			err = options.check(signingKey.PrivateKey.Public(), hash)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		signingTime := time.Now().UTC().Truncate(time.Second)
+		signedData, err := createSignedData(cmsSignerParameters{
+			Key:         signingKey,
+			Certificate: certificate,
+			Hash:        hash,
+			Digest:      digest,
+			ContentType: oidData,
+			SigningTime: signingTime,
+			RSAOptions:  options,
+		})
+		if err != nil {
+			log.Printf("Error creating CMS signature: %s", err)
+			http.Error(w, "Error creating CMS signature", http.StatusInternalServerError)
+			return
+		}
+
+		if isTimestampRequested(r) {
+			if signedData, err = addSignatureTimestamp(signedData, timestamps); err != nil {
+				writeTimestampError(w, err)
+				return
+			}
+		}
+
+		log.Printf("CMS signature created with key %s", signingKey.ID)
+		writeCmsResponse(w, r, signedData, signingKey, hash, signingTime)
+	}
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCmsKeyRegistry(t *testing.T, privateKey crypto.Signer) *KeyRegistry {
+	registry := NewKeyRegistry()
+	err := registry.Add(&SigningKey{ID: "seal", PrivateKey: privateKey, Certificate: generateTestCertificate(t, privateKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+// parseTestSignedData returns SignedData and DER encoded signed attributes as signed
+func parseTestSignedData(t *testing.T, der []byte) (cmsSignedData, []byte) {
+	var contentInfo cmsContentInfo
+	_, err := asn1.Unmarshal(der, &contentInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, contentInfo.ContentType.Equal(oidSignedData))
+
+	var signedData cmsSignedData
+	_, err = asn1.Unmarshal(contentInfo.Content.Bytes, &signedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedAttributes := signedData.SignerInfos[0].SignedAttrs.FullBytes
+	signedAttributesSet := append([]byte{0x31}, signedAttributes[1:]...)
+	return signedData, signedAttributesSet
+}
+
+func TestCmsSigningHandlerRSA(t *testing.T) {
+	fmt.Println("!!! Starting CMS signing tests on cms.go !!!")
+	privateKey := generateTestRSAKey(t)
+	content := []byte("Hello, World!")
+	digest := sha256.Sum256(content)
+	body := fmt.Sprintf(`{"digest": "%s"}`, base64.StdEncoding.EncodeToString(digest[:]))
+
+	req := httptest.NewRequest(http.MethodPost, "/cms/sign", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	CmsSigningHandler(newTestCmsKeyRegistry(t, privateKey), nil)(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/pkcs7-signature", rr.Header().Get("Content-Type"))
+
+	signedData, signedAttributes := parseTestSignedData(t, rr.Body.Bytes())
+	assert.True(t, signedData.EncapContentInfo.EContentType.Equal(oidData))
+	assert.Empty(t, signedData.EncapContentInfo.EContent.Bytes)
+
+	signerInfo := signedData.SignerInfos[0]
+	assert.True(t, signerInfo.DigestAlgorithm.Algorithm.Equal(oidSHA256))
+
+	// Signed attributes contain message digest of the content
+	assert.Contains(t, string(signedAttributes), string(digest[:]))
+
+	attributesDigest := sha256.Sum256(signedAttributes)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, attributesDigest[:], signerInfo.Signature))
+}
+
+func TestCmsSigningHandlerECDSAContent(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	content := []byte("Hello, World!")
+	body := fmt.Sprintf(`{"content": "%s", "hashAlgorithm": "SHA-384"}`, base64.StdEncoding.EncodeToString(content))
+
+	req := httptest.NewRequest(http.MethodPost, "/cms/sign?type=pem", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	CmsSigningHandler(newTestCmsKeyRegistry(t, privateKey), nil)(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "-----BEGIN CMS-----"))
+}
+
+func TestCmsSigningHandlerWithoutCertificate(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/cms/sign", strings.NewReader(`{"digest": "aGFzaA=="}`))
+	rr := httptest.NewRecorder()
+	CmsSigningHandler(newTestKeyRegistry(t, privateKey), nil)(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -25,12 +25,18 @@ package functions
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 
 	"github.com/unknovs/hash-sign/routes/requests"
 )
@@ -54,31 +60,207 @@ func getEmptyAsiceReader(req requests.Request) (*zip.Reader, error) {
 	emptyAsiceReader, err := zip.NewReader(bytes.NewReader(SignedEmptyAsiceBytes), int64(len(SignedEmptyAsiceBytes)))
 	if err != nil {
 		log.Printf("Error reading decoded ASic-E: %s", err.Error())
+		return nil, err
 	}
-	return emptyAsiceReader, err
+	return emptyAsiceReader, checkContainerEntries(emptyAsiceReader)
 }
 
-func createNewAsiceFile() (*os.File, *zip.Writer, error) {
-	newAsiceFile, err := os.CreateTemp("", "newZip")
-	if err != nil {
-		log.Printf("Error creating temporary file for new ASiC-E file: %v", err)
-		return nil, nil, err
+// addFilesToArchive writes mimetype, the request files and entries of the
+// container. With a profile manifest is updated to list the added files.
+func addFilesToArchive(req requests.Request, emptyAsiceReader *zip.Reader, newAsiceWriter *zip.Writer, profile *containerProfile) error {
+	if err := addMimetypeToArchive(emptyAsiceReader, newAsiceWriter); err != nil {
+		return err
 	}
 
-	newAsiceWriter := zip.NewWriter(newAsiceFile)
-	return newAsiceFile, newAsiceWriter, nil
-}
-
-func addFilesToArchive(req requests.Request, emptyAsiceReader *zip.Reader, newAsiceWriter *zip.Writer) error {
+	names := newFileNameSet(emptyAsiceReader)
 	for _, file := range req.SignedFiles {
 		signedFile := requests.SignedFile(file) // Convert File to SignedFile
+		if err := names.add(signedFile.FileName); err != nil {
+			return err
+		}
 		if err := addFileToArchive(newAsiceWriter, signedFile); err != nil {
 			return err
 		}
 	}
 
+	return addEntriesToArchive(req, emptyAsiceReader, newAsiceWriter, profile)
+}
+
+// addJSONFilesToArchive adds files of JSON request with base64 encoded
+// container and files.
+func addJSONFilesToArchive(r *http.Request, newAsiceWriter *zip.Writer, profile *containerProfile) error {
+	req, err := decodeRequest(r)
+	if err != nil {
+		return errors.New("can't decode request")
+	}
+
+	emptyAsiceReader, err := getEmptyAsiceReader(req)
+	if isContainerError(err) {
+		return err
+	}
+	if err != nil {
+		return errors.New("error reading decoded ASiC-E")
+	}
+
+	return addFilesToArchive(req, emptyAsiceReader, newAsiceWriter, profile)
+}
+
+// isMultipartRequest checks if container and files are sent as multipart/form-data.
+func isMultipartRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// addMultipartFilesToArchive streams files of multipart/form-data request into
+// the new container. emptyAsice part shall come first, as mimetype of the
+// container is written before the files, it is kept in work storage for
+// reading. Each signedFiles part is copied straight to the zip writer.
+func addMultipartFilesToArchive(r *http.Request, storage *WorkStorage, newAsiceWriter *zip.Writer, profile *containerProfile) error {
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("failed to read multipart body: %w", err)
+	}
+
+	part, err := multipartReader.NextPart()
+	if err != nil {
+		return fmt.Errorf("failed to read multipart body: %w", err)
+	}
+	if part.FormName() != "emptyAsice" {
+		return errors.New("first part shall be emptyAsice")
+	}
+
+	emptyAsiceFile, err := storage.newBuffer(r.ContentLength)
+	if err != nil {
+		return err
+	}
+	defer emptyAsiceFile.Close()
+
+	if _, err := io.Copy(emptyAsiceFile, part); err != nil {
+		return fmt.Errorf("failed to read emptyAsice: %w", err)
+	}
+	emptyAsiceReader, err := zip.NewReader(emptyAsiceFile, emptyAsiceFile.Size())
+	if err != nil {
+		log.Printf("Error reading ASiC-E: %v", err)
+		return errors.New("error reading ASiC-E")
+	}
+	if err := checkContainerEntries(emptyAsiceReader); err != nil {
+		return err
+	}
+
+	if err := addMimetypeToArchive(emptyAsiceReader, newAsiceWriter); err != nil {
+		return err
+	}
+
+	// Added files are kept without content for manifest entries
+	var req requests.Request
+	names := newFileNameSet(emptyAsiceReader)
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read multipart body: %w", err)
+		}
+		if part.FormName() != "signedFiles" {
+			return fmt.Errorf("unexpected part %s", part.FormName())
+		}
+
+		signedFile := requests.SignedFile{FileName: multipartFileName(part), MimeType: multipartMimeType(part)}
+		if err := names.add(signedFile.FileName); err != nil {
+			return err
+		}
+		if err := addFileToArchiveFromStream(newAsiceWriter, signedFile.FileName, part); err != nil {
+			return err
+		}
+		req.SignedFiles = append(req.SignedFiles, signedFile)
+	}
+
+	return addEntriesToArchive(req, emptyAsiceReader, newAsiceWriter, profile)
+}
+
+// multipartFileName returns filename of the part with folders, part.FileName
+// keeps only the base name.
+func multipartFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// multipartMimeType returns Content-Type of the part, empty for generic binary
+// content so that type is detected from file extension.
+func multipartMimeType(part *multipart.Part) string {
+	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil || mediaType == "application/octet-stream" {
+		return ""
+	}
+	return mediaType
+}
+
+// addMimetypeToArchive writes mimetype of the container first and stored, as
+// the container spec requires. Mimetype already stored that way is copied.
+func addMimetypeToArchive(emptyAsiceReader *zip.Reader, newAsiceWriter *zip.Writer) error {
+	mimetypeFile := findZipFile(emptyAsiceReader, asiceMimetypeFile)
+	if mimetypeFile == nil {
+		return nil
+	}
+	if isStoredMimetype(mimetypeFile) {
+		return addFileToArchiveFromReader(newAsiceWriter, mimetypeFile)
+	}
+	mimetype, err := readZipFile(mimetypeFile)
+	if err != nil {
+		log.Printf("Error reading mimetype: %v", err)
+		return err
+	}
+	if err := writeMimetype(newAsiceWriter, strings.TrimSpace(string(mimetype))); err != nil {
+		log.Printf("Error writing mimetype: %v", err)
+		return err
+	}
+	return nil
+}
+
+// isStoredMimetype checks if mimetype entry is stored without compression,
+// extra field and data descriptor, with no whitespace around the content.
+func isStoredMimetype(file *zip.File) bool {
+	if file.Method != zip.Store || len(file.Extra) > 0 || file.Flags&0x8 != 0 {
+		return false
+	}
+	content, err := readZipFile(file)
+	return err == nil && strings.TrimSpace(string(content)) == string(content)
+}
+
+// getCompressionLevel returns deflate level of added files from
+// compressionLevel query parameter, 0 is no compression and 9 is best.
+func getCompressionLevel(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("compressionLevel")
+	if value == "" {
+		return flate.DefaultCompression, nil
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < flate.NoCompression || level > flate.BestCompression {
+		return 0, errors.New("invalid 'compressionLevel' parameter, use 0 to 9")
+	}
+	return level, nil
+}
+
+// setCompressionLevel sets deflate level of files created in the archive.
+// Copied entries keep their compression.
+func setCompressionLevel(archive *zip.Writer, level int) {
+	archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+}
+
+// addEntriesToArchive copies entries of the container after the added files.
+// With a profile manifest is written again listing the added files.
+func addEntriesToArchive(req requests.Request, emptyAsiceReader *zip.Reader, newAsiceWriter *zip.Writer, profile *containerProfile) error {
 	for _, file := range emptyAsiceReader.File {
-		if file.Mode().IsDir() {
+		if file.Mode().IsDir() || file.Name == asiceMimetypeFile {
+			continue
+		}
+		if profile != nil && file.Name == asiceManifestFile {
 			continue
 		}
 
@@ -86,44 +268,36 @@ func addFilesToArchive(req requests.Request, emptyAsiceReader *zip.Reader, newAs
 			return err
 		}
 	}
+
+	if profile != nil {
+		return addManifestEntries(req, emptyAsiceReader, newAsiceWriter)
+	}
 	return nil
 }
 
-func writeResponse(w http.ResponseWriter, r *http.Request, newAsiceFile *os.File) error {
-	newAsiceFileBytes, err := os.ReadFile(newAsiceFile.Name())
+// addManifestEntries writes manifest of the container with the added files.
+func addManifestEntries(req requests.Request, emptyAsiceReader *zip.Reader, newAsiceWriter *zip.Writer) error {
+	existing, err := manifestEntries(emptyAsiceReader)
 	if err != nil {
-		log.Printf("Error reading newAsiceFile: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Error reading manifest: %v", err)
 		return err
 	}
 
-	fileType := r.URL.Query().Get("type")
-	log.Println("Provided files added to ASiC-E container")
-
-	if fileType == "binary" {
-		w.Header().Set("Content-Type", "application/zip")
-		_, err := w.Write(newAsiceFileBytes)
-		if err != nil {
-			log.Printf("Error writing newAsiceFileBytes: %v", err)
+	var entries []asiceManifestEntry
+	for _, entry := range existing {
+		if entry.FullPath != "/" {
+			entries = append(entries, entry)
 		}
-		return err
-	} else if fileType == "base64" {
-		response := base64.StdEncoding.EncodeToString(newAsiceFileBytes)
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(map[string]interface{}{
-			"packedAsice": response,
-		})
-		if err != nil {
-			log.Printf("Error encoding response: %v", err)
-		}
-		return err
-	} else {
-		_, err := w.Write(newAsiceFileBytes)
-		if err != nil {
-			log.Printf("Error writing newAsiceFileBytes: %v", err)
+	}
+	for _, file := range req.SignedFiles {
+		mediaType := file.MimeType
+		if mediaType == "" {
+			mediaType = dataFileMimeType(file.FileName, nil)
 		}
-		return err
+		entries = append(entries, asiceManifestEntry{FullPath: file.FileName, MediaType: mediaType})
 	}
+
+	return writeZipFile(newAsiceWriter, asiceManifestFile, createManifest(entries))
 }
 
 func addFileToArchive(archive *zip.Writer, file requests.SignedFile) error {
@@ -148,29 +322,32 @@ func addFileToArchive(archive *zip.Writer, file requests.SignedFile) error {
 	return nil
 }
 
+// addFileToArchiveFromReader copies the entry without recompression, so its
+// bytes, compression method, modification time, comment and extra fields stay
+// unchanged.
 func addFileToArchiveFromReader(archive *zip.Writer, file *zip.File) error {
 	if file.Mode().IsDir() {
 		return nil
 	}
 
-	newFileWriter, err := archive.Create(file.Name)
-	if err != nil {
-		log.Printf("Error creating new file in ASiC-E archive: %v", err)
+	if err := archive.Copy(file); err != nil {
+		log.Printf("Error copying file %s to new ASiC-E archive: %v", file.Name, err)
 		return err
 	}
+	return nil
+}
 
-	fileReader, err := file.Open()
+// addFileToArchiveFromStream copies the file content to the archive as it is read.
+func addFileToArchiveFromStream(archive *zip.Writer, name string, content io.Reader) error {
+	newFileWriter, err := archive.Create(name)
 	if err != nil {
-		log.Printf("Error opening file in ASiC-E archive: %v", err)
+		log.Printf("Error creating new file %s in the ASiC-E archive: %v", name, err)
 		return err
 	}
-	defer fileReader.Close()
 
-	_, err = io.Copy(newFileWriter, fileReader)
-	if err != nil {
-		log.Printf("Error copying file to new ASiC-E archive: %v", err)
+	if err := copyLimited(name, newFileWriter, content); err != nil {
+		log.Printf("Error writing file %s to the ASiC-E archive: %v", name, err)
 		return err
 	}
-
 	return nil
 }
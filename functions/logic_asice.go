@@ -0,0 +1,446 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+const (
+	asiceMimeType     = "application/vnd.etsi.asic-e+zip"
+	asiceMimetypeFile = "mimetype"
+	asiceManifestFile = "META-INF/manifest.xml"
+	maxContainerSize  = 64 << 20
+
+	xmlnsManifest = "urn:oasis:names:tc:opendocument:xmlns:manifest:1.0"
+)
+
+// asiceManifestEntry is a file-entry of META-INF/manifest.xml.
+type asiceManifestEntry struct {
+	FullPath  string
+	MediaType string
+}
+
+// isContainerRequest checks if the container is sent as binary body.
+func isContainerRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/octet-stream", "application/zip", asiceMimeType, asicsMimeType:
+		return true
+	default:
+		return false
+	}
+}
+
+// readContainerBody reads the container from binary body or from the base64
+// field of JSON body decoded into request.
+func readContainerBody(w http.ResponseWriter, r *http.Request, request interface{}, encodedContainer func() string) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxContainerSize)
+
+	if isContainerRequest(r) {
+		containerBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read container: %w", err)
+		}
+		return containerBytes, nil
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		log.Printf("Failed to decode JSON: %s", err)
+		return nil, errors.New("failed to decode JSON")
+	}
+	containerBytes, err := base64.StdEncoding.DecodeString(encodedContainer())
+	if err != nil {
+		return nil, errors.New("failed to decode container from base64")
+	}
+	return containerBytes, nil
+}
+
+// openAsice opens the container and checks its mimetype.
+func openAsice(containerBytes []byte) (*zip.Reader, error) {
+	return openContainer(containerBytes, asiceMimeType)
+}
+
+// openContainer opens ASiC container and checks that its mimetype is mimeType.
+func openContainer(containerBytes []byte, mimeType string) (*zip.Reader, error) {
+	reader, err := zip.NewReader(bytes.NewReader(containerBytes), int64(len(containerBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container: %w", err)
+	}
+	if err := checkContainerEntries(reader); err != nil {
+		return nil, err
+	}
+	if err := checkContainerMimetype(reader, mimeType); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// checkContainerMimetype checks that the container has mimetype file with the
+// mimetype.
+func checkContainerMimetype(reader *zip.Reader, mimeType string) error {
+	mimetypeFile := findZipFile(reader, asiceMimetypeFile)
+	if mimetypeFile == nil {
+		return errors.New("container has no mimetype file")
+	}
+	mimetype, err := readZipFile(mimetypeFile)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(mimetype)) != mimeType {
+		return fmt.Errorf("unsupported container mimetype %s", mimetype)
+	}
+	return nil
+}
+
+func findZipFile(reader *zip.Reader, name string) *zip.File {
+	for _, file := range reader.File {
+		if file.Name == name {
+			return file
+		}
+	}
+	return nil
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer fileReader.Close()
+
+	content, err := io.ReadAll(fileReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	return content, nil
+}
+
+// hashZipFile calculates digest of the file content.
+func hashZipFile(file *zip.File, hash crypto.Hash) ([]byte, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer fileReader.Close()
+
+	h := hash.New()
+	if _, err := io.Copy(h, fileReader); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	return h.Sum(nil), nil
+}
+
+// isAsiceDataFile checks if the entry is a data file, not mimetype or metadata.
+func isAsiceDataFile(name string) bool {
+	return name != asiceMimetypeFile && !strings.HasPrefix(name, "META-INF/") && !strings.HasSuffix(name, "/")
+}
+
+// isAsiceSignatureFile checks if the entry is a XAdES signature file.
+func isAsiceSignatureFile(name string) bool {
+	if !strings.HasPrefix(name, "META-INF/") || strings.Contains(name[len("META-INF/"):], "/") {
+		return false
+	}
+	return strings.Contains(name, "signatures") && strings.HasSuffix(name, ".xml")
+}
+
+func asiceDataFiles(reader *zip.Reader) []*zip.File {
+	var files []*zip.File
+	for _, file := range reader.File {
+		if isAsiceDataFile(file.Name) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// nextSignatureFileName returns the first unused META-INF/signatures<n>.xml
+// name and its index.
+func nextSignatureFileName(reader *zip.Reader) (string, int) {
+	for n := 0; ; n++ {
+		name := fmt.Sprintf("META-INF/signatures%d.xml", n)
+		if findZipFile(reader, name) == nil {
+			return name, n
+		}
+	}
+}
+
+// asiceDataObjects returns digests and media types of the container data files.
+func asiceDataObjects(reader *zip.Reader, hash crypto.Hash) ([]xadesDataFile, []asiceManifestEntry, error) {
+	mediaTypes, err := manifestMediaTypes(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dataFiles []xadesDataFile
+	var entries []asiceManifestEntry
+	for _, file := range asiceDataFiles(reader) {
+		digest, err := hashZipFile(file, hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		mimeType := dataFileMimeType(file.Name, mediaTypes)
+		dataFiles = append(dataFiles, xadesDataFile{Name: file.Name, MimeType: mimeType, Digest: digest})
+		entries = append(entries, asiceManifestEntry{FullPath: file.Name, MediaType: mimeType})
+	}
+
+	if len(dataFiles) == 0 {
+		return nil, nil, errors.New("container has no data files")
+	}
+	return dataFiles, entries, nil
+}
+
+// manifestEntries returns file entries of the manifest in document order.
+func manifestEntries(reader *zip.Reader) ([]asiceManifestEntry, error) {
+	manifestFile := findZipFile(reader, asiceManifestFile)
+	if manifestFile == nil {
+		return nil, nil
+	}
+	content, err := readZipFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseXML(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	var entries []asiceManifestEntry
+	for _, entry := range root.Elements(xmlnsManifest, "file-entry") {
+		entries = append(entries, asiceManifestEntry{
+			FullPath:  entry.AttrNS(xmlnsManifest, "full-path"),
+			MediaType: entry.AttrNS(xmlnsManifest, "media-type"),
+		})
+	}
+	return entries, nil
+}
+
+// manifestMediaTypes returns media types of files listed in the manifest.
+func manifestMediaTypes(reader *zip.Reader) (map[string]string, error) {
+	entries, err := manifestEntries(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaTypes := map[string]string{}
+	for _, entry := range entries {
+		mediaTypes[entry.FullPath] = entry.MediaType
+	}
+	return mediaTypes, nil
+}
+
+// dataFileMimeType returns media type from the manifest or by file extension.
+func dataFileMimeType(name string, mediaTypes map[string]string) string {
+	if mediaType := mediaTypes[name]; mediaType != "" {
+		return mediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// createManifest writes OpenDocument manifest for the container.
+func createManifest(entries []asiceManifestEntry) []byte {
+	var manifest strings.Builder
+	manifest.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	fmt.Fprintf(&manifest, `<manifest:manifest xmlns:manifest="%s" manifest:version="1.2">`+"\n", xmlnsManifest)
+	fmt.Fprintf(&manifest, `<manifest:file-entry manifest:full-path="/" manifest:media-type="%s"/>`+"\n", asiceMimeType)
+	for _, entry := range entries {
+		fmt.Fprintf(&manifest, `<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`+"\n",
+			xmlEscape(entry.FullPath), xmlEscape(entry.MediaType))
+	}
+	manifest.WriteString(`</manifest:manifest>` + "\n")
+	return []byte(manifest.String())
+}
+
+// writeMimetype writes mimetype entry stored without compression, extra field
+// and data descriptor. It shall be the first entry of the container.
+func writeMimetype(writer *zip.Writer, mimeType string) error {
+	content := []byte(mimeType)
+	mimetypeWriter, err := writer.CreateRaw(&zip.FileHeader{
+		Name:               asiceMimetypeFile,
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(len(content)),
+		UncompressedSize64: uint64(len(content)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create mimetype: %w", err)
+	}
+	if _, err := mimetypeWriter.Write(content); err != nil {
+		return fmt.Errorf("failed to write mimetype: %w", err)
+	}
+	return nil
+}
+
+// newAsiceContainer writes container with mimetype, the data files and
+// manifest listing every file with its MIME type.
+func newAsiceContainer(files []requests.SignedFile) ([]byte, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no files provided")
+	}
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+
+	if err := writeMimetype(writer, asiceMimeType); err != nil {
+		return nil, err
+	}
+
+	names := fileNameSet{}
+	var entries []asiceManifestEntry
+	for _, file := range files {
+		if err := names.add(file.FileName); err != nil {
+			return nil, err
+		}
+
+		content, err := base64.StdEncoding.DecodeString(file.EncodedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode file %s from base64", file.FileName)
+		}
+		if err := writeZipFile(writer, file.FileName, content); err != nil {
+			return nil, err
+		}
+		mediaType := file.MimeType
+		if mediaType == "" {
+			mediaType = dataFileMimeType(file.FileName, nil)
+		}
+		entries = append(entries, asiceManifestEntry{FullPath: file.FileName, MediaType: mediaType})
+	}
+
+	if err := writeZipFile(writer, asiceManifestFile, createManifest(entries)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeAsiceWithSignature copies the container entries without changes and
+// adds the signature file. Manifest is added if container has none.
+func writeAsiceWithSignature(reader *zip.Reader, signatureName string, signature []byte, manifest []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+
+	if err := writeMimetype(writer, asiceMimeType); err != nil {
+		return nil, err
+	}
+	for _, file := range reader.File {
+		if file.Name == asiceMimetypeFile || file.Mode().IsDir() {
+			continue
+		}
+		if err := writer.Copy(file); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", file.Name, err)
+		}
+	}
+
+	if findZipFile(reader, asiceManifestFile) == nil {
+		if err := writeZipFile(writer, asiceManifestFile, manifest); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeZipFile(writer, signatureName, signature); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeZipFile(writer *zip.Writer, name string, content []byte) error {
+	fileWriter, err := writer.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if _, err := fileWriter.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeContainerResponse writes the container as binary body or, if type is
+// base64, as JSON with packedAsice property.
+func writeContainerResponse(w http.ResponseWriter, r *http.Request, containerBytes []byte) error {
+	return writeContainerStream(w, r, bytes.NewReader(containerBytes))
+}
+
+// writeContainerStream copies the container to the response without reading
+// it into memory, base64 JSON is encoded while copying.
+func writeContainerStream(w http.ResponseWriter, r *http.Request, container io.Reader) error {
+	var err error
+
+	switch r.URL.Query().Get("type") {
+	case "base64":
+		w.Header().Set("Content-Type", "application/json")
+		err = writeBase64JSON(w, "packedAsice", container)
+	case "binary":
+		w.Header().Set("Content-Type", "application/zip")
+		_, err = io.Copy(w, container)
+	default:
+		_, err = io.Copy(w, container)
+	}
+
+	if err != nil {
+		log.Printf("Error writing container response: %v", err)
+	}
+	return err
+}
+
+// writeBase64JSON writes JSON object with a single base64 encoded property,
+// same as json.Encoder would, while reading the content.
+func writeBase64JSON(w io.Writer, property string, content io.Reader) error {
+	if _, err := fmt.Fprintf(w, `{"%s":"`, property); err != nil {
+		return err
+	}
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.Copy(encoder, content); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\"}\n")
+	return err
+}
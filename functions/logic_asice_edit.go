@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var errAsiceFileNotFound = errors.New("file not found in container")
+
+// signedFileError refuses change of a data file covered by signatures.
+type signedFileError struct {
+	FileName   string
+	Signatures []string
+}
+
+func (e *signedFileError) Error() string {
+	return fmt.Sprintf("file %s is signed by %s, set dropSignatures to remove the signatures", e.FileName, strings.Join(e.Signatures, ", "))
+}
+
+// signatureRemoval is a signature file change when signatures covering a data
+// file are dropped. Content is the signature file without the dropped
+// signatures, nil if the whole file is removed.
+type signatureRemoval struct {
+	File    string
+	Dropped []string
+	Content []byte
+}
+
+// signaturesCoveringFile returns changes of signature files with a signature
+// referencing the data file. Signature file is removed when all its signatures
+// cover the file, otherwise only those signatures are removed from it.
+// Signature files which can't be read are removed as well, as the files they
+// cover are unknown.
+func signaturesCoveringFile(reader *zip.Reader, name string) ([]signatureRemoval, error) {
+	var removals []signatureRemoval
+	for _, file := range reader.File {
+		if !isAsiceSignatureFile(file.Name) {
+			continue
+		}
+
+		signatures, err := readAsiceSignatureFile(file)
+		if err != nil {
+			removals = append(removals, signatureRemoval{File: file.Name, Dropped: []string{file.Name}})
+			continue
+		}
+		var covering []*xmlNode
+		for _, signature := range signatures {
+			if slices.Contains(xadesSignedFiles(signature), name) {
+				covering = append(covering, signature)
+			}
+		}
+		switch {
+		case len(covering) == 0:
+			continue
+		case len(covering) == len(signatures):
+			removals = append(removals, signatureRemoval{File: file.Name, Dropped: []string{file.Name}})
+			continue
+		}
+
+		root := signatures[0].Parent
+		removal := signatureRemoval{File: file.Name}
+		for _, signature := range covering {
+			root.Children = slices.DeleteFunc(root.Children, func(child *xmlNode) bool { return child == signature })
+			removal.Dropped = append(removal.Dropped, file.Name+"#"+signature.Attr("Id"))
+		}
+		if removal.Content, err = serializeXML(root); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+		removals = append(removals, removal)
+	}
+	return removals, nil
+}
+
+// asiceReplacement is new content of the data file.
+type asiceReplacement struct {
+	Content   []byte
+	MediaType string
+}
+
+// editAsiceDataFile removes the data file from the container or, if
+// replacement is set, replaces its content. Manifest is written again for the
+// remaining data files. Signatures covering the file are removed only with
+// dropSignatures, otherwise signedFileError is returned. Dropped signatures are
+// returned as signature file names, or as file#Id for signatures removed from
+// a signature file with other signatures.
+func editAsiceDataFile(reader *zip.Reader, name string, replacement *asiceReplacement, dropSignatures bool) ([]byte, []string, error) {
+	target := findZipFile(reader, name)
+	if target == nil || !isAsiceDataFile(name) {
+		return nil, nil, errAsiceFileNotFound
+	}
+	if replacement == nil && len(asiceDataFiles(reader)) == 1 {
+		return nil, nil, errors.New("can't remove the only data file of the container")
+	}
+
+	removals, err := signaturesCoveringFile(reader, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dropped []string
+	rewritten := map[string][]byte{}
+	for _, removal := range removals {
+		dropped = append(dropped, removal.Dropped...)
+		rewritten[removal.File] = removal.Content
+	}
+	if len(dropped) > 0 && !dropSignatures {
+		return nil, nil, &signedFileError{FileName: name, Signatures: dropped}
+	}
+
+	mediaTypes, err := manifestMediaTypes(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if replacement != nil && replacement.MediaType != "" {
+		mediaTypes[name] = replacement.MediaType
+	}
+	var entries []asiceManifestEntry
+	for _, file := range asiceDataFiles(reader) {
+		if file.Name == name && replacement == nil {
+			continue
+		}
+		entries = append(entries, asiceManifestEntry{FullPath: file.Name, MediaType: dataFileMimeType(file.Name, mediaTypes)})
+	}
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	if err := writeMimetype(writer, asiceMimeType); err != nil {
+		return nil, nil, err
+	}
+
+	manifestWritten := false
+	for _, file := range reader.File {
+		content, changed := rewritten[file.Name]
+		switch {
+		case file.Name == asiceMimetypeFile || file.Mode().IsDir() || changed && content == nil:
+			continue
+		case changed:
+			err = writeZipFile(writer, file.Name, content)
+		case file.Name == asiceManifestFile:
+			if manifestWritten {
+				continue
+			}
+			err = writeZipFile(writer, asiceManifestFile, createManifest(entries))
+			manifestWritten = true
+		case file.Name == name:
+			if replacement == nil {
+				continue
+			}
+			err = writeZipFile(writer, name, replacement.Content)
+		default:
+			err = writer.Copy(file)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to write %s: %w", file.Name, err)
+		}
+	}
+	if !manifestWritten {
+		if err := writeZipFile(writer, asiceManifestFile, createManifest(entries)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buffer.Bytes(), dropped, nil
+}
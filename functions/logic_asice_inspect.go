@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// readAsiceSignatureFile parses the signature file and returns its ds:Signature
+// elements. Root can be asic:XAdESSignatures or a single ds:Signature.
+func readAsiceSignatureFile(file *zip.File) ([]*xmlNode, error) {
+	content, err := readZipFile(file)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseXML(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if root.Local == "Signature" && root.Namespace() == xmlnsDS {
+		return []*xmlNode{root}, nil
+	}
+	signatures := root.Elements(xmlnsDS, "Signature")
+	if len(signatures) == 0 {
+		return nil, errors.New("no signatures found in signature file")
+	}
+	return signatures, nil
+}
+
+// xadesKeyInfoCertificate returns the signer certificate from KeyInfo.
+func xadesKeyInfoCertificate(signature *xmlNode) (*x509.Certificate, error) {
+	certificateElement := signature.Path(xmlnsDS, "KeyInfo", "X509Data", "X509Certificate")
+	if certificateElement == nil {
+		return nil, errors.New("signer certificate not found in KeyInfo")
+	}
+	return parseCertificate(strings.Join(strings.Fields(certificateElement.Content()), ""))
+}
+
+// xadesKeyInfoIntermediates returns certificates of KeyInfo other than the
+// signer certificate. Certificates which can't be parsed are skipped.
+func xadesKeyInfoIntermediates(signature *xmlNode) []*x509.Certificate {
+	x509Data := signature.Path(xmlnsDS, "KeyInfo", "X509Data")
+	if x509Data == nil {
+		return nil
+	}
+	elements := x509Data.Elements(xmlnsDS, "X509Certificate")
+	if len(elements) < 2 {
+		return nil
+	}
+	var intermediates []*x509.Certificate
+	for _, element := range elements[1:] {
+		if certificate, err := parseCertificate(strings.Join(strings.Fields(element.Content()), "")); err == nil {
+			intermediates = append(intermediates, certificate)
+		}
+	}
+	return intermediates
+}
+
+// xadesSignedProperties returns SignedProperties of the signature.
+func xadesSignedProperties(signature *xmlNode) *xmlNode {
+	for _, object := range signature.Elements(xmlnsDS, "Object") {
+		if signedProperties := object.Path(xmlnsXAdES, "QualifyingProperties", "SignedProperties"); signedProperties != nil {
+			return signedProperties
+		}
+	}
+	return nil
+}
+
+// xadesSigningTime returns claimed signing time from SignedProperties.
+func xadesSigningTime(signature *xmlNode) string {
+	signedProperties := xadesSignedProperties(signature)
+	if signedProperties == nil {
+		return ""
+	}
+	signingTime := signedProperties.Path(xmlnsXAdES, "SignedSignatureProperties", "SigningTime")
+	if signingTime == nil {
+		return ""
+	}
+	return strings.TrimSpace(signingTime.Content())
+}
+
+// isSignedPropertiesReference checks if the reference points to SignedProperties.
+func isSignedPropertiesReference(reference *xmlNode) bool {
+	return reference.Attr("Type") == xadesSignedPropertiesType
+}
+
+// xadesReferenceFileName returns container file name of the data file reference.
+func xadesReferenceFileName(reference *xmlNode) (string, error) {
+	uri := reference.Attr("URI")
+	if uri == "" || strings.HasPrefix(uri, "#") {
+		return "", errors.New("reference does not point to a file")
+	}
+	return url.PathUnescape(uri)
+}
+
+// xadesSignedFiles returns names of the files covered by the signature.
+func xadesSignedFiles(signature *xmlNode) []string {
+	files := []string{}
+	signedInfo := signature.Element(xmlnsDS, "SignedInfo")
+	if signedInfo == nil {
+		return files
+	}
+	for _, reference := range signedInfo.Elements(xmlnsDS, "Reference") {
+		if isSignedPropertiesReference(reference) {
+			continue
+		}
+		if name, err := xadesReferenceFileName(reference); err == nil {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+func certificateInfo(certificate *x509.Certificate) *responses.CertificateInfo {
+	return &responses.CertificateInfo{
+		Subject:      certificate.Subject.String(),
+		Issuer:       certificate.Issuer.String(),
+		SerialNumber: certificate.SerialNumber.String(),
+		NotBefore:    certificate.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:     certificate.NotAfter.UTC().Format(time.RFC3339),
+	}
+}
+
+// inspectAsice describes data files and signatures of the container.
+func inspectAsice(reader *zip.Reader, hash crypto.Hash) (responses.AsiceInspection, error) {
+	inspection := responses.AsiceInspection{
+		MimeType:   asiceMimeType,
+		DataFiles:  []responses.AsiceDataFile{},
+		Signatures: []responses.AsiceSignatureInfo{},
+	}
+
+	mediaTypes, err := manifestMediaTypes(reader)
+	if err != nil {
+		return inspection, err
+	}
+
+	for _, file := range asiceDataFiles(reader) {
+		digest, err := hashZipFile(file, hash)
+		if err != nil {
+			return inspection, err
+		}
+		inspection.DataFiles = append(inspection.DataFiles, responses.AsiceDataFile{
+			Name:            file.Name,
+			Size:            file.UncompressedSize64,
+			MimeType:        mediaTypes[file.Name],
+			Digest:          base64.StdEncoding.EncodeToString(digest),
+			DigestAlgorithm: hash.String(),
+		})
+	}
+
+	for _, file := range reader.File {
+		if !isAsiceSignatureFile(file.Name) {
+			continue
+		}
+
+		signatures, err := readAsiceSignatureFile(file)
+		if err != nil {
+			inspection.Signatures = append(inspection.Signatures, responses.AsiceSignatureInfo{
+				File:        file.Name,
+				Format:      "XAdES",
+				SignedFiles: []string{},
+				Error:       err.Error(),
+			})
+			continue
+		}
+
+		for _, signature := range signatures {
+			inspection.Signatures = append(inspection.Signatures, xadesSignatureInfo(file.Name, signature))
+		}
+	}
+
+	return inspection, nil
+}
+
+// xadesSignatureInfo describes ds:Signature element of the signature file.
+func xadesSignatureInfo(fileName string, signature *xmlNode) responses.AsiceSignatureInfo {
+	info := responses.AsiceSignatureInfo{
+		File:        fileName,
+		Format:      "XAdES",
+		Id:          signature.Attr("Id"),
+		SigningTime: xadesSigningTime(signature),
+		SignedFiles: xadesSignedFiles(signature),
+	}
+	if signatureMethod := signature.Path(xmlnsDS, "SignedInfo", "SignatureMethod"); signatureMethod != nil {
+		info.SignatureMethod = signatureMethod.Attr("Algorithm")
+	}
+	if certificate, err := xadesKeyInfoCertificate(signature); err == nil {
+		info.Signer = certificateInfo(certificate)
+	} else {
+		info.Error = err.Error()
+	}
+	return info
+}
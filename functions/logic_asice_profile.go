@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// containerProfile names the output file extension of the container. Latvian
+// eDoc and Estonian BDOC are packaged the same way, so every profile is checked
+// with the same strict ASiC-E packaging rules. Rules specific to eDoc or BDOC,
+// like required signature formats, are not checked.
+type containerProfile struct {
+	Name      string
+	Extension string
+}
+
+var containerProfiles = map[string]*containerProfile{
+	"edoc": {Name: "edoc", Extension: ".edoc"},
+	"bdoc": {Name: "bdoc", Extension: ".bdoc"},
+}
+
+var profileSignatureFile = regexp.MustCompile(`^META-INF/signatures[0-9]+\.xml$`)
+
+// profileViolation is a broken profile rule. Repairable violations are fixed
+// by rewriting mimetype and manifest.
+type profileViolation struct {
+	Message    string
+	Repairable bool
+}
+
+// getContainerProfile returns profile from profile query parameter, nil for
+// generic ASiC-E container.
+func getContainerProfile(r *http.Request) (*containerProfile, error) {
+	name := strings.ToLower(r.URL.Query().Get("profile"))
+	if name == "" || name == "asice" {
+		return nil, nil
+	}
+	profile, ok := containerProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid 'profile' parameter, use 'asice', 'edoc' or 'bdoc'")
+	}
+	return profile, nil
+}
+
+// isRepairRequested checks repair query parameter.
+func isRepairRequested(r *http.Request) bool {
+	return r.URL.Query().Get("repair") == "true"
+}
+
+// check returns packaging rules broken by the container.
+func (p *containerProfile) check(reader *zip.Reader) []profileViolation {
+	var violations []profileViolation
+	repairable := func(format string, args ...interface{}) {
+		violations = append(violations, profileViolation{Message: fmt.Sprintf(format, args...), Repairable: true})
+	}
+	refused := func(format string, args ...interface{}) {
+		violations = append(violations, profileViolation{Message: fmt.Sprintf(format, args...)})
+	}
+
+	mimetypeFile := findZipFile(reader, asiceMimetypeFile)
+	if mimetypeFile == nil {
+		repairable("mimetype not found")
+	} else {
+		if mimetype, err := readZipFile(mimetypeFile); err != nil || string(mimetype) != asiceMimeType {
+			repairable("mimetype shall be %s", asiceMimeType)
+		}
+		if reader.File[0] != mimetypeFile || mimetypeFile.Method != zip.Store || len(mimetypeFile.Extra) > 0 {
+			repairable("mimetype shall be the first entry stored without compression and extra field")
+		}
+	}
+
+	names := map[string]bool{}
+	for _, file := range reader.File {
+		if names[file.Name] {
+			refused("file %s is in container more than once", file.Name)
+		}
+		names[file.Name] = true
+
+		switch {
+		case file.Name == asiceMimetypeFile || file.Name == asiceManifestFile || file.Mode().IsDir():
+		case strings.HasPrefix(file.Name, "META-INF/"):
+			if !profileSignatureFile.MatchString(file.Name) {
+				refused("file %s is not allowed in META-INF, only manifest.xml and signatures<n>.xml", file.Name)
+			}
+		case strings.Contains(file.Name, "/"):
+			refused("data file %s shall be in root folder of the container", file.Name)
+		}
+	}
+
+	if findZipFile(reader, asiceManifestFile) == nil {
+		repairable("META-INF/manifest.xml not found")
+		return violations
+	}
+	entries, err := manifestEntries(reader)
+	if err != nil {
+		repairable("%v", err)
+		return violations
+	}
+
+	listed := map[string]bool{}
+	rootListed := false
+	for _, entry := range entries {
+		if entry.FullPath == "/" {
+			rootListed = entry.MediaType == asiceMimeType
+			continue
+		}
+		if listed[entry.FullPath] {
+			repairable("manifest lists file %s more than once", entry.FullPath)
+		}
+		listed[entry.FullPath] = true
+		if findZipFile(reader, entry.FullPath) == nil {
+			repairable("manifest lists file %s which is not in container", entry.FullPath)
+		}
+		if entry.MediaType == "" {
+			repairable("manifest has no media type for file %s", entry.FullPath)
+		}
+	}
+	if !rootListed {
+		repairable("manifest shall list container root with media type %s", asiceMimeType)
+	}
+	for _, file := range asiceDataFiles(reader) {
+		if !listed[file.Name] {
+			repairable("file %s is not listed in manifest", file.Name)
+		}
+	}
+
+	return violations
+}
+
+// repair rewrites mimetype and manifest of the container. Other entries are
+// copied without changes, so existing signatures stay valid.
+func (p *containerProfile) repair(reader *zip.Reader) ([]byte, error) {
+	mediaTypes, err := manifestMediaTypes(reader)
+	if err != nil {
+		mediaTypes = map[string]string{}
+	}
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	if err := writeMimetype(writer, asiceMimeType); err != nil {
+		return nil, err
+	}
+
+	var entries []asiceManifestEntry
+	for _, file := range reader.File {
+		if file.Name == asiceMimetypeFile || file.Name == asiceManifestFile || file.Mode().IsDir() {
+			continue
+		}
+		if err := writer.Copy(file); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", file.Name, err)
+		}
+		if isAsiceDataFile(file.Name) {
+			entries = append(entries, asiceManifestEntry{FullPath: file.Name, MediaType: dataFileMimeType(file.Name, mediaTypes)})
+		}
+	}
+
+	if err := writeZipFile(writer, asiceManifestFile, createManifest(entries)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// applyContainerProfile checks the container against the profile. If repair
+// is set and all violations are repairable, repaired container is returned.
+// Otherwise violations which refuse the container are returned.
+func applyContainerProfile(containerBytes []byte, profile *containerProfile, repair bool) ([]byte, []string, error) {
+	if profile == nil {
+		return containerBytes, nil, nil
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(containerBytes), int64(len(containerBytes)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read container: %w", err)
+	}
+	if len(reader.File) == 0 {
+		return nil, []string{"container is empty"}, nil
+	}
+
+	violations := profile.check(reader)
+	if len(violations) == 0 {
+		return containerBytes, nil, nil
+	}
+
+	var messages []string
+	canRepair := repair
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+		if !violation.Repairable {
+			canRepair = false
+		}
+	}
+	if !canRepair {
+		return nil, messages, nil
+	}
+
+	repaired, err := profile.repair(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return repaired, nil, nil
+}
+
+// profileError formats violations for the error response.
+func profileError(profile *containerProfile, violations []string) string {
+	return fmt.Sprintf("Container does not match %s profile: %s", profile.Name, strings.Join(violations, "; "))
+}
+
+// setContainerFileName names the binary response with profile file extension.
+func setContainerFileName(w http.ResponseWriter, r *http.Request, profile *containerProfile) {
+	if profile != nil && r.URL.Query().Get("type") != "base64" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="container%s"`, profile.Extension))
+	}
+}
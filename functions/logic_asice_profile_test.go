@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// newTestLooseAsice creates container with compressed mimetype and without manifest.
+func newTestLooseAsice(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	assert.NoError(t, writeZipFile(writer, asiceMimetypeFile, []byte(asiceMimeType)))
+	assert.NoError(t, writeZipFile(writer, "existing.txt", []byte("Existing")))
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestCreateAsiceWithProfile(t *testing.T) {
+	fmt.Println("!!! Starting container profile tests on logic_asice_profile.go !!!")
+	body := `{"signedFiles": [{"fileName": "test.txt", "encodedFile": "SGVsbG8="}]}`
+	req := httptest.NewRequest(http.MethodPost, "/asice/create?profile=edoc", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleCreateAsiceRequest(rr, req)
+
+	if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		assert.Equal(t, `attachment; filename="container.edoc"`, rr.Header().Get("Content-Disposition"))
+		reader, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		assert.NoError(t, err)
+		assert.Empty(t, containerProfiles["edoc"].check(reader))
+	}
+
+	// Data files in folders are not allowed
+	body = `{"signedFiles": [{"fileName": "folder/test.txt", "encodedFile": "SGVsbG8="}]}`
+	req = httptest.NewRequest(http.MethodPost, "/asice/create?profile=bdoc&repair=true", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleCreateAsiceRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "shall be in root folder")
+
+	req = httptest.NewRequest(http.MethodPost, "/asice/create?profile=unknown", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleCreateAsiceRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestAddFileWithProfile(t *testing.T) {
+	body := fmt.Sprintf(`{"emptyAsice": "%s", "signedFiles": [{"fileName": "test.txt", "encodedFile": "SGVsbG8="}]}`,
+		base64.StdEncoding.EncodeToString(newTestLooseAsice(t)))
+
+	// Container with compressed mimetype is refused without repair
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile?profile=bdoc", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Container does not match bdoc profile")
+
+	req = httptest.NewRequest(http.MethodPost, "/asice/addFile?profile=bdoc&repair=true", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	assertMimetypeEntry(t, rr.Body.Bytes(), asiceMimeType)
+	reader, err := openAsice(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, containerProfiles["bdoc"].check(reader))
+	mediaTypes, err := manifestMediaTypes(reader)
+	assert.NoError(t, err)
+	assert.Contains(t, mediaTypes, "existing.txt")
+	assert.Contains(t, mediaTypes, "test.txt")
+}
+
+func TestAddFileWithProfileRefused(t *testing.T) {
+	container := newTestLooseAsice(t)
+	reader, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations := containerProfiles["edoc"].check(reader)
+	assert.NotEmpty(t, violations)
+	for _, violation := range violations {
+		assert.True(t, violation.Repairable, violation.Message)
+	}
+
+	// Duplicate file name can't be repaired
+	body := fmt.Sprintf(`{"emptyAsice": "%s", "signedFiles": [{"fileName": "existing.txt", "encodedFile": "SGVsbG8="}]}`,
+		base64.StdEncoding.EncodeToString(container))
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile?profile=edoc&repair=true", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "more than once")
+}
+
+func TestInspectAsiceWithProfile(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/asice/inspect?profile=edoc", bytes.NewReader(newTestLooseAsice(t)))
+	req.Header.Set("Content-Type", "application/zip")
+	rr := httptest.NewRecorder()
+	HandleInspectAsiceRequest(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	var inspection responses.AsiceInspection
+	if err := json.NewDecoder(rr.Body).Decode(&inspection); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "edoc", inspection.Profile)
+	assert.Contains(t, inspection.ProfileErrors, "META-INF/manifest.xml not found")
+}
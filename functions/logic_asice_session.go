@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAsiceSessionTTL = 5 * time.Minute
+	maxAsiceSessions       = 1000
+)
+
+var errAsiceSessionLimit = errors.New("too many signing sessions in progress")
+
+// asiceSession holds a container waiting for an external signature value.
+type asiceSession struct {
+	Container       []byte
+	SignatureName   string
+	SignatureID     string
+	Document        []byte
+	Certificate     *x509.Certificate
+	Hash            crypto.Hash
+	RSAOptions      rsaSignatureOptions
+	ManifestEntries []asiceManifestEntry
+	ExpiresAt       time.Time
+}
+
+// AsiceSessionStore keeps prepared signatures in memory until they are
+// finalized or expire.
+type AsiceSessionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*asiceSession
+}
+
+// NewAsiceSessionStore creates a store, sessions expire after ttl. Default TTL
+// is used if ttl is not positive.
+func NewAsiceSessionStore(ttl time.Duration) *AsiceSessionStore {
+	if ttl <= 0 {
+		ttl = defaultAsiceSessionTTL
+	}
+	return &AsiceSessionStore{ttl: ttl, sessions: map[string]*asiceSession{}}
+}
+
+// Add stores the session and returns its token.
+func (s *AsiceSessionStore) Add(session *asiceSession) (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired(time.Now())
+	if len(s.sessions) >= maxAsiceSessions {
+		return "", errAsiceSessionLimit
+	}
+	session.ExpiresAt = time.Now().Add(s.ttl)
+	s.sessions[token] = session
+	return token, nil
+}
+
+// Take removes the session from the store and returns it, if it exists and has
+// not expired. Only one caller can take the session, concurrent finalizations
+// with the same token can't both succeed.
+func (s *AsiceSessionStore) Take(token string) (*asiceSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, false
+	}
+	delete(s.sessions, token)
+	if time.Now().After(session.ExpiresAt) {
+		return nil, false
+	}
+	return session, true
+}
+
+// Restore puts back the session taken with Take when finalization fails, so
+// the token can be used again until the session expires.
+func (s *AsiceSessionStore) Restore(token string, session *asiceSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().After(session.ExpiresAt) {
+		return
+	}
+	s.sessions[token] = session
+}
+
+func (s *AsiceSessionStore) removeExpired(now time.Time) {
+	for token, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, token)
+		}
+	}
+}
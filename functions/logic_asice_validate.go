@@ -0,0 +1,532 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// Validation indications and sub-indications, named as in ETSI EN 319 102-1.
+const (
+	indicationTotalPassed   = "TOTAL_PASSED"
+	indicationIndeterminate = "INDETERMINATE"
+	indicationTotalFailed   = "TOTAL_FAILED"
+
+	subIndicationFormatFailure        = "FORMAT_FAILURE"
+	subIndicationHashFailure          = "HASH_FAILURE"
+	subIndicationSigCryptoFailure     = "SIG_CRYPTO_FAILURE"
+	subIndicationSignedDataNotFound   = "SIGNED_DATA_NOT_FOUND"
+	subIndicationNoSigningCertificate = "NO_SIGNING_CERTIFICATE_FOUND"
+	subIndicationCryptoConstraints    = "CRYPTO_CONSTRAINTS_FAILURE"
+	subIndicationOutOfBounds          = "OUT_OF_BOUNDS_NO_POE"
+	subIndicationNotYetValid          = "NOT_YET_VALID"
+	subIndicationNoCertificateChain   = "NO_CERTIFICATE_CHAIN_FOUND"
+)
+
+const xmlDSigEnvelopedTransform = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+
+// xmlSignatureAlgorithm describes an XML signature algorithm URI.
+type xmlSignatureAlgorithm struct {
+	KeyAlgorithm string
+	Hash         crypto.Hash
+	PSS          bool
+}
+
+var xmlSignatureAlgorithms = func() map[string]xmlSignatureAlgorithm {
+	algorithms := map[string]xmlSignatureAlgorithm{}
+	for hash, uri := range xmlRSASignatureMethods {
+		algorithms[uri] = xmlSignatureAlgorithm{KeyAlgorithm: KeyAlgorithmRSA, Hash: hash}
+	}
+	for hash, uri := range xmlRSAPSSSignatureMethods {
+		algorithms[uri] = xmlSignatureAlgorithm{KeyAlgorithm: KeyAlgorithmRSA, Hash: hash, PSS: true}
+	}
+	for hash, uri := range xmlECDSASignatureMethods {
+		algorithms[uri] = xmlSignatureAlgorithm{KeyAlgorithm: KeyAlgorithmECDSA, Hash: hash}
+	}
+	return algorithms
+}()
+
+var xmlDigestAlgorithms = func() map[string]crypto.Hash {
+	algorithms := map[string]crypto.Hash{}
+	for hash, uri := range xmlDigestMethods {
+		algorithms[uri] = hash
+	}
+	return algorithms
+}()
+
+// signatureVerdict collects result of one signature validation. Failure
+// overrides indeterminate result, first reason of the result sets sub-indication.
+type signatureVerdict struct {
+	Indication    string
+	SubIndication string
+	Errors        []string
+	Warnings      []string
+}
+
+func (v *signatureVerdict) fail(subIndication, format string, args ...interface{}) {
+	if v.Indication != indicationTotalFailed {
+		v.Indication = indicationTotalFailed
+		v.SubIndication = subIndication
+	}
+	v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+}
+
+func (v *signatureVerdict) indeterminate(subIndication, format string, args ...interface{}) {
+	if v.Indication == indicationTotalPassed {
+		v.Indication = indicationIndeterminate
+		v.SubIndication = subIndication
+	}
+	v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+}
+
+func (v *signatureVerdict) warn(format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, fmt.Sprintf(format, args...))
+}
+
+// documentRoot returns root element of the document holding the node.
+func documentRoot(n *xmlNode) *xmlNode {
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return n
+}
+
+// canonicalizationMethod returns algorithm of the CanonicalizationMethod or
+// Transform element and the exclusive canonicalization prefix list.
+func canonicalizationMethod(element *xmlNode) (string, []string) {
+	var prefixes []string
+	if inclusiveNamespaces := element.Element(c14nExclusive, "InclusiveNamespaces"); inclusiveNamespaces != nil {
+		prefixes = strings.Fields(inclusiveNamespaces.Attr("PrefixList"))
+	}
+	return element.Attr("Algorithm"), prefixes
+}
+
+// decodeXMLBase64 decodes base64 content which can contain line breaks.
+func decodeXMLBase64(element *xmlNode) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(element.Content()), ""))
+}
+
+// referenceDigest calculates digest of the referenced content. Container files
+// are hashed while they are read, without reading them into memory. Same
+// document references are canonicalized, inclusive canonicalization is used if
+// the reference has no canonicalization transform.
+func referenceDigest(reader *zip.Reader, signature, reference *xmlNode, hash crypto.Hash, verdict *signatureVerdict) ([]byte, bool) {
+	uri := reference.Attr("URI")
+	transforms := []*xmlNode{}
+	if transformsElement := reference.Element(xmlnsDS, "Transforms"); transformsElement != nil {
+		transforms = transformsElement.Elements(xmlnsDS, "Transform")
+	}
+
+	if !strings.HasPrefix(uri, "#") {
+		name, err := xadesReferenceFileName(reference)
+		if err != nil {
+			verdict.fail(subIndicationFormatFailure, "invalid reference URI %q", uri)
+			return nil, false
+		}
+		if len(transforms) > 0 {
+			verdict.indeterminate(subIndicationFormatFailure, "transforms of file reference %s are not supported", name)
+			return nil, false
+		}
+		file := findZipFile(reader, name)
+		if file == nil {
+			verdict.fail(subIndicationSignedDataNotFound, "signed file %s not found in container", name)
+			return nil, false
+		}
+		digest, err := hashZipFile(file, hash)
+		if err != nil {
+			verdict.fail(subIndicationSignedDataNotFound, "signed file %s can't be read: %v", name, err)
+			return nil, false
+		}
+		return digest, true
+	}
+
+	element := documentRoot(signature).FindByID(uri[1:])
+	if element == nil {
+		verdict.fail(subIndicationSignedDataNotFound, "referenced element %s not found", uri)
+		return nil, false
+	}
+
+	method, prefixes := c14n10, []string(nil)
+	for _, transform := range transforms {
+		algorithm, transformPrefixes := canonicalizationMethod(transform)
+		switch algorithm {
+		case xmlDSigEnvelopedTransform:
+			verdict.indeterminate(subIndicationFormatFailure, "enveloped signature transform of reference %s is not supported", uri)
+			return nil, false
+		case c14nExclusive, c14nExclusiveWithComments, c14n10, c14n10WithComments, c14n11, c14n11WithComments:
+			method, prefixes = algorithm, transformPrefixes
+		default:
+			verdict.indeterminate(subIndicationFormatFailure, "transform %s of reference %s is not supported", algorithm, uri)
+			return nil, false
+		}
+	}
+
+	content, err := canonicalize(element, method, prefixes)
+	if err != nil {
+		verdict.indeterminate(subIndicationFormatFailure, "reference %s: %v", uri, err)
+		return nil, false
+	}
+	h := hash.New()
+	h.Write(content)
+	return h.Sum(nil), true
+}
+
+// checkReferences compares digests of all references with the referenced
+// content. Signature shall reference signed properties and at least one file.
+func checkReferences(reader *zip.Reader, signature, signedInfo *xmlNode, verdict *signatureVerdict) {
+	references := signedInfo.Elements(xmlnsDS, "Reference")
+	signedProperties := xadesSignedProperties(signature)
+
+	signedPropertiesReferenced := false
+	filesReferenced := 0
+	for _, reference := range references {
+		uri := reference.Attr("URI")
+		if isSignedPropertiesReference(reference) {
+			// Digested element shall be the checked SignedProperties of this signature
+			if signedProperties == nil || signedProperties.Attr("Id") == "" || uri != "#"+signedProperties.Attr("Id") ||
+				documentRoot(signature).FindByID(uri[1:]) != signedProperties {
+				verdict.fail(subIndicationFormatFailure, "SignedProperties reference %s does not point to signature properties", uri)
+				continue
+			}
+			signedPropertiesReferenced = true
+		} else if !strings.HasPrefix(uri, "#") {
+			filesReferenced++
+		}
+
+		digestMethod := reference.Element(xmlnsDS, "DigestMethod")
+		digestValue := reference.Element(xmlnsDS, "DigestValue")
+		if digestMethod == nil || digestValue == nil {
+			verdict.fail(subIndicationFormatFailure, "reference %s has no digest", uri)
+			continue
+		}
+		hash, ok := xmlDigestAlgorithms[digestMethod.Attr("Algorithm")]
+		if !ok {
+			verdict.indeterminate(subIndicationCryptoConstraints, "digest algorithm %s of reference %s is not supported", digestMethod.Attr("Algorithm"), uri)
+			continue
+		}
+		expected, err := decodeXMLBase64(digestValue)
+		if err != nil {
+			verdict.fail(subIndicationFormatFailure, "digest of reference %s is not base64", uri)
+			continue
+		}
+
+		digest, ok := referenceDigest(reader, signature, reference, hash, verdict)
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(digest, expected) {
+			verdict.fail(subIndicationHashFailure, "digest of reference %s does not match", uri)
+		}
+	}
+
+	if !signedPropertiesReferenced {
+		verdict.fail(subIndicationFormatFailure, "SignedProperties are not signed")
+	}
+	if filesReferenced == 0 {
+		verdict.fail(subIndicationSignedDataNotFound, "signature does not reference any file")
+	}
+}
+
+// checkSignatureValue verifies signature value over canonicalized SignedInfo.
+func checkSignatureValue(signature, signedInfo *xmlNode, certificate *x509.Certificate, verdict *signatureVerdict) {
+	canonicalizationElement := signedInfo.Element(xmlnsDS, "CanonicalizationMethod")
+	signatureMethodElement := signedInfo.Element(xmlnsDS, "SignatureMethod")
+	signatureValueElement := signature.Element(xmlnsDS, "SignatureValue")
+	if canonicalizationElement == nil || signatureMethodElement == nil || signatureValueElement == nil {
+		verdict.fail(subIndicationFormatFailure, "SignedInfo or SignatureValue is not complete")
+		return
+	}
+
+	algorithm, ok := xmlSignatureAlgorithms[signatureMethodElement.Attr("Algorithm")]
+	if !ok {
+		verdict.indeterminate(subIndicationCryptoConstraints, "signature algorithm %s is not supported", signatureMethodElement.Attr("Algorithm"))
+		return
+	}
+
+	method, prefixes := canonicalizationMethod(canonicalizationElement)
+	signedInfoBytes, err := canonicalize(signedInfo, method, prefixes)
+	if err != nil {
+		verdict.indeterminate(subIndicationFormatFailure, "SignedInfo: %v", err)
+		return
+	}
+	signatureValue, err := decodeXMLBase64(signatureValueElement)
+	if err != nil {
+		verdict.fail(subIndicationFormatFailure, "signature value is not base64")
+		return
+	}
+
+	h := algorithm.Hash.New()
+	h.Write(signedInfoBytes)
+	digest := h.Sum(nil)
+
+	switch publicKey := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm.KeyAlgorithm != KeyAlgorithmRSA {
+			verdict.fail(subIndicationSigCryptoFailure, "signature algorithm does not match RSA signer certificate")
+		} else if algorithm.PSS {
+			err = rsa.VerifyPSS(publicKey, algorithm.Hash, digest, signatureValue, &rsa.PSSOptions{SaltLength: algorithm.Hash.Size(), Hash: algorithm.Hash})
+		} else {
+			err = rsa.VerifyPKCS1v15(publicKey, algorithm.Hash, digest, signatureValue)
+		}
+	case *ecdsa.PublicKey:
+		// XML signatures carry ECDSA value as r||s, DER is not allowed
+		keyBytes := (publicKey.Params().BitSize + 7) >> 3
+		if algorithm.KeyAlgorithm != KeyAlgorithmECDSA {
+			verdict.fail(subIndicationSigCryptoFailure, "signature algorithm does not match ECDSA signer certificate")
+		} else if len(signatureValue) != 2*keyBytes {
+			verdict.fail(subIndicationFormatFailure, "ECDSA signature value shall be r||s (P1363) of %d bytes", 2*keyBytes)
+			return
+		} else {
+			err = verifyECDSASignatureFormat(publicKey, digest, signatureValue, "P1363")
+		}
+	default:
+		verdict.indeterminate(subIndicationCryptoConstraints, "signer public key type %T is not supported", certificate.PublicKey)
+		return
+	}
+	if err != nil {
+		verdict.fail(subIndicationSigCryptoFailure, "signature value verification failed")
+	}
+}
+
+// checkSignedProperties checks signing certificate reference and signing time
+// against the signer certificate.
+func checkSignedProperties(signature *xmlNode, certificate *x509.Certificate, verdict *signatureVerdict) {
+	signedProperties := xadesSignedProperties(signature)
+	if signedProperties == nil {
+		verdict.fail(subIndicationFormatFailure, "SignedProperties not found")
+		return
+	}
+	signatureProperties := signedProperties.Element(xmlnsXAdES, "SignedSignatureProperties")
+	if signatureProperties == nil {
+		verdict.fail(subIndicationFormatFailure, "SignedSignatureProperties not found")
+		return
+	}
+
+	signingCertificate := signatureProperties.Element(xmlnsXAdES, "SigningCertificateV2")
+	if signingCertificate == nil {
+		signingCertificate = signatureProperties.Element(xmlnsXAdES, "SigningCertificate")
+	}
+	if signingCertificate == nil {
+		verdict.indeterminate(subIndicationNoSigningCertificate, "signing certificate reference not found in SignedProperties")
+	} else {
+		matched := false
+		for _, cert := range signingCertificate.Elements(xmlnsXAdES, "Cert") {
+			certDigest := cert.Element(xmlnsXAdES, "CertDigest")
+			if certDigest == nil {
+				continue
+			}
+			digestMethod := certDigest.Element(xmlnsDS, "DigestMethod")
+			digestValue := certDigest.Element(xmlnsDS, "DigestValue")
+			if digestMethod == nil || digestValue == nil {
+				continue
+			}
+			hash, ok := xmlDigestAlgorithms[digestMethod.Attr("Algorithm")]
+			expected, err := decodeXMLBase64(digestValue)
+			if !ok || err != nil {
+				continue
+			}
+			h := hash.New()
+			h.Write(certificate.Raw)
+			if bytes.Equal(h.Sum(nil), expected) {
+				matched = true
+			}
+		}
+		if !matched {
+			verdict.indeterminate(subIndicationNoSigningCertificate, "signing certificate reference does not match certificate in KeyInfo")
+		}
+	}
+
+	signingTimeElement := signatureProperties.Element(xmlnsXAdES, "SigningTime")
+	if signingTimeElement == nil {
+		verdict.warn("signing time not found")
+		return
+	}
+	signingTime, err := time.Parse(time.RFC3339, strings.TrimSpace(signingTimeElement.Content()))
+	if err != nil {
+		verdict.warn("signing time can't be parsed")
+		return
+	}
+	if signingTime.Before(certificate.NotBefore) {
+		verdict.indeterminate(subIndicationNotYetValid, "signer certificate is not valid at signing time")
+	} else if signingTime.After(certificate.NotAfter) {
+		verdict.indeterminate(subIndicationOutOfBounds, "signer certificate is expired at signing time")
+	}
+}
+
+// validateXadesSignature validates one signature of the container. Signature
+// is TOTAL_PASSED only if signer certificate chains to the trust store.
+func validateXadesSignature(reader *zip.Reader, signature *xmlNode, trustStore *x509.CertPool) (*x509.Certificate, signatureVerdict) {
+	verdict := signatureVerdict{Indication: indicationTotalPassed, Errors: []string{}, Warnings: []string{}}
+
+	signedInfo := signature.Element(xmlnsDS, "SignedInfo")
+	if signedInfo == nil {
+		verdict.fail(subIndicationFormatFailure, "SignedInfo not found")
+		return nil, verdict
+	}
+
+	// Same Id on several elements lets a reference digest other element than
+	// the one that is checked
+	if id := documentRoot(signature).DuplicateID(); id != "" {
+		verdict.fail(subIndicationFormatFailure, "Id %s is used by more than one element", id)
+		return nil, verdict
+	}
+
+	checkReferences(reader, signature, signedInfo, &verdict)
+
+	certificate, err := xadesKeyInfoCertificate(signature)
+	if err != nil {
+		verdict.indeterminate(subIndicationNoSigningCertificate, "%v", err)
+		return nil, verdict
+	}
+
+	checkSignatureValue(signature, signedInfo, certificate, &verdict)
+	checkSignedProperties(signature, certificate, &verdict)
+
+	options := verifyOptions{Intermediates: xadesKeyInfoIntermediates(signature), ValidationTime: time.Now()}
+	if chain := checkChain(certificate, options, trustStore); chain.Status != responses.CheckPassed {
+		verdict.indeterminate(subIndicationNoCertificateChain, "signer certificate chain: %s", chain.Message)
+	}
+	verdict.warn("signer certificate revocation status is not checked")
+	return certificate, verdict
+}
+
+// checkManifest compares manifest entries with files of the container.
+func checkManifest(reader *zip.Reader, validation *responses.AsiceValidation) {
+	manifestFile := findZipFile(reader, asiceManifestFile)
+	if manifestFile == nil {
+		validation.ContainerWarnings = append(validation.ContainerWarnings, "META-INF/manifest.xml not found")
+		return
+	}
+	mediaTypes, err := manifestMediaTypes(reader)
+	if err != nil {
+		validation.ContainerErrors = append(validation.ContainerErrors, err.Error())
+		return
+	}
+
+	if mediaType, ok := mediaTypes["/"]; ok && mediaType != asiceMimeType {
+		validation.ContainerErrors = append(validation.ContainerErrors, fmt.Sprintf("manifest container media type %s does not match mimetype", mediaType))
+	}
+	for _, file := range asiceDataFiles(reader) {
+		if _, ok := mediaTypes[file.Name]; !ok {
+			validation.ContainerErrors = append(validation.ContainerErrors, fmt.Sprintf("file %s is not listed in manifest", file.Name))
+		}
+	}
+	for name := range mediaTypes {
+		if name == "/" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		if findZipFile(reader, name) == nil {
+			validation.ContainerErrors = append(validation.ContainerErrors, fmt.Sprintf("manifest lists file %s which is not in container", name))
+		}
+	}
+}
+
+// validateAsice validates manifest and every signature of the container.
+func validateAsice(reader *zip.Reader, trustStore *x509.CertPool) responses.AsiceValidation {
+	validation := responses.AsiceValidation{
+		ContainerErrors:   []string{},
+		ContainerWarnings: []string{},
+		Signatures:        []responses.AsiceSignatureValidation{},
+	}
+
+	if reader.File[0].Name != asiceMimetypeFile || reader.File[0].Method != zip.Store {
+		validation.ContainerErrors = append(validation.ContainerErrors, "mimetype shall be the first entry stored without compression")
+	}
+	dataFiles := asiceDataFiles(reader)
+	if len(dataFiles) == 0 {
+		validation.ContainerErrors = append(validation.ContainerErrors, "container has no data files")
+	}
+	checkManifest(reader, &validation)
+
+	for _, file := range reader.File {
+		if !isAsiceSignatureFile(file.Name) {
+			continue
+		}
+
+		signatures, err := readAsiceSignatureFile(file)
+		if err != nil {
+			validation.Signatures = append(validation.Signatures, responses.AsiceSignatureValidation{
+				File:          file.Name,
+				SignedFiles:   []string{},
+				Indication:    indicationTotalFailed,
+				SubIndication: subIndicationFormatFailure,
+				Errors:        []string{err.Error()},
+				Warnings:      []string{},
+			})
+			continue
+		}
+
+		for _, signature := range signatures {
+			certificate, verdict := validateXadesSignature(reader, signature, trustStore)
+
+			signedFiles := xadesSignedFiles(signature)
+			covered := map[string]bool{}
+			for _, name := range signedFiles {
+				covered[name] = true
+			}
+			for _, dataFile := range dataFiles {
+				if !covered[dataFile.Name] {
+					verdict.warn("file %s is not covered by the signature", dataFile.Name)
+				}
+			}
+
+			result := responses.AsiceSignatureValidation{
+				File:          file.Name,
+				Id:            signature.Attr("Id"),
+				SigningTime:   xadesSigningTime(signature),
+				SignedFiles:   signedFiles,
+				Indication:    verdict.Indication,
+				SubIndication: verdict.SubIndication,
+				Errors:        verdict.Errors,
+				Warnings:      verdict.Warnings,
+			}
+			if certificate != nil {
+				result.Signer = certificateInfo(certificate)
+			}
+			validation.Signatures = append(validation.Signatures, result)
+		}
+	}
+
+	validation.SignatureCount = len(validation.Signatures)
+	for _, signature := range validation.Signatures {
+		if signature.Indication == indicationTotalPassed {
+			validation.ValidSignatureCount++
+		}
+	}
+	if validation.SignatureCount == 0 {
+		validation.ContainerErrors = append(validation.ContainerErrors, "container has no signatures")
+	}
+	validation.Valid = len(validation.ContainerErrors) == 0 && validation.ValidSignatureCount == validation.SignatureCount
+	return validation
+}
@@ -0,0 +1,292 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+const (
+	asicsMimeType      = "application/vnd.etsi.asic-s+zip"
+	asicsCAdESFile     = "META-INF/signature.p7s"
+	asicsXAdESFile     = "META-INF/signatures.xml"
+	asicsTimestampFile = "META-INF/timestamp.tst"
+)
+
+// asicsSignatureFormats maps ASiC-S signature file names to signature formats.
+var asicsSignatureFormats = map[string]string{
+	asicsCAdESFile:     "CAdES",
+	asicsXAdESFile:     "XAdES",
+	asicsTimestampFile: "timestamp",
+}
+
+// openAsics opens the container and checks its mimetype.
+func openAsics(containerBytes []byte) (*zip.Reader, error) {
+	return openContainer(containerBytes, asicsMimeType)
+}
+
+// asicsDataObject checks ASiC-S layout and returns the data object of the
+// container, nil if container has no data object yet.
+func asicsDataObject(reader *zip.Reader) (*zip.File, error) {
+	names := make([]string, 0, len(reader.File))
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	name, err := asicsDataObjectName(names)
+	if err != nil || name == "" {
+		return nil, err
+	}
+	return findZipFile(reader, name), nil
+}
+
+// asicsDataObjectName checks ASiC-S layout of the container entry names and
+// returns name of the data object, empty if there is none. Container can hold
+// only one data object in root folder and one signature or timestamp.
+func asicsDataObjectName(names []string) (string, error) {
+	var dataObject, signatureFile string
+	seen := map[string]bool{}
+
+	for _, name := range names {
+		if seen[name] {
+			return "", fmt.Errorf("file %s is in container more than once", name)
+		}
+		seen[name] = true
+
+		switch {
+		case name == asiceMimetypeFile || strings.HasSuffix(name, "/"):
+		case asicsSignatureFormats[name] != "":
+			if signatureFile != "" {
+				return "", fmt.Errorf("ASiC-S container shall have one signature or timestamp, found %s and %s", signatureFile, name)
+			}
+			signatureFile = name
+		case strings.HasPrefix(name, "META-INF/"):
+		case strings.Contains(name, "/"):
+			return "", fmt.Errorf("data object %s shall be in root folder of the container", name)
+		case dataObject != "":
+			return "", fmt.Errorf("ASiC-S container shall hold a single data object, found %s and %s", dataObject, name)
+		default:
+			dataObject = name
+		}
+	}
+	return dataObject, nil
+}
+
+// asicsSignatureFileName returns container file name for the signature:
+// XML is XAdES, CMS with TSTInfo is a timestamp token, other CMS is CAdES.
+func asicsSignatureFileName(signature []byte) (string, error) {
+	if trimmed := bytes.TrimSpace(signature); len(trimmed) > 0 && trimmed[0] == '<' {
+		if _, err := parseXML(signature); err != nil {
+			return "", fmt.Errorf("invalid XAdES signature: %w", err)
+		}
+		return asicsXAdESFile, nil
+	}
+
+	signedData, err := parseSignedData(signature)
+	if err != nil {
+		return "", fmt.Errorf("signature is neither XAdES nor CMS: %w", err)
+	}
+	if isTimestampToken(signedData) {
+		return asicsTimestampFile, nil
+	}
+	return asicsCAdESFile, nil
+}
+
+// newAsicsContainer writes container with mimetype, the data object and the
+// signature if it is set.
+func newAsicsContainer(files []requests.SignedFile, signature []byte) ([]byte, error) {
+	if len(files) != 1 {
+		return nil, fmt.Errorf("ASiC-S container shall hold a single data object, %d files provided", len(files))
+	}
+	file := files[0]
+	if err := validateFileName(file.FileName); err != nil {
+		return nil, err
+	}
+	if strings.Contains(file.FileName, "/") {
+		return nil, fmt.Errorf("data object %s shall be in root folder of the container", file.FileName)
+	}
+	content, err := base64.StdEncoding.DecodeString(file.EncodedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode file %s from base64", file.FileName)
+	}
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+
+	if err := writeMimetype(writer, asicsMimeType); err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(writer, file.FileName, content); err != nil {
+		return nil, err
+	}
+	if len(signature) > 0 {
+		signatureName, err := asicsSignatureFileName(signature)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeZipFile(writer, signatureName, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// cmsSignatureInfo describes CAdES signature or timestamp token of the file.
+// Detached signature covers the data object, so signedFiles are set as is.
+func cmsSignatureInfo(file *zip.File, signedFiles []string) responses.AsiceSignatureInfo {
+	info := responses.AsiceSignatureInfo{
+		File:        file.Name,
+		Format:      asicsSignatureFormats[file.Name],
+		SignedFiles: signedFiles,
+	}
+
+	content, err := readZipFile(file)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	signedData, err := parseSignedData(content)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+
+	if isTimestampToken(signedData) {
+		token, err := parseTSTInfo(signedData)
+		if err != nil {
+			info.Error = err.Error()
+			return info
+		}
+		info.SigningTime = token.GenTime.UTC().Format(time.RFC3339)
+	}
+
+	if len(signedData.SignerInfos) == 0 {
+		info.Error = "CMS has no signers"
+		return info
+	}
+	signerInfo := signedData.SignerInfos[0]
+	info.SignatureMethod = signerInfo.SignatureAlgorithm.Algorithm.String()
+	if signingTime, ok := signerInfo.signingTime(); ok && info.SigningTime == "" {
+		info.SigningTime = signingTime.UTC().Format(time.RFC3339)
+	}
+
+	certificate, err := signedData.signerCertificate(signerInfo)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Signer = certificateInfo(certificate)
+	return info
+}
+
+// inspectAsics describes the data object and signature of the container.
+func inspectAsics(reader *zip.Reader, hash crypto.Hash) (responses.AsiceInspection, error) {
+	inspection := responses.AsiceInspection{
+		MimeType:   asicsMimeType,
+		DataFiles:  []responses.AsiceDataFile{},
+		Signatures: []responses.AsiceSignatureInfo{},
+	}
+
+	dataObject, err := asicsDataObject(reader)
+	if err != nil {
+		return inspection, err
+	}
+
+	signedFiles := []string{}
+	if dataObject != nil {
+		digest, err := hashZipFile(dataObject, hash)
+		if err != nil {
+			return inspection, err
+		}
+		inspection.DataFiles = append(inspection.DataFiles, responses.AsiceDataFile{
+			Name:            dataObject.Name,
+			Size:            dataObject.UncompressedSize64,
+			MimeType:        dataFileMimeType(dataObject.Name, nil),
+			Digest:          base64.StdEncoding.EncodeToString(digest),
+			DigestAlgorithm: hash.String(),
+		})
+		signedFiles = append(signedFiles, dataObject.Name)
+	}
+
+	for _, file := range reader.File {
+		switch asicsSignatureFormats[file.Name] {
+		case "":
+			continue
+		case "XAdES":
+			signatures, err := readAsiceSignatureFile(file)
+			if err != nil {
+				inspection.Signatures = append(inspection.Signatures, responses.AsiceSignatureInfo{
+					File:        file.Name,
+					Format:      "XAdES",
+					SignedFiles: []string{},
+					Error:       err.Error(),
+				})
+				continue
+			}
+			for _, signature := range signatures {
+				inspection.Signatures = append(inspection.Signatures, xadesSignatureInfo(file.Name, signature))
+			}
+		default:
+			inspection.Signatures = append(inspection.Signatures, cmsSignatureInfo(file, signedFiles))
+		}
+	}
+
+	return inspection, nil
+}
+
+// checkAsicsAddedFiles checks that the container with the added files has
+// exactly one data object, before the files are written.
+func checkAsicsAddedFiles(reader *zip.Reader, files []requests.SignedFile) error {
+	if err := checkContainerMimetype(reader, asicsMimeType); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(reader.File)+len(files))
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	for _, file := range files {
+		names = append(names, file.FileName)
+	}
+	dataObject, err := asicsDataObjectName(names)
+	if err != nil {
+		return err
+	}
+	if dataObject == "" {
+		return errors.New("ASiC-S container has no data object")
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// newTestCadesSignature creates detached CAdES signature of the content.
+func newTestCadesSignature(t *testing.T, content []byte) []byte {
+	key, ok := newTestCmsKeyRegistry(t, generateTestRSAKey(t)).Get("seal")
+	if !ok {
+		t.Fatal("key not found")
+	}
+	certificate, err := keyCertificate(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(content)
+	signature, err := createSignedData(cmsSignerParameters{
+		Key:         key,
+		Certificate: certificate,
+		Hash:        crypto.SHA256,
+		Digest:      digest[:],
+		ContentType: oidData,
+		SigningTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		RSAOptions:  rsaSignatureOptions{Method: signatureMethodPKCS1v15},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+// newTestTimestampToken creates unsigned time-stamp token of the content.
+func newTestTimestampToken(t *testing.T, content []byte, genTime time.Time) []byte {
+	digest := sha256.Sum256(content)
+	info, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3},
+		MessageImprint: tstMessageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: digest[:]},
+		SerialNumber:   big.NewInt(1),
+		GenTime:        genTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eContent, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedData, err := asn1.Marshal(cmsSignedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: cmsEncapsulatedContentInfo{
+			EContentType: oidTSTInfo,
+			EContent:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: eContent},
+		},
+		SignerInfos: []cmsSignerInfo{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func createTestAsics(t *testing.T, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/asics/create", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleCreateAsicsRequest(rr, req)
+	return rr
+}
+
+func inspectTestAsics(t *testing.T, container []byte) responses.AsiceInspection {
+	req := httptest.NewRequest(http.MethodPost, "/asics/inspect", bytes.NewReader(container))
+	req.Header.Set("Content-Type", asicsMimeType)
+	rr := httptest.NewRecorder()
+	HandleInspectAsicsRequest(rr, req)
+
+	var inspection responses.AsiceInspection
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return inspection
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&inspection); err != nil {
+		t.Fatal(err)
+	}
+	return inspection
+}
+
+func TestCreateAsicsWithCades(t *testing.T) {
+	fmt.Println("!!! Starting ASiC-S tests on logic_asics.go !!!")
+	content := []byte("Hello, World!")
+	body := fmt.Sprintf(`{"signedFiles": [{"fileName": "test.txt", "encodedFile": "%s"}], "signature": "%s"}`,
+		base64.StdEncoding.EncodeToString(content), base64.StdEncoding.EncodeToString(newTestCadesSignature(t, content)))
+
+	rr := createTestAsics(t, body)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	container := rr.Body.Bytes()
+	assertMimetypeEntry(t, container, asicsMimeType)
+
+	reader, err := zip.NewReader(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, findZipFile(reader, asicsCAdESFile))
+	assert.Nil(t, findZipFile(reader, asiceManifestFile))
+
+	inspection := inspectTestAsics(t, container)
+	assert.Equal(t, asicsMimeType, inspection.MimeType)
+	if assert.Len(t, inspection.DataFiles, 1) {
+		assert.Equal(t, "test.txt", inspection.DataFiles[0].Name)
+		assert.Equal(t, "3/1gIbsr1bCvZ2KQgJ7DpTGR3YHH9wpLKGiKNiGCmG8=", inspection.DataFiles[0].Digest)
+	}
+	if assert.Len(t, inspection.Signatures, 1) {
+		signature := inspection.Signatures[0]
+		assert.Empty(t, signature.Error)
+		assert.Equal(t, "CAdES", signature.Format)
+		assert.Equal(t, asicsCAdESFile, signature.File)
+		assert.Equal(t, "2024-05-01T10:00:00Z", signature.SigningTime)
+		assert.Equal(t, oidRSAEncryption.String(), signature.SignatureMethod)
+		assert.Equal(t, []string{"test.txt"}, signature.SignedFiles)
+		if assert.NotNil(t, signature.Signer) {
+			assert.Contains(t, signature.Signer.Subject, "CN=Test Signer")
+		}
+	}
+}
+
+func TestCreateAsicsSingleDataObject(t *testing.T) {
+	body := `{"signedFiles": [{"fileName": "a.txt", "encodedFile": "SGVsbG8="}, {"fileName": "b.txt", "encodedFile": "SGVsbG8="}]}`
+	rr := createTestAsics(t, body)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "single data object")
+
+	rr = createTestAsics(t, `{"signedFiles": []}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = createTestAsics(t, `{"signedFiles": [{"fileName": "folder/a.txt", "encodedFile": "SGVsbG8="}]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = createTestAsics(t, `{"signedFiles": [{"fileName": "a.txt", "encodedFile": "SGVsbG8="}], "signature": "SGVsbG8="}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "neither XAdES nor CMS")
+}
+
+func TestAddFileToAsicsWithTimestamp(t *testing.T) {
+	content := []byte("Hello, World!")
+	genTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Container with the timestamp only, data object is added later
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	assert.NoError(t, writeMimetype(writer, asicsMimeType))
+	assert.NoError(t, writeZipFile(writer, asicsTimestampFile, newTestTimestampToken(t, content, genTime)))
+	assert.NoError(t, writer.Close())
+	container := base64.StdEncoding.EncodeToString(buffer.Bytes())
+
+	body := fmt.Sprintf(`{"container": "%s", "signedFiles": [{"fileName": "test.txt", "encodedFile": "%s"}]}`,
+		container, base64.StdEncoding.EncodeToString(content))
+	req := httptest.NewRequest(http.MethodPost, "/asics/addFile", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsicsRequest(rr, req)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	assertMimetypeEntry(t, rr.Body.Bytes(), asicsMimeType)
+
+	inspection := inspectTestAsics(t, rr.Body.Bytes())
+	if assert.Len(t, inspection.DataFiles, 1) {
+		assert.Equal(t, "test.txt", inspection.DataFiles[0].Name)
+	}
+	if assert.Len(t, inspection.Signatures, 1) {
+		assert.Equal(t, "timestamp", inspection.Signatures[0].Format)
+		assert.Equal(t, "2024-06-01T12:00:00Z", inspection.Signatures[0].SigningTime)
+		assert.Equal(t, "CMS has no signers", inspection.Signatures[0].Error)
+	}
+
+	// Second data object is refused
+	body = fmt.Sprintf(`{"container": "%s", "signedFiles": [{"fileName": "other.txt", "encodedFile": "SGVsbG8="}]}`,
+		base64.StdEncoding.EncodeToString(rr.Body.Bytes()))
+	req = httptest.NewRequest(http.MethodPost, "/asics/addFile", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleAddFileToAsicsRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "single data object")
+
+	// Layout is checked on entry names before the container is written
+	body = fmt.Sprintf(`{"container": "%s", "signedFiles": [{"fileName": "a.txt", "encodedFile": "SGVsbG8="}, {"fileName": "b.txt", "encodedFile": "SGVsbG8="}]}`, container)
+	req = httptest.NewRequest(http.MethodPost, "/asics/addFile", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleAddFileToAsicsRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "found a.txt and b.txt")
+
+	body = fmt.Sprintf(`{"container": "%s", "signedFiles": []}`, container)
+	req = httptest.NewRequest(http.MethodPost, "/asics/addFile", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleAddFileToAsicsRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "has no data object")
+
+	body = fmt.Sprintf(`{"container": "%s", "signedFiles": [{"fileName": "test.txt", "encodedFile": "SGVsbG8="}]}`,
+		base64.StdEncoding.EncodeToString(newTestAsice(t, map[string]string{})))
+	req = httptest.NewRequest(http.MethodPost, "/asics/addFile", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	HandleAddFileToAsicsRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unsupported container mimetype")
+}
+
+func TestInspectAsicsRejectsAsice(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/asics/inspect", bytes.NewReader(newTestAsice(t, map[string]string{"test.txt": "Hello"})))
+	req.Header.Set("Content-Type", "application/zip")
+	rr := httptest.NewRecorder()
+	HandleInspectAsicsRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unsupported container mimetype")
+}
@@ -0,0 +1,622 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Canonicalization methods supported for XML signatures
+const (
+	c14nExclusive             = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	c14nExclusiveWithComments = "http://www.w3.org/2001/10/xml-exc-c14n#WithComments"
+	c14n10                    = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	c14n10WithComments        = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315#WithComments"
+	c14n11                    = "http://www.w3.org/2006/12/xml-c14n11"
+	c14n11WithComments        = "http://www.w3.org/2006/12/xml-c14n11#WithComments"
+)
+
+// xmlNamespace is the namespace bound to the xml prefix.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// xmlNode is an element, text, comment or processing instruction of a parsed
+// XML document. Names keep the prefixes used in the document, namespace
+// declarations are attributes. Processing instruction keeps its target in Local.
+type xmlNode struct {
+	Prefix   string
+	Local    string
+	Attrs    []xml.Attr
+	Children []*xmlNode
+	Parent   *xmlNode
+
+	Text       string
+	IsText     bool
+	IsComment  bool
+	IsProcInst bool
+}
+
+// isElement reports whether the node is an element.
+func (n *xmlNode) isElement() bool {
+	return !n.IsText && !n.IsComment && !n.IsProcInst
+}
+
+// parseXML reads the document and returns its root element.
+func parseXML(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+
+	var root, current *xmlNode
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Prefix: t.Name.Space, Local: t.Name.Local, Attrs: t.Copy().Attr, Parent: current}
+			if err := normalizeAttributes(data[offset:decoder.InputOffset()], node.Attrs); err != nil {
+				return nil, err
+			}
+			if current == nil {
+				if root != nil {
+					return nil, errors.New("XML document has more than one root element")
+				}
+				root = node
+			} else {
+				current.Children = append(current.Children, node)
+			}
+			current = node
+		case xml.EndElement:
+			if current == nil || current.Prefix != t.Name.Space || current.Local != t.Name.Local {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			current = current.Parent
+		case xml.CharData:
+			if current != nil {
+				current.Children = append(current.Children, &xmlNode{Text: string(t), IsText: true, Parent: current})
+			}
+		case xml.Comment:
+			if current != nil {
+				current.Children = append(current.Children, &xmlNode{Text: string(t), IsComment: true, Parent: current})
+			}
+		case xml.ProcInst:
+			if current != nil {
+				current.Children = append(current.Children, &xmlNode{Local: t.Target, Text: string(t.Inst), IsProcInst: true, Parent: current})
+			}
+		case xml.Directive:
+			return nil, errors.New("XML document type declarations are not supported")
+		}
+	}
+
+	if root == nil || current != nil {
+		return nil, errors.New("XML document is not complete")
+	}
+	return root, nil
+}
+
+// normalizeAttributes replaces values of the attributes with values normalized
+// as XML requires: literal tab, carriage return and line feed characters become
+// spaces, while the same characters written as character references are kept.
+// The decoder does not tell them apart, so values are read again from the raw
+// start tag.
+func normalizeAttributes(tag []byte, attrs []xml.Attr) error {
+	values, err := rawAttributeValues(tag)
+	if err != nil {
+		return err
+	}
+	if len(values) != len(attrs) {
+		return errors.New("failed to read XML attribute values")
+	}
+	for i, raw := range values {
+		value, err := normalizeAttributeValue(raw)
+		if err != nil {
+			return err
+		}
+		attrs[i].Value = value
+	}
+	return nil
+}
+
+// rawAttributeValues returns values of the start tag attributes as written in
+// the document, in order of the attributes.
+func rawAttributeValues(tag []byte) ([]string, error) {
+	start := bytes.IndexByte(tag, '<')
+	if start < 0 {
+		return nil, errors.New("failed to read XML start tag")
+	}
+	var values []string
+	for i := start + 1; i < len(tag); i++ {
+		quote := tag[i]
+		if quote != '"' && quote != '\'' {
+			continue
+		}
+		end := bytes.IndexByte(tag[i+1:], quote)
+		if end < 0 {
+			return nil, errors.New("failed to read XML attribute value")
+		}
+		values = append(values, string(tag[i+1:i+1+end]))
+		i += end + 1
+	}
+	return values, nil
+}
+
+// normalizeAttributeValue resolves references of the raw attribute value and
+// replaces literal white space characters with spaces.
+func normalizeAttributeValue(raw string) (string, error) {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+
+	var value strings.Builder
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '\t', '\n', '\r':
+			value.WriteByte(' ')
+		case '&':
+			end := strings.IndexByte(raw[i:], ';')
+			if end < 0 {
+				return "", errors.New("invalid reference in XML attribute value")
+			}
+			reference, err := resolveReference(raw[i+1 : i+end])
+			if err != nil {
+				return "", err
+			}
+			value.WriteString(reference)
+			i += end
+		default:
+			value.WriteByte(raw[i])
+		}
+	}
+	return value.String(), nil
+}
+
+// resolveReference returns the text of the predefined entity or character
+// reference without leading ampersand and trailing semicolon.
+func resolveReference(name string) (string, error) {
+	switch name {
+	case "amp":
+		return "&", nil
+	case "lt":
+		return "<", nil
+	case "gt":
+		return ">", nil
+	case "apos":
+		return "'", nil
+	case "quot":
+		return `"`, nil
+	}
+
+	var code uint64
+	var err error
+	switch {
+	case strings.HasPrefix(name, "#x"):
+		code, err = strconv.ParseUint(name[2:], 16, 32)
+	case strings.HasPrefix(name, "#"):
+		code, err = strconv.ParseUint(name[1:], 10, 32)
+	default:
+		err = errors.New("unknown entity")
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid reference &%s; in XML attribute value", name)
+	}
+	return string(rune(code)), nil
+}
+
+// qualifiedName returns the name of the element as written in the document.
+func (n *xmlNode) qualifiedName() string {
+	if n.Prefix == "" {
+		return n.Local
+	}
+	return n.Prefix + ":" + n.Local
+}
+
+// namespaceDeclaration returns the prefix declared by the attribute.
+func namespaceDeclaration(attr xml.Attr) (string, bool) {
+	if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+		return "", true
+	}
+	if attr.Name.Space == "xmlns" {
+		return attr.Name.Local, true
+	}
+	return "", false
+}
+
+// lookupNamespace resolves the prefix in scope of the element.
+func (n *xmlNode) lookupNamespace(prefix string) string {
+	if prefix == "xml" {
+		return xmlNamespace
+	}
+	for node := n; node != nil; node = node.Parent {
+		for _, attr := range node.Attrs {
+			if declared, ok := namespaceDeclaration(attr); ok && declared == prefix {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+// Namespace returns the namespace URI of the element.
+func (n *xmlNode) Namespace() string {
+	return n.lookupNamespace(n.Prefix)
+}
+
+// inScopeNamespaces returns all namespaces declared on the element and its ancestors.
+func (n *xmlNode) inScopeNamespaces() map[string]string {
+	namespaces := map[string]string{}
+	for node := n; node != nil; node = node.Parent {
+		for _, attr := range node.Attrs {
+			if prefix, ok := namespaceDeclaration(attr); ok {
+				if _, exists := namespaces[prefix]; !exists {
+					namespaces[prefix] = attr.Value
+				}
+			}
+		}
+	}
+	return namespaces
+}
+
+// Attr returns value of the attribute without prefix.
+func (n *xmlNode) Attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// AttrNS returns value of the attribute in the namespace.
+func (n *xmlNode) AttrNS(namespace, name string) string {
+	for _, attr := range n.Attrs {
+		if _, ok := namespaceDeclaration(attr); ok || attr.Name.Local != name {
+			continue
+		}
+		if n.lookupNamespace(attr.Name.Space) == namespace && attr.Name.Space != "" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Elements returns child elements with the namespace and local name.
+func (n *xmlNode) Elements(namespace, local string) []*xmlNode {
+	var elements []*xmlNode
+	for _, child := range n.Children {
+		if child.isElement() && child.Local == local && child.Namespace() == namespace {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// Element returns the first child element with the namespace and local name.
+func (n *xmlNode) Element(namespace, local string) *xmlNode {
+	elements := n.Elements(namespace, local)
+	if len(elements) == 0 {
+		return nil
+	}
+	return elements[0]
+}
+
+// Path follows child elements in the namespace, nil if one of them is missing.
+func (n *xmlNode) Path(namespace string, locals ...string) *xmlNode {
+	node := n
+	for _, local := range locals {
+		if node == nil {
+			return nil
+		}
+		node = node.Element(namespace, local)
+	}
+	return node
+}
+
+// Content returns text content of the element.
+func (n *xmlNode) Content() string {
+	var content strings.Builder
+	for _, child := range n.Children {
+		if child.IsText {
+			content.WriteString(child.Text)
+		} else if child.isElement() {
+			content.WriteString(child.Content())
+		}
+	}
+	return content.String()
+}
+
+// SetContent replaces children of the element with text.
+func (n *xmlNode) SetContent(text string) {
+	n.Children = []*xmlNode{{Text: text, IsText: true, Parent: n}}
+}
+
+// AppendChild adds the element as the last child of the element.
+func (n *xmlNode) AppendChild(child *xmlNode) {
+	child.Parent = n
+	n.Children = append(n.Children, child)
+}
+
+// FindByID returns the element in the subtree with the Id attribute value.
+func (n *xmlNode) FindByID(id string) *xmlNode {
+	if !n.isElement() {
+		return nil
+	}
+	if n.Attr("Id") == id {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.FindByID(id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// DuplicateID returns an Id attribute value used by more than one element of
+// the subtree, empty if all values are unique.
+func (n *xmlNode) DuplicateID() string {
+	seen := map[string]bool{}
+	var find func(node *xmlNode) string
+	find = func(node *xmlNode) string {
+		if !node.isElement() {
+			return ""
+		}
+		if id := node.Attr("Id"); id != "" {
+			if seen[id] {
+				return id
+			}
+			seen[id] = true
+		}
+		for _, child := range node.Children {
+			if id := find(child); id != "" {
+				return id
+			}
+		}
+		return ""
+	}
+	return find(n)
+}
+
+// canonicalize serializes the element subtree with the canonicalization method.
+// Inclusive prefixes are used only with exclusive canonicalization.
+func canonicalize(n *xmlNode, method string, inclusivePrefixes []string) ([]byte, error) {
+	c := canonicalizer{withComments: strings.HasSuffix(method, "#WithComments")}
+
+	switch method {
+	case c14nExclusive, c14nExclusiveWithComments:
+		c.exclusive = true
+		c.inclusivePrefixes = inclusivePrefixes
+	case c14n10, c14n10WithComments:
+		c.inherited = inheritedXMLAttributes(n, nil)
+	case c14n11, c14n11WithComments:
+		// xml:base of ancestors would need URI fixup of canonical XML 1.1
+		if inheritedXMLAttributes(n, []string{"base"}) != nil {
+			return nil, errors.New("xml:base of ancestor elements is not supported with canonicalization 1.1")
+		}
+		c.inherited = inheritedXMLAttributes(n, []string{"lang", "space"})
+	default:
+		return nil, fmt.Errorf("unsupported canonicalization method %s", method)
+	}
+
+	var buffer bytes.Buffer
+	c.writeElement(&buffer, n, map[string]string{}, true)
+	return buffer.Bytes(), nil
+}
+
+type canonicalizer struct {
+	exclusive         bool
+	withComments      bool
+	inclusivePrefixes []string
+	// xml:* attributes of ancestors rendered on the apex element
+	inherited []xml.Attr
+}
+
+// inheritedXMLAttributes returns xml:* attributes of the element ancestors, the
+// nearest one of each name, that the element itself does not have. Nil names
+// select all xml:* attributes.
+func inheritedXMLAttributes(n *xmlNode, names []string) []xml.Attr {
+	seen := map[string]bool{}
+	for _, attr := range n.Attrs {
+		if attr.Name.Space == "xml" {
+			seen[attr.Name.Local] = true
+		}
+	}
+
+	var inherited []xml.Attr
+	for node := n.Parent; node != nil; node = node.Parent {
+		for _, attr := range node.Attrs {
+			if attr.Name.Space != "xml" || seen[attr.Name.Local] {
+				continue
+			}
+			if names != nil && !slices.Contains(names, attr.Name.Local) {
+				continue
+			}
+			seen[attr.Name.Local] = true
+			inherited = append(inherited, attr)
+		}
+	}
+	return inherited
+}
+
+func (c canonicalizer) writeElement(buffer *bytes.Buffer, n *xmlNode, rendered map[string]string, apex bool) {
+	// Namespaces to render on this element
+	var namespaces map[string]string
+	if c.exclusive {
+		namespaces = c.utilizedNamespaces(n)
+	} else if apex {
+		namespaces = n.inScopeNamespaces()
+	} else {
+		namespaces = map[string]string{}
+		for _, attr := range n.Attrs {
+			if prefix, ok := namespaceDeclaration(attr); ok {
+				namespaces[prefix] = attr.Value
+			}
+		}
+	}
+
+	renderedHere := map[string]string{}
+	for prefix, uri := range namespaces {
+		if prefix == "xml" {
+			continue
+		}
+		current, exists := rendered[prefix]
+		if exists && current == uri {
+			continue
+		}
+		if !exists && prefix == "" && uri == "" {
+			continue
+		}
+		renderedHere[prefix] = uri
+	}
+
+	childRendered := rendered
+	if len(renderedHere) > 0 {
+		childRendered = make(map[string]string, len(rendered)+len(renderedHere))
+		for prefix, uri := range rendered {
+			childRendered[prefix] = uri
+		}
+		for prefix, uri := range renderedHere {
+			childRendered[prefix] = uri
+		}
+	}
+
+	buffer.WriteByte('<')
+	buffer.WriteString(n.qualifiedName())
+
+	prefixes := make([]string, 0, len(renderedHere))
+	for prefix := range renderedHere {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			buffer.WriteString(` xmlns="`)
+		} else {
+			buffer.WriteString(` xmlns:` + prefix + `="`)
+		}
+		buffer.WriteString(escapeC14NAttribute(renderedHere[prefix]))
+		buffer.WriteByte('"')
+	}
+
+	type canonicalAttr struct {
+		namespace, name, value string
+		local                  string
+	}
+	var attrs []canonicalAttr
+	elementAttrs := n.Attrs
+	if apex && len(c.inherited) > 0 {
+		elementAttrs = append(append([]xml.Attr{}, n.Attrs...), c.inherited...)
+	}
+	for _, attr := range elementAttrs {
+		if _, ok := namespaceDeclaration(attr); ok {
+			continue
+		}
+		name := attr.Name.Local
+		namespace := ""
+		if attr.Name.Space != "" {
+			name = attr.Name.Space + ":" + attr.Name.Local
+			namespace = n.lookupNamespace(attr.Name.Space)
+		}
+		attrs = append(attrs, canonicalAttr{namespace: namespace, name: name, value: attr.Value, local: attr.Name.Local})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].namespace != attrs[j].namespace {
+			return attrs[i].namespace < attrs[j].namespace
+		}
+		return attrs[i].local < attrs[j].local
+	})
+	for _, attr := range attrs {
+		buffer.WriteString(" " + attr.name + `="` + escapeC14NAttribute(attr.value) + `"`)
+	}
+	buffer.WriteByte('>')
+
+	for _, child := range n.Children {
+		switch {
+		case child.IsText:
+			buffer.WriteString(escapeC14NText(child.Text))
+		case child.IsComment:
+			if c.withComments {
+				buffer.WriteString("<!--" + child.Text + "-->")
+			}
+		case child.IsProcInst:
+			buffer.WriteString("<?" + child.Local)
+			if child.Text != "" {
+				buffer.WriteString(" " + child.Text)
+			}
+			buffer.WriteString("?>")
+		default:
+			c.writeElement(buffer, child, childRendered, false)
+		}
+	}
+
+	buffer.WriteString("</" + n.qualifiedName() + ">")
+}
+
+// utilizedNamespaces returns namespaces visibly utilized by the element and
+// its attributes, and inclusive prefixes in scope, for exclusive canonicalization.
+func (c canonicalizer) utilizedNamespaces(n *xmlNode) map[string]string {
+	namespaces := map[string]string{n.Prefix: n.lookupNamespace(n.Prefix)}
+	for _, attr := range n.Attrs {
+		if _, ok := namespaceDeclaration(attr); ok {
+			continue
+		}
+		if attr.Name.Space != "" {
+			namespaces[attr.Name.Space] = n.lookupNamespace(attr.Name.Space)
+		}
+	}
+
+	if len(c.inclusivePrefixes) > 0 {
+		inScope := n.inScopeNamespaces()
+		for _, prefix := range c.inclusivePrefixes {
+			if prefix == "#default" {
+				prefix = ""
+			}
+			if uri, ok := inScope[prefix]; ok {
+				namespaces[prefix] = uri
+			}
+		}
+	}
+	return namespaces
+}
+
+var c14nTextReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var c14nAttributeReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func escapeC14NText(text string) string {
+	return c14nTextReplacer.Replace(text)
+}
+
+func escapeC14NAttribute(value string) string {
+	return c14nAttributeReplacer.Replace(value)
+}
@@ -0,0 +1,228 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testC14NDocument = `<?xml version="1.0"?>
+<a:root xmlns:a="urn:a" xmlns:b="urn:b" xmlns="urn:default" xmlns:unused="urn:unused" attr="x">
+  <b:child b:z="1" a:y="2" plain="&quot;q&quot; &amp; &lt;" xmlns:a="urn:a">text &amp; &gt; more<!-- comment --></b:child>
+  <inner xmlns=""><deeper xmlns:b="urn:b2" b:attr="v">t</deeper></inner>
+  <x:el xmlns:x="urn:x" xmlns:b="urn:b"/>
+</a:root>`
+
+func TestCanonicalizeDocument(t *testing.T) {
+	fmt.Println("!!! Starting XML canonicalization tests on logic_c14n.go !!!")
+	root, err := parseXML([]byte(testC14NDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inclusive, err := canonicalize(root, c14n10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<a:root xmlns="urn:default" xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:unused" attr="x">
+  <b:child plain="&quot;q&quot; &amp; &lt;" a:y="2" b:z="1">text &amp; &gt; more</b:child>
+  <inner xmlns=""><deeper xmlns:b="urn:b2" b:attr="v">t</deeper></inner>
+  <x:el xmlns:x="urn:x"></x:el>
+</a:root>`, string(inclusive))
+
+	exclusive, err := canonicalize(root, c14nExclusiveWithComments, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<a:root xmlns:a="urn:a" attr="x">
+  <b:child xmlns:b="urn:b" plain="&quot;q&quot; &amp; &lt;" a:y="2" b:z="1">text &amp; &gt; more<!-- comment --></b:child>
+  <inner><deeper xmlns:b="urn:b2" b:attr="v">t</deeper></inner>
+  <x:el xmlns:x="urn:x"></x:el>
+</a:root>`, string(exclusive))
+}
+
+func TestCanonicalizeSubtree(t *testing.T) {
+	root, err := parseXML([]byte(testC14NDocument))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := root.Element("urn:b", "child")
+	if !assert.NotNil(t, child) {
+		return
+	}
+
+	inclusive, err := canonicalize(child, c14n11, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<b:child xmlns="urn:default" xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:unused" plain="&quot;q&quot; &amp; &lt;" a:y="2" b:z="1">text &amp; &gt; more</b:child>`, string(inclusive))
+
+	exclusive, err := canonicalize(child, c14nExclusive, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<b:child xmlns:a="urn:a" xmlns:b="urn:b" plain="&quot;q&quot; &amp; &lt;" a:y="2" b:z="1">text &amp; &gt; more</b:child>`, string(exclusive))
+
+	withPrefixes, err := canonicalize(child, c14nExclusive, []string{"unused", "#default"})
+	assert.NoError(t, err)
+	assert.Equal(t, `<b:child xmlns="urn:default" xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:unused" plain="&quot;q&quot; &amp; &lt;" a:y="2" b:z="1">text &amp; &gt; more</b:child>`, string(withPrefixes))
+}
+
+func TestCanonicalizeUnsupportedMethod(t *testing.T) {
+	root, err := parseXML([]byte(`<root/>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = canonicalize(root, "urn:unknown", nil)
+	assert.Error(t, err)
+}
+
+func TestParseXMLRejectsDoctype(t *testing.T) {
+	_, err := parseXML([]byte(`<!DOCTYPE root [<!ENTITY a "b">]><root>&a;</root>`))
+	assert.Error(t, err)
+}
+
+// Based on example 3.1 of Canonical XML 1.0, processing instructions outside
+// of the document element are not part of the element subtree
+func TestCanonicalizeProcessingInstructions(t *testing.T) {
+	root, err := parseXML([]byte(`<?xml version="1.0"?>
+
+<?xml-stylesheet   href="doc.xsl"
+   type="text/xsl"   ?>
+
+<doc>Hello, world!<!-- Comment 1 --><?pi-without-data     ?><?pi   some data ?></doc>
+
+<?pi-without-data     ?>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canonical, err := canonicalize(root, c14n10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>Hello, world!<?pi-without-data?><?pi some data ?></doc>`, string(canonical))
+
+	canonical, err = canonicalize(root, c14nExclusiveWithComments, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>Hello, world!<!-- Comment 1 --><?pi-without-data?><?pi some data ?></doc>`, string(canonical))
+}
+
+// Based on example 3.3 of Canonical XML 1.0 without the document type declaration
+func TestCanonicalizeStartAndEndTags(t *testing.T) {
+	root, err := parseXML([]byte(`<doc>
+   <e1   />
+   <e2   ></e2>
+   <e3   name = "elem3"   id="elem3"   />
+   <e4   name="elem4"   id="elem4"   ></e4>
+   <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
+      xmlns:b="http://www.ietf.org"
+      xmlns:a="http://www.w3.org"
+      xmlns="http://example.org"/>
+   <e6 xmlns="" xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="" xmlns:a="http://www.w3.org">
+            <e9 xmlns="" xmlns:a="http://www.ietf.org"/>
+         </e8>
+      </e7>
+   </e6>
+</doc>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canonical, err := canonicalize(root, c14n10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>
+   <e1></e1>
+   <e2></e2>
+   <e3 id="elem3" name="elem3"></e3>
+   <e4 id="elem4" name="elem4"></e4>
+   <e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>
+   <e6 xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="">
+            <e9 xmlns:a="http://www.ietf.org"></e9>
+         </e8>
+      </e7>
+   </e6>
+</doc>`, string(canonical))
+}
+
+// Based on example 3.4 of Canonical XML 1.0 without the document type
+// declaration, with literal white space added to attribute values
+func TestCanonicalizeCharacterModifications(t *testing.T) {
+	root, err := parseXML([]byte("<doc>\r\n" + `   <text>First line&#x0d;&#10;Second line</text>
+   <value>&#x32;</value>
+   <compute><![CDATA[value>"0" && value<"10" ?"valid":"error"]]></compute>
+   <compute expr='value>"0" &amp;&amp; value&lt;"10" ?"valid":"error"'>valid</compute>
+   <norm attr=' &apos;   &#x20;&#13;&#xa;&#9;   &apos; '/>
+   <normNames attr='   A   &#x20;&#13;&#xa;&#9;   B   '/>
+   <literal attr="a` + "\tb\r\nc\rd\ne" + `"/>
+</doc>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canonical, err := canonicalize(root, c14n10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>
+   <text>First line&#xD;
+Second line</text>
+   <value>2</value>
+   <compute>value&gt;"0" &amp;&amp; value&lt;"10" ?"valid":"error"</compute>
+   <compute expr="value>&quot;0&quot; &amp;&amp; value&lt;&quot;10&quot; ?&quot;valid&quot;:&quot;error&quot;">valid</compute>
+   <norm attr=" '    &#xD;&#xA;&#x9;   ' "></norm>
+   <normNames attr="   A    &#xD;&#xA;&#x9;   B   "></normNames>
+   <literal attr="a b c d e"></literal>
+</doc>`, string(canonical))
+}
+
+// Based on example 3.8 of Canonical XML 1.1, inclusive canonicalization of a
+// subtree renders xml:* attributes of ancestors
+func TestCanonicalizeInheritedXMLAttributes(t *testing.T) {
+	root, err := parseXML([]byte(`<doc xml:lang="en" xml:space="preserve" xml:id="d"><e1 xml:lang="lv"><e2 attr="1" xml:space="default"><e3/></e2></e1></doc>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2 := root.Path("", "e1", "e2")
+	if !assert.NotNil(t, e2) {
+		return
+	}
+
+	canonical, err := canonicalize(e2, c14n10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<e2 attr="1" xml:id="d" xml:lang="lv" xml:space="default"><e3></e3></e2>`, string(canonical))
+
+	canonical, err = canonicalize(e2, c14n11, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<e2 attr="1" xml:lang="lv" xml:space="default"><e3></e3></e2>`, string(canonical))
+
+	canonical, err = canonicalize(e2, c14nExclusive, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<e2 attr="1" xml:space="default"><e3></e3></e2>`, string(canonical))
+
+	based, err := parseXML([]byte(`<doc xml:base="http://www.example.com/"><e1/></doc>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err = canonicalize(based.Children[0], c14n10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `<e1 xml:base="http://www.example.com/"></e1>`, string(canonical))
+
+	_, err = canonicalize(based.Children[0], c14n11, nil)
+	assert.Error(t, err)
+}
@@ -35,8 +35,48 @@ import (
 	"github.com/unknovs/hash-sign/routes/responses"
 )
 
-func certificatesExist() bool {
-	return env.RsaAuthCert != "" || env.RsaSigningCert != "" || env.EcdsaAuthCert != "" || env.EcdsaSigningCert != ""
+func certificatesExist(keys *KeyRegistry) bool {
+	return env.RsaAuthCert != "" || env.RsaSigningCert != "" || env.EcdsaAuthCert != "" || env.EcdsaSigningCert != "" ||
+		len(keyCertificates(keys)) > 0
+}
+
+// keyCertificates lists certificates linked to registered keys.
+func keyCertificates(keys *KeyRegistry) []responses.KeyCertificate {
+	var certificates []responses.KeyCertificate
+	for _, key := range keys.Keys() {
+		if key.Certificate == "" {
+			continue
+		}
+		certificates = append(certificates, responses.KeyCertificate{
+			KeyId:       key.ID,
+			Algorithm:   key.Algorithm,
+			Certificate: key.Certificate,
+			Operations:  key.Operations,
+		})
+	}
+	return certificates
+}
+
+func getKeyCertificateResponse(keys *KeyRegistry, keyId string, r *http.Request) (responses.CertificatesResponse, error) {
+	response := responses.CertificatesResponse{}
+
+	key, ok := keys.Get(keyId)
+	if !ok {
+		return response, fmt.Errorf("key %s not found", keyId)
+	}
+	if key.Certificate == "" {
+		return response, fmt.Errorf("certificate for key %s not found", keyId)
+	}
+
+	response.Keys = []responses.KeyCertificate{{
+		KeyId:       key.ID,
+		Algorithm:   key.Algorithm,
+		Certificate: key.Certificate,
+		Operations:  key.Operations,
+	}}
+	log.Printf("%s?keyId=%s responded", r.URL.Path, keyId)
+
+	return response, nil
 }
 
 func CheckCertificates() error {
@@ -81,12 +121,12 @@ func CheckCertificates() error {
 	return nil
 }
 
-func getCertificatesResponse(key, certType string, r *http.Request) (responses.CertificatesResponse, error) {
+func getCertificatesResponse(keys *KeyRegistry, key, certType string, r *http.Request) (responses.CertificatesResponse, error) {
 	response := responses.CertificatesResponse{}
 
 	switch {
 	case key == "":
-		return handleBothCertificates(certType, r)
+		return handleBothCertificates(keys, certType, r)
 	case key == "rsa":
 		return handleRSACertificates(certType, r)
 	case key == "ecdsa":
@@ -156,7 +196,7 @@ func handleECDSACertificates(certType string, r *http.Request) (responses.Certif
 	return response, nil
 }
 
-func handleBothCertificates(certType string, r *http.Request) (responses.CertificatesResponse, error) {
+func handleBothCertificates(keys *KeyRegistry, certType string, r *http.Request) (responses.CertificatesResponse, error) {
 	response := responses.CertificatesResponse{}
 	switch certType {
 	case "":
@@ -164,6 +204,7 @@ func handleBothCertificates(certType string, r *http.Request) (responses.Certifi
 		response.RsaSigningCertificate = env.RsaSigningCert
 		response.EcdsaAuthenticationCertificate = env.EcdsaAuthCert
 		response.EcdsaSigningCertificate = env.EcdsaSigningCert
+		response.Keys = keyCertificates(keys)
 		log.Printf("%s responded", r.URL.Path)
 	default:
 		return response, fmt.Errorf("cannot use 'type' without 'key' in querry")
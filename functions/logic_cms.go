@@ -0,0 +1,564 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+var (
+	oidData                          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttributeContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidAttributeSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidAttributeTimeStampToken       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+
+	oidSHA224 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSASSAPSS       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECDSAWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+var hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA224: oidSHA224,
+	crypto.SHA256: oidSHA256,
+	crypto.SHA384: oidSHA384,
+	crypto.SHA512: oidSHA512,
+}
+
+var ecdsaSignatureOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA224: oidECDSAWithSHA224,
+	crypto.SHA256: oidECDSAWithSHA256,
+	crypto.SHA384: oidECDSAWithSHA384,
+	crypto.SHA512: oidECDSAWithSHA512,
+}
+
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapsulatedContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+type cmsEncapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+}
+
+type cmsSignerInfo struct {
+	Version            int
+	SID                cmsIssuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type essSigningCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// essCertIDv2 omits hashAlgorithm, so the SHA-256 default is used for certHash.
+type essCertIDv2 struct {
+	CertHash     []byte
+	IssuerSerial essIssuerSerial
+}
+
+type essIssuerSerial struct {
+	Issuer       []asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type rsaPSSParameters struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength       int                      `asn1:"explicit,tag:2"`
+}
+
+// cmsSignerParameters describes one SignedData with a single signer.
+type cmsSignerParameters struct {
+	Key         *SigningKey
+	Certificate *x509.Certificate
+	Hash        crypto.Hash
+	Digest      []byte // digest of the content
+	ContentType asn1.ObjectIdentifier
+	Content     []byte // encapsulated content, signature is detached if nil
+	SigningTime time.Time
+	RSAOptions  rsaSignatureOptions
+	// OmitCertificate leaves signer certificate out of SignedData certificates
+	OmitCertificate bool
+}
+
+// hashAlgorithmFromOID returns the hash algorithm of the digest algorithm identifier.
+func hashAlgorithmFromOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for hash, hashOID := range hashOIDs {
+		if hashOID.Equal(oid) {
+			return hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %s", oid)
+}
+
+func hashAlgorithmIdentifier(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	oid, ok := hashOIDs[hash]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported hash algorithm %s", hash)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid}, nil
+}
+
+func cmsSignatureAlgorithm(key *SigningKey, hash crypto.Hash, options rsaSignatureOptions) (pkix.AlgorithmIdentifier, error) {
+	switch key.Algorithm {
+	case KeyAlgorithmRSA:
+		if options.Method != signatureMethodPSS {
+			return pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}, nil
+		}
+
+		options = options.resolve(hash)
+		hashAlgorithm := pkix.AlgorithmIdentifier{Algorithm: hashOIDs[hash], Parameters: asn1.NullRawValue}
+		mgfHashAlgorithm, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: hashOIDs[hash], Parameters: asn1.NullRawValue})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, err
+		}
+		parameters, err := asn1.Marshal(rsaPSSParameters{
+			HashAlgorithm:    hashAlgorithm,
+			MaskGenAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfHashAlgorithm}},
+			SaltLength:       options.SaltLength,
+		})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, err
+		}
+		return pkix.AlgorithmIdentifier{Algorithm: oidRSASSAPSS, Parameters: asn1.RawValue{FullBytes: parameters}}, nil
+	case KeyAlgorithmECDSA:
+		oid, ok := ecdsaSignatureOIDs[hash]
+		if !ok {
+			return pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported hash algorithm %s", hash)
+		}
+		return pkix.AlgorithmIdentifier{Algorithm: oid}, nil
+	default:
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("%s keys are not supported for CMS signatures", key.Algorithm)
+	}
+}
+
+func newCMSAttribute(attributeType asn1.ObjectIdentifier, value interface{}) (cmsAttribute, error) {
+	valueBytes, err := asn1.Marshal(value)
+	if err != nil {
+		return cmsAttribute{}, err
+	}
+	return cmsAttribute{
+		Type:   attributeType,
+		Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: valueBytes},
+	}, nil
+}
+
+// marshalAttributes returns DER encoded attributes sorted as required for SET OF.
+func marshalAttributes(attributes []cmsAttribute) ([]byte, error) {
+	encoded := make([][]byte, 0, len(attributes))
+	for _, attribute := range attributes {
+		attributeBytes, err := asn1.Marshal(attribute)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attributeBytes)
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	return bytes.Join(encoded, nil), nil
+}
+
+func signingCertificateV2Attribute(certificate *x509.Certificate) (cmsAttribute, error) {
+	certHash := sha256.Sum256(certificate.Raw)
+	return newCMSAttribute(oidAttributeSigningCertificateV2, essSigningCertificateV2{
+		Certs: []essCertIDv2{{
+			CertHash: certHash[:],
+			IssuerSerial: essIssuerSerial{
+				Issuer:       []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: certificate.RawIssuer}},
+				SerialNumber: certificate.SerialNumber,
+			},
+		}},
+	})
+}
+
+// createSignedData creates CMS ContentInfo with SignedData signed with the key.
+// Signed attributes contain content-type, message-digest, signing-time and
+// ESS signing-certificate-v2, as required for CAdES-BES.
+func createSignedData(parameters cmsSignerParameters) ([]byte, error) {
+	if parameters.Certificate == nil {
+		return nil, errors.New("signing certificate is required")
+	}
+	if len(parameters.Digest) != parameters.Hash.Size() {
+		return nil, errors.New("digest length does not match hash algorithm")
+	}
+
+	digestAlgorithm, err := hashAlgorithmIdentifier(parameters.Hash)
+	if err != nil {
+		return nil, err
+	}
+	signatureAlgorithm, err := cmsSignatureAlgorithm(parameters.Key, parameters.Hash, parameters.RSAOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	contentTypeAttribute, err := newCMSAttribute(oidAttributeContentType, parameters.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	messageDigestAttribute, err := newCMSAttribute(oidAttributeMessageDigest, parameters.Digest)
+	if err != nil {
+		return nil, err
+	}
+	signingTimeAttribute, err := newCMSAttribute(oidAttributeSigningTime, parameters.SigningTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+	signingCertificateAttribute, err := signingCertificateV2Attribute(parameters.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	signedAttributesBytes, err := marshalAttributes([]cmsAttribute{contentTypeAttribute, messageDigestAttribute, signingTimeAttribute, signingCertificateAttribute})
+	if err != nil {
+		return nil, err
+	}
+
+	// Signature is calculated over signed attributes encoded as SET OF
+	signedAttributesSet, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttributesBytes})
+	if err != nil {
+		return nil, err
+	}
+	h := parameters.Hash.New()
+	h.Write(signedAttributesSet)
+	signature, err := signDigestWithKey(parameters.Key, parameters.Hash, h.Sum(nil), parameters.RSAOptions, "DER")
+	if err != nil {
+		return nil, err
+	}
+
+	signerInfo := cmsSignerInfo{
+		Version: 1,
+		SID: cmsIssuerAndSerialNumber{
+			Issuer:       asn1.RawValue{FullBytes: parameters.Certificate.RawIssuer},
+			SerialNumber: parameters.Certificate.SerialNumber,
+		},
+		DigestAlgorithm:    digestAlgorithm,
+		SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttributesBytes},
+		SignatureAlgorithm: signatureAlgorithm,
+		Signature:          signature,
+	}
+
+	encapContentInfo := cmsEncapsulatedContentInfo{EContentType: parameters.ContentType}
+	if parameters.Content != nil {
+		eContent, err := asn1.Marshal(parameters.Content)
+		if err != nil {
+			return nil, err
+		}
+		encapContentInfo.EContent = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: eContent}
+	}
+
+	// Version 3 is required for other content types than id-data
+	version := 1
+	if !parameters.ContentType.Equal(oidData) {
+		version = 3
+	}
+
+	signedData := &cmsSignedData{
+		Version:          version,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		EncapContentInfo: encapContentInfo,
+		SignerInfos:      []cmsSignerInfo{signerInfo},
+	}
+	if !parameters.OmitCertificate {
+		signedData.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: parameters.Certificate.Raw}
+	}
+	return marshalSignedData(signedData)
+}
+
+// marshalSignedData returns CMS ContentInfo with the SignedData.
+func marshalSignedData(signedData *cmsSignedData) ([]byte, error) {
+	signedDataBytes, err := asn1.Marshal(*signedData)
+	if err != nil {
+		return nil, err
+	}
+
+	// RawValue is written as is, so the explicit tag is added here
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedDataBytes},
+	})
+}
+
+// parseSignedData parses CMS ContentInfo with SignedData.
+func parseSignedData(der []byte) (*cmsSignedData, error) {
+	var contentInfo cmsContentInfo
+	rest, err := asn1.Unmarshal(der, &contentInfo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CMS: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("invalid CMS: trailing data")
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("CMS content is not SignedData")
+	}
+
+	var signedData cmsSignedData
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("invalid CMS SignedData: %w", err)
+	}
+	return &signedData, nil
+}
+
+// signerCertificate returns certificate of the signer from SignedData certificates.
+func (signedData *cmsSignedData) signerCertificate(signerInfo cmsSignerInfo) (*x509.Certificate, error) {
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in CMS: %w", err)
+	}
+	for _, certificate := range certificates {
+		if bytes.Equal(certificate.RawIssuer, signerInfo.SID.Issuer.FullBytes) && certificate.SerialNumber.Cmp(signerInfo.SID.SerialNumber) == 0 {
+			return certificate, nil
+		}
+	}
+	return nil, errors.New("signer certificate not found in CMS")
+}
+
+// signedAttribute returns the first value of the signed attribute.
+func (signerInfo cmsSignerInfo) signedAttribute(attributeType asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	return attributeValue(signerInfo.SignedAttrs.Bytes, attributeType)
+}
+
+// unsignedAttribute returns the first value of the unsigned attribute.
+func (signerInfo cmsSignerInfo) unsignedAttribute(attributeType asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	return attributeValue(signerInfo.UnsignedAttrs.Bytes, attributeType)
+}
+
+func attributeValue(attributes []byte, attributeType asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	rest := attributes
+	for len(rest) > 0 {
+		var attribute cmsAttribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attribute); err != nil {
+			return asn1.RawValue{}, false
+		}
+		if !attribute.Type.Equal(attributeType) {
+			continue
+		}
+		var value asn1.RawValue
+		if _, err := asn1.Unmarshal(attribute.Values.Bytes, &value); err != nil {
+			return asn1.RawValue{}, false
+		}
+		return value, true
+	}
+	return asn1.RawValue{}, false
+}
+
+// signingTime returns signing-time attribute of the signer.
+func (signerInfo cmsSignerInfo) signingTime() (time.Time, bool) {
+	value, ok := signerInfo.signedAttribute(oidAttributeSigningTime)
+	if !ok {
+		return time.Time{}, false
+	}
+	var signingTime time.Time
+	if _, err := asn1.Unmarshal(value.FullBytes, &signingTime); err != nil {
+		return time.Time{}, false
+	}
+	return signingTime, true
+}
+
+// verifySignerInfo checks message-digest attribute against the content and
+// signature over signed attributes with the certificate.
+func verifySignerInfo(signerInfo cmsSignerInfo, certificate *x509.Certificate, content []byte) error {
+	hash, err := hashAlgorithmFromOID(signerInfo.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	if len(signerInfo.SignedAttrs.FullBytes) == 0 {
+		return errors.New("CMS signer has no signed attributes")
+	}
+
+	value, ok := signerInfo.signedAttribute(oidAttributeMessageDigest)
+	if !ok {
+		return errors.New("CMS signer has no message-digest attribute")
+	}
+	var messageDigest []byte
+	if _, err := asn1.Unmarshal(value.FullBytes, &messageDigest); err != nil {
+		return fmt.Errorf("invalid message-digest attribute: %w", err)
+	}
+	h := hash.New()
+	h.Write(content)
+	if !bytes.Equal(messageDigest, h.Sum(nil)) {
+		return errors.New("message-digest does not match the content")
+	}
+
+	// Signature is calculated over signed attributes encoded as SET OF
+	signedAttributesSet := append([]byte{}, signerInfo.SignedAttrs.FullBytes...)
+	signedAttributesSet[0] = asn1.TagSet | 0x20
+	h = hash.New()
+	h.Write(signedAttributesSet)
+	digest := h.Sum(nil)
+
+	switch publicKey := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if signerInfo.SignatureAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+			err = rsa.VerifyPSS(publicKey, hash, digest, signerInfo.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		} else {
+			err = rsa.VerifyPKCS1v15(publicKey, hash, digest, signerInfo.Signature)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(publicKey, digest, signerInfo.Signature) {
+			err = errors.New("ECDSA verification failed")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	if err != nil {
+		return fmt.Errorf("CMS signature is not valid: %w", err)
+	}
+	return nil
+}
+
+// addSignatureTimestamp adds signature-time-stamp-token unsigned attribute
+// over the signature value of the first signer, making CAdES-BES a CAdES-T.
+func addSignatureTimestamp(der []byte, timestamps *TimestampClient) ([]byte, error) {
+	signedData, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(signedData.SignerInfos) == 0 {
+		return nil, errors.New("CMS has no signers")
+	}
+	signerInfo := &signedData.SignerInfos[0]
+
+	hash, err := hashAlgorithmFromOID(signerInfo.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(signerInfo.Signature)
+	token, _, err := timestamps.Timestamp(hash, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := parseAttributes(signerInfo.UnsignedAttrs.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	timestampAttribute, err := newCMSAttribute(oidAttributeTimeStampToken, asn1.RawValue{FullBytes: token})
+	if err != nil {
+		return nil, err
+	}
+	unsignedAttributesBytes, err := marshalAttributes(append(attributes, timestampAttribute))
+	if err != nil {
+		return nil, err
+	}
+	signerInfo.UnsignedAttrs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: unsignedAttributesBytes}
+
+	return marshalSignedData(signedData)
+}
+
+func parseAttributes(attributesBytes []byte) ([]cmsAttribute, error) {
+	var attributes []cmsAttribute
+	for rest := attributesBytes; len(rest) > 0; {
+		var attribute cmsAttribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attribute); err != nil {
+			return nil, fmt.Errorf("invalid CMS attribute: %w", err)
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes, nil
+}
+
+func writeCmsResponse(w http.ResponseWriter, r *http.Request, signedData []byte, key *SigningKey, hash crypto.Hash, signingTime time.Time) {
+	var err error
+
+	switch r.URL.Query().Get("type") {
+	case "base64":
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(responses.CmsSignature{
+			Cms:           base64.StdEncoding.EncodeToString(signedData),
+			KeyId:         key.ID,
+			HashAlgorithm: hash.String(),
+			SigningTime:   signingTime.Format(time.RFC3339),
+		})
+	case "pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		err = pem.Encode(w, &pem.Block{Type: "CMS", Bytes: signedData})
+	default:
+		w.Header().Set("Content-Type", "application/pkcs7-signature")
+		_, err = w.Write(signedData)
+	}
+
+	if err != nil {
+		log.Printf("Error writing CMS response: %v", err)
+	}
+}
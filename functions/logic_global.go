@@ -23,9 +23,7 @@
 package functions
 
 import (
-	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/unknovs/hash-sign/env"
@@ -62,18 +60,3 @@ func APIKeyAuthorization(next http.HandlerFunc) http.HandlerFunc {
 // 		next(w, r)
 // 	}
 // }
-
-func CheckVolumeMounted(volumePath string) bool {
-	// Attempt to obtain information about the volume without creating a directory
-	if _, err := os.Stat(volumePath); err != nil {
-		if os.IsNotExist(err) {
-			log.Printf("Volume is not mounted at %s", volumePath)
-		} else {
-			log.Printf("Error checking volume: %v", err)
-		}
-		return false
-	}
-
-	log.Printf("Volume is mounted at %s", volumePath)
-	return true
-}
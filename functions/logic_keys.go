@@ -0,0 +1,443 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/unknovs/hash-sign/env"
+)
+
+const (
+	KeyAlgorithmRSA     = "RSA"
+	KeyAlgorithmECDSA   = "ECDSA"
+	KeyAlgorithmEd25519 = "Ed25519"
+)
+
+// Operations a registered key can be allowed to perform.
+const (
+	OperationSign  = "sign"
+	OperationCMS   = "cms"
+	OperationXAdES = "xades"
+	OperationTSA   = "tsa"
+)
+
+// allOperations are allowed for keys without configured operations. TSA key
+// shall be dedicated, so OperationTSA is allowed only if configured.
+var allOperations = []string{OperationSign, OperationCMS, OperationXAdES}
+
+var knownOperations = append(slices.Clone(allOperations), OperationTSA)
+
+// Key IDs used for the keys loaded from PEM_FILE and EC_PEM_FILE.
+const (
+	legacyRSAKeyID   = "rsa"
+	legacyECDSAKeyID = "ecdsa"
+	legacyEdDSAKeyID = "eddsa"
+)
+
+type SigningKey struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	Certificate string // base64 encoded DER, same form as certificates in environment
+	Operations  []string
+}
+
+func (k *SigningKey) Allows(operation string) bool {
+	return slices.Contains(k.Operations, operation)
+}
+
+type KeyRegistry struct {
+	keys map[string]*SigningKey
+	ids  []string
+}
+
+// keyConfigEntry is one key in the KEYS_CONFIG file.
+type keyConfigEntry struct {
+	ID              string   `json:"id"`
+	Algorithm       string   `json:"algorithm"`
+	KeyFile         string   `json:"keyFile"`
+	Certificate     string   `json:"certificate"`
+	CertificateFile string   `json:"certificateFile"`
+	Operations      []string `json:"operations"`
+}
+
+func NewKeyRegistry() *KeyRegistry {
+	return &KeyRegistry{keys: map[string]*SigningKey{}}
+}
+
+func (r *KeyRegistry) Add(key *SigningKey) error {
+	if key.ID == "" {
+		return fmt.Errorf("key ID is empty")
+	}
+	if _, exists := r.keys[key.ID]; exists {
+		return fmt.Errorf("duplicate key ID %s", key.ID)
+	}
+
+	if key.Algorithm == "" {
+		key.Algorithm = keyAlgorithm(key.PrivateKey)
+	}
+	if key.Algorithm == "" {
+		return fmt.Errorf("unsupported private key type %T for key %s", key.PrivateKey, key.ID)
+	}
+	if len(key.Operations) == 0 {
+		key.Operations = allOperations
+	}
+	for _, operation := range key.Operations {
+		if !slices.Contains(knownOperations, operation) {
+			return fmt.Errorf("unknown operation %s for key %s", operation, key.ID)
+		}
+	}
+
+	if key.Certificate != "" {
+		if err := checkKeyCertificate(key); err != nil {
+			return err
+		}
+	}
+
+	r.keys[key.ID] = key
+	r.ids = append(r.ids, key.ID)
+	return nil
+}
+
+func (r *KeyRegistry) Get(id string) (*SigningKey, bool) {
+	if r == nil {
+		return nil, false
+	}
+	key, ok := r.keys[id]
+	return key, ok
+}
+
+// Keys returns registered keys in the order they were added.
+func (r *KeyRegistry) Keys() []*SigningKey {
+	if r == nil {
+		return nil
+	}
+	keys := make([]*SigningKey, 0, len(r.ids))
+	for _, id := range r.ids {
+		keys = append(keys, r.keys[id])
+	}
+	return keys
+}
+
+// Default returns the key used when the request has no keyId: the key loaded
+// from environment if present, otherwise the first key of the algorithm
+// allowing the operation.
+func (r *KeyRegistry) Default(algorithm, operation string) *SigningKey {
+	legacyIDs := map[string]string{
+		KeyAlgorithmRSA:     legacyRSAKeyID,
+		KeyAlgorithmECDSA:   legacyECDSAKeyID,
+		KeyAlgorithmEd25519: legacyEdDSAKeyID,
+	}
+	if key, ok := r.Get(legacyIDs[algorithm]); ok && key.Algorithm == algorithm && key.Allows(operation) {
+		return key
+	}
+
+	for _, key := range r.Keys() {
+		if key.Algorithm == algorithm && key.Allows(operation) {
+			return key
+		}
+	}
+	return nil
+}
+
+// LoadKeyRegistry registers keys from PEM_FILE, EC_PEM_FILE and ED_PEM_FILE
+// together with keys from KEYS_DIR and KEYS_CONFIG.
+func LoadKeyRegistry(rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey, edKey ed25519.PrivateKey) (*KeyRegistry, error) {
+	registry := NewKeyRegistry()
+
+	if rsaKey != nil {
+		if err := addLegacyKey(registry, legacyRSAKeyID, rsaKey, env.RsaSigningCert); err != nil {
+			return registry, err
+		}
+	}
+	if ecKey != nil {
+		if err := addLegacyKey(registry, legacyECDSAKeyID, ecKey, env.EcdsaSigningCert); err != nil {
+			return registry, err
+		}
+	}
+	if edKey != nil {
+		if err := addLegacyKey(registry, legacyEdDSAKeyID, edKey, ""); err != nil {
+			return registry, err
+		}
+	}
+
+	if env.KeysDir != "" {
+		if err := loadKeysFromDir(registry, env.KeysDir); err != nil {
+			return registry, err
+		}
+	}
+	if env.KeysConfig != "" {
+		if err := loadKeysFromConfig(registry, env.KeysConfig); err != nil {
+			return registry, err
+		}
+	}
+
+	for _, key := range registry.Keys() {
+		log.Printf("Key %s (%s) loaded for operations: %s", key.ID, key.Algorithm, strings.Join(key.Operations, ", "))
+	}
+
+	return registry, nil
+}
+
+// addLegacyKey registers key from PEM_FILE, EC_PEM_FILE or ED_PEM_FILE. If the
+// certificate does not match the key, the key is registered without it.
+func addLegacyKey(registry *KeyRegistry, id string, key crypto.Signer, certificate string) error {
+	if certificate != "" {
+		err := checkKeyCertificate(&SigningKey{ID: id, PrivateKey: key, Certificate: certificate})
+		if err == nil {
+			return registry.Add(&SigningKey{ID: id, PrivateKey: key, Certificate: certificate})
+		}
+		log.Printf("Signing certificate not linked to key %s: %s", id, err)
+	}
+	return registry.Add(&SigningKey{ID: id, PrivateKey: key})
+}
+
+// loadKeysFromDir registers every <id>.key file in dir. A certificate in
+// <id>.crt next to the key is linked to it.
+func loadKeysFromDir(registry *KeyRegistry, dir string) error {
+	keyFiles, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	if err != nil {
+		return fmt.Errorf("failed to list key directory: %w", err)
+	}
+
+	for _, keyFile := range keyFiles {
+		id := strings.TrimSuffix(filepath.Base(keyFile), ".key")
+		key, err := readPrivateKeyFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", id, err)
+		}
+
+		signingKey := &SigningKey{ID: id, PrivateKey: key}
+		certificateFile := strings.TrimSuffix(keyFile, ".key") + ".crt"
+		if _, err := os.Stat(certificateFile); err == nil {
+			signingKey.Certificate, err = readCertificateFile(certificateFile)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", id, err)
+			}
+		}
+
+		if err := registry.Add(signingKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadKeysFromConfig(registry *KeyRegistry, filename string) error {
+	configBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read key config: %w", err)
+	}
+
+	var entries []keyConfigEntry
+	if err := json.Unmarshal(configBytes, &entries); err != nil {
+		return fmt.Errorf("failed to parse key config: %w", err)
+	}
+
+	for _, entry := range entries {
+		key, err := readPrivateKeyFile(entry.KeyFile)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", entry.ID, err)
+		}
+
+		signingKey := &SigningKey{
+			ID:          entry.ID,
+			PrivateKey:  key,
+			Certificate: entry.Certificate,
+			Operations:  entry.Operations,
+		}
+		if entry.CertificateFile != "" {
+			signingKey.Certificate, err = readCertificateFile(entry.CertificateFile)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", entry.ID, err)
+			}
+		}
+
+		algorithm := keyAlgorithm(key)
+		if entry.Algorithm != "" && !strings.EqualFold(entry.Algorithm, algorithm) {
+			return fmt.Errorf("key %s is %s, but %s configured", entry.ID, algorithm, entry.Algorithm)
+		}
+
+		if err := registry.Add(signingKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyCertificate returns parsed certificate linked to the key.
+func keyCertificate(key *SigningKey) (*x509.Certificate, error) {
+	if key.Certificate == "" {
+		return nil, fmt.Errorf("certificate for key %s not found", key.ID)
+	}
+	return parseCertificate(key.Certificate)
+}
+
+// signDigestWithKey signs a digest with RSA or ECDSA key. ECDSA signatures are
+// encoded with ecdsaSignatureMethod, DER or P1363.
+func signDigestWithKey(key *SigningKey, hash crypto.Hash, digest []byte, options rsaSignatureOptions, ecdsaSignatureMethod string) ([]byte, error) {
+	switch privateKey := key.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return signRSA(privateKey, hash, digest, options)
+	case *ecdsa.PrivateKey:
+		return signEcdsaHash(privateKey, digest, ecdsaSignatureMethod)
+	default:
+		return nil, fmt.Errorf("%s keys can't sign a digest", key.Algorithm)
+	}
+}
+
+func keyAlgorithm(key crypto.Signer) string {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return KeyAlgorithmRSA
+	case *ecdsa.PrivateKey:
+		return KeyAlgorithmECDSA
+	case ed25519.PrivateKey:
+		return KeyAlgorithmEd25519
+	default:
+		return ""
+	}
+}
+
+// readPrivateKeyFile reads an unencrypted PKCS#1, SEC 1 or PKCS#8 private key in PEM format.
+func readPrivateKeyFile(filename string) (crypto.Signer, error) {
+	pemBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// readCertificateFile reads a PEM or DER certificate and returns it base64 encoded.
+func readCertificateFile(filename string) (string, error) {
+	certificateBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate file: %w", err)
+	}
+
+	if block, _ := pem.Decode(certificateBytes); block != nil {
+		certificateBytes = block.Bytes
+	}
+	if _, err := x509.ParseCertificate(certificateBytes); err != nil {
+		return "", fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(certificateBytes), nil
+}
+
+func checkKeyCertificate(key *SigningKey) error {
+	certificate, err := parseCertificate(key.Certificate)
+	if err != nil {
+		return fmt.Errorf("key %s: %w", key.ID, err)
+	}
+
+	publicKey, ok := key.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(certificate.PublicKey) {
+		return fmt.Errorf("certificate of key %s does not match private key", key.ID)
+	}
+	return nil
+}
+
+// selectKey picks the key named in keyId query parameter or the default key
+// of the first algorithm that has one. On failure the error is written to w.
+func selectKey(w http.ResponseWriter, r *http.Request, keys *KeyRegistry, operation string, algorithms ...string) (*SigningKey, bool) {
+	keyId := r.URL.Query().Get("keyId")
+	if keyId == "" {
+		for _, algorithm := range algorithms {
+			if key := keys.Default(algorithm, operation); key != nil {
+				return key, true
+			}
+		}
+		http.Error(w, fmt.Sprintf("%s Private key not loaded", strings.Join(algorithms, " or ")), http.StatusNotFound)
+		return nil, false
+	}
+
+	key, ok := keys.Get(keyId)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Key %s not found", keyId), http.StatusNotFound)
+		return nil, false
+	}
+	if !slices.Contains(algorithms, key.Algorithm) {
+		http.Error(w, fmt.Sprintf("Key %s is not %s key", keyId, strings.Join(algorithms, " or ")), http.StatusBadRequest)
+		return nil, false
+	}
+	if !key.Allows(operation) {
+		http.Error(w, fmt.Sprintf("Key %s is not allowed to %s", keyId, operation), http.StatusForbidden)
+		return nil, false
+	}
+
+	return key, true
+}
+
+// getKeyAlgorithms returns key algorithms allowed by the key query parameter.
+func getKeyAlgorithms(r *http.Request) ([]string, error) {
+	switch r.URL.Query().Get("key") {
+	case "":
+		return []string{KeyAlgorithmRSA, KeyAlgorithmECDSA}, nil
+	case "rsa":
+		return []string{KeyAlgorithmRSA}, nil
+	case "ecdsa":
+		return []string{KeyAlgorithmECDSA}, nil
+	default:
+		return nil, errors.New("invalid 'key' parameter, use 'rsa' or 'ecdsa'")
+	}
+}
@@ -0,0 +1,228 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// generateTestCertificate returns a base64 encoded self-signed certificate for the key
+func generateTestCertificate(t *testing.T, privateKey crypto.Signer) string {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Test Signer", Country: []string{"LV"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+	}
+
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(certificateBytes)
+}
+
+func writeTestKeyFile(t *testing.T, filename string, privateKey crypto.Signer) {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})
+	if err := os.WriteFile(filename, pemBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeysFromDir(t *testing.T) {
+	fmt.Println("!!! Starting key registry tests on logic_keys.go !!!")
+	dir := t.TempDir()
+	rsaKey := generateTestRSAKey(t)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	writeTestKeyFile(t, filepath.Join(dir, "company-seal.key"), rsaKey)
+	writeTestKeyFile(t, filepath.Join(dir, "employee.key"), ecKey)
+
+	certificate := generateTestCertificate(t, ecKey)
+	certificateBytes, _ := base64.StdEncoding.DecodeString(certificate)
+	pemCertificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "employee.crt"), pemCertificate, 0600))
+
+	registry := NewKeyRegistry()
+	assert.NoError(t, loadKeysFromDir(registry, dir))
+
+	seal, ok := registry.Get("company-seal")
+	assert.True(t, ok)
+	assert.Equal(t, KeyAlgorithmRSA, seal.Algorithm)
+	assert.Empty(t, seal.Certificate)
+
+	employee, ok := registry.Get("employee")
+	assert.True(t, ok)
+	assert.Equal(t, KeyAlgorithmECDSA, employee.Algorithm)
+	assert.Equal(t, certificate, employee.Certificate)
+	assert.True(t, employee.Allows(OperationSign))
+}
+
+func TestLoadKeysFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	writeTestKeyFile(t, filepath.Join(dir, "ec.pem"), ecKey)
+
+	config := fmt.Sprintf(`[{"id": "ec", "algorithm": "ECDSA", "keyFile": "%s", "certificate": "%s", "operations": ["sign"]}]`,
+		filepath.Join(dir, "ec.pem"), generateTestCertificate(t, ecKey))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "keys.json"), []byte(config), 0600))
+
+	registry := NewKeyRegistry()
+	assert.NoError(t, loadKeysFromConfig(registry, filepath.Join(dir, "keys.json")))
+
+	key, ok := registry.Get("ec")
+	assert.True(t, ok)
+	assert.Equal(t, []string{OperationSign}, key.Operations)
+}
+
+func TestKeyRegistryRejectsMismatchingCertificate(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	registry := NewKeyRegistry()
+	err := registry.Add(&SigningKey{ID: "ec", PrivateKey: ecKey, Certificate: generateTestCertificate(t, otherKey)})
+	assert.Error(t, err)
+}
+
+func TestLegacyKeyGetters(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey := generateTestRSAKey(t)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeTestKeyFile(t, filepath.Join(dir, "rsa.pem"), rsaKey)
+	writeTestKeyFile(t, filepath.Join(dir, "ec.pem"), ecKey)
+	writeTestKeyFile(t, filepath.Join(dir, "ed.pem"), edKey)
+
+	// PKCS#1 RSA key, as PEM_FILE was read before
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "rsa-pkcs1.pem"), pkcs1, 0600))
+
+	loadedRSA, err := GetPrivateKey(filepath.Join(dir, "rsa-pkcs1.pem"))
+	assert.NoError(t, err)
+	assert.True(t, rsaKey.Equal(loadedRSA))
+	loadedRSA, err = GetPrivateKey(filepath.Join(dir, "rsa.pem"))
+	assert.NoError(t, err)
+	assert.True(t, rsaKey.Equal(loadedRSA))
+	loadedEC, err := GetECPrivateKey(filepath.Join(dir, "ec.pem"))
+	assert.NoError(t, err)
+	assert.True(t, ecKey.Equal(loadedEC))
+	loadedEd, err := GetEdPrivateKey(filepath.Join(dir, "ed.pem"))
+	assert.NoError(t, err)
+	assert.True(t, edKey.Equal(loadedEd))
+
+	_, err = GetPrivateKey(filepath.Join(dir, "ec.pem"))
+	assert.ErrorContains(t, err, "not RSA")
+	_, err = GetECPrivateKey(filepath.Join(dir, "ed.pem"))
+	assert.ErrorContains(t, err, "not ECDSA")
+	_, err = GetEdPrivateKey(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+
+	loadedRSA, err = GetPrivateKey("")
+	assert.NoError(t, err)
+	assert.Nil(t, loadedRSA)
+}
+
+func TestAddLegacyKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	// Key is registered without certificate which does not match it
+	registry := NewKeyRegistry()
+	assert.NoError(t, addLegacyKey(registry, legacyECDSAKeyID, ecKey, generateTestCertificate(t, otherKey)))
+	key, ok := registry.Get(legacyECDSAKeyID)
+	if assert.True(t, ok) {
+		assert.Empty(t, key.Certificate)
+	}
+
+	// Registry errors are returned
+	err := addLegacyKey(registry, legacyECDSAKeyID, ecKey, generateTestCertificate(t, ecKey))
+	assert.ErrorContains(t, err, "duplicate key ID")
+}
+
+func TestSigningHandlerSelectsKeyById(t *testing.T) {
+	firstKey := generateTestRSAKey(t)
+	secondKey := generateTestRSAKey(t)
+	registry := newTestKeyRegistry(t, firstKey, secondKey)
+	hash := make([]byte, 32)
+	body := fmt.Sprintf(`{"hash": "%s"}`, base64.StdEncoding.EncodeToString(hash))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign?keyId=key1", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(registry)(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&secondKey.PublicKey, crypto.SHA256, hash, signature))
+
+	req = httptest.NewRequest(http.MethodPost, "/digest/sign?keyId=unknown", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	SigningHandler(registry)(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestCertificatesHandlerListsKeys(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certificate := generateTestCertificate(t, ecKey)
+	registry := NewKeyRegistry()
+	assert.NoError(t, registry.Add(&SigningKey{ID: "employee", PrivateKey: ecKey, Certificate: certificate}))
+
+	req := httptest.NewRequest(http.MethodGet, "/certificates?keyId=employee", nil)
+	rr := httptest.NewRecorder()
+	CertificatesHandler(registry)(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.CertificatesResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response.Keys, 1)
+	assert.Equal(t, "employee", response.Keys[0].KeyId)
+	assert.Equal(t, certificate, response.Keys[0].Certificate)
+}
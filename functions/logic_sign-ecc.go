@@ -23,19 +23,16 @@
 package functions
 
 import (
-	"bufio"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
-	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"log"
 	"math/big"
 	"net/http"
-	"os"
 
 	"github.com/unknovs/hash-sign/routes/requests"
 	"github.com/unknovs/hash-sign/routes/responses"
@@ -48,37 +45,10 @@ func GetECPrivateKey(filename string) (*ecdsa.PrivateKey, error) {
 		return nil, nil
 	}
 
-	// Read the PEM file
-	keyFile, err := os.Open(filename)
+	key, err := readPrivateKeyFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open ECC private key file: %w", err)
+		return nil, fmt.Errorf("failed to load ECC private key: %w", err)
 	}
-	defer keyFile.Close()
-
-	// Read the PEM file content
-	keyFileInfo, _ := keyFile.Stat()
-	keySize := keyFileInfo.Size()
-	keyBytes := make([]byte, keySize)
-
-	buffer := bufio.NewReader(keyFile)
-	_, err = buffer.Read(keyBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read ECC private key file: %w", err)
-	}
-
-	// Decode PEM block
-	block, _ := pem.Decode(keyBytes)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode PEM block")
-	}
-
-	// Parse PKCS#8 private key
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse ECC PKCS#8 private key: %w", err)
-	}
-
-	// Convert to ECDSA private key
 	ecKey, ok := key.(*ecdsa.PrivateKey)
 	if !ok {
 		return nil, fmt.Errorf("parsed key is not ECDSA private key")
@@ -88,11 +58,24 @@ func GetECPrivateKey(filename string) (*ecdsa.PrivateKey, error) {
 }
 
 func decodeHash(signEcdsa requests.SignEcdsa) ([]byte, error) {
+	// Hash the data on server or check the hash against the named algorithm
+	if signEcdsa.Data != "" || signEcdsa.HashAlgorithm != "" {
+		_, hashBytes, err := requestDigest(signEcdsa.DigestToSign, signEcdsa.Data, signEcdsa.HashAlgorithm)
+		if err != nil {
+			log.Printf("Failed to get hash to sign: %s", err)
+			return nil, err
+		}
+		return hashBytes, nil
+	}
+
 	// Decode the hash from base64
 	hashBytes, err := base64.StdEncoding.DecodeString(signEcdsa.DigestToSign)
 	if err != nil {
 		log.Printf("Failed to decode hash from base64: %s", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to decode hash from base64")
+	}
+	if len(hashBytes) == 0 {
+		return nil, fmt.Errorf("hash is empty")
 	}
 	return hashBytes, nil
 }
@@ -107,6 +90,51 @@ func signHash(privateKey *ecdsa.PrivateKey, hashBytes []byte) (*big.Int, *big.In
 	return signatureR, signatureS, nil
 }
 
+// signEcdsaHash signs the hash and encodes the signature with the signature method.
+func signEcdsaHash(privateKey *ecdsa.PrivateKey, hashBytes []byte, signatureMethod string) ([]byte, error) {
+	signatureR, signatureS, err := signHash(privateKey, hashBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeSignature(signatureMethod, signatureR, signatureS, privateKey)
+}
+
+// signEcdsaBatch signs every hash in the batch. A failed item gets an error
+// in its response and does not stop signing of the other items.
+func signEcdsaBatch(privateKey *ecdsa.PrivateKey, signEcdsaRequests []requests.SignEcdsa, signatureMethod string) []responses.HashSignature {
+	hashSignatures := make([]responses.HashSignature, 0, len(signEcdsaRequests))
+
+	for _, signEcdsa := range signEcdsaRequests {
+		hashSignature := responses.HashSignature{
+			SessionId:       signEcdsa.SessionId,
+			SignatureMethod: signatureMethod,
+			Hash:            signEcdsa.DigestToSign,
+		}
+
+		hashBytes, err := decodeHash(signEcdsa)
+		if err != nil {
+			hashSignature.Error = err.Error()
+			hashSignatures = append(hashSignatures, hashSignature)
+			continue
+		}
+		hashSignature.Hash = base64.StdEncoding.EncodeToString(hashBytes)
+		hashSignature.HashAlgorithm = ecdsaHashAlgorithm(signEcdsa)
+
+		signature, err := signEcdsaHash(privateKey, hashBytes, signatureMethod)
+		if err != nil {
+			hashSignature.Error = "Error signing hash"
+			hashSignatures = append(hashSignatures, hashSignature)
+			continue
+		}
+
+		hashSignature.SignatureValue = base64.StdEncoding.EncodeToString(signature)
+		hashSignatures = append(hashSignatures, hashSignature)
+	}
+
+	return hashSignatures
+}
+
 func encodeSignature(signatureMethod string, signatureR, signatureS *big.Int, privateKey *ecdsa.PrivateKey) ([]byte, error) {
 	var signature []byte
 	var err error
@@ -148,22 +176,22 @@ func encodeSignature(signatureMethod string, signatureR, signatureS *big.Int, pr
 	return signature, nil
 }
 
-func validateRequest(w http.ResponseWriter, r *http.Request, privateKey *ecdsa.PrivateKey) bool {
+func validateRequest(w http.ResponseWriter, r *http.Request, keys *KeyRegistry) (*ecdsa.PrivateKey, bool) {
 	if !isPostMethod(r) {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return false
+		return nil, false
 	}
 
-	if privateKey == nil {
-		http.Error(w, "ECC Private key not loaded", http.StatusNotFound)
-		return false
+	signingKey, ok := selectKey(w, r, keys, OperationSign, KeyAlgorithmECDSA)
+	if !ok {
+		return nil, false
 	}
 
-	return true
+	return signingKey.PrivateKey.(*ecdsa.PrivateKey), true
 }
 
-func decodeJSON(w http.ResponseWriter, r *http.Request, signEcdsa *requests.SignEcdsa) bool {
-	err := json.NewDecoder(r.Body).Decode(signEcdsa)
+func decodeJSON(w http.ResponseWriter, bodyBytes []byte, signEcdsaRequests *[]requests.SignEcdsa) bool {
+	err := json.Unmarshal(bodyBytes, signEcdsaRequests)
 	if err != nil {
 		log.Printf("Failed to decode JSON: %s", err)
 		http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
@@ -185,12 +213,27 @@ func getSignatureMethod(r *http.Request) string {
 	return signatureMethod
 }
 
-func sendResponse(w http.ResponseWriter, signEcdsa requests.SignEcdsa, signature []byte, signatureMethod string) {
+// ecdsaHashAlgorithm returns hash algorithm for response if it is known.
+func ecdsaHashAlgorithm(signEcdsa requests.SignEcdsa) string {
+	if signEcdsa.HashAlgorithm != "" {
+		if hash, err := parseHashAlgorithm(signEcdsa.HashAlgorithm); err == nil {
+			return hash.String()
+		}
+	}
+	if signEcdsa.Data != "" {
+		return crypto.SHA256.String()
+	}
+	return ""
+}
+
+func sendResponse(w http.ResponseWriter, signEcdsa requests.SignEcdsa, hashBytes, signature []byte, signatureMethod string) {
 	signatureValue := base64.StdEncoding.EncodeToString(signature)
 
 	hashSignature := responses.HashSignature{
+		SessionId:       signEcdsa.SessionId,
 		SignatureMethod: signatureMethod,
-		Hash:            signEcdsa.DigestToSign,
+		HashAlgorithm:   ecdsaHashAlgorithm(signEcdsa),
+		Hash:            base64.StdEncoding.EncodeToString(hashBytes),
 		SignatureValue:  signatureValue,
 	}
 
@@ -203,3 +246,20 @@ func sendResponse(w http.ResponseWriter, signEcdsa requests.SignEcdsa, signature
 		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
 	}
 }
+
+func sendBatchResponse(w http.ResponseWriter, hashSignatures []responses.HashSignature) {
+	for _, hashSignature := range hashSignatures {
+		if hashSignature.Error != "" {
+			log.Printf("Hash value: %v not signed: %s", hashSignature.Hash, hashSignature.Error)
+			continue
+		}
+		log.Printf("Hash value: %v signed using %s method", hashSignature.Hash, hashSignature.SignatureMethod)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(hashSignatures)
+	if err != nil {
+		log.Printf("Failed to encode JSON: %s", err)
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+	}
+}
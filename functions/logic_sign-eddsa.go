@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+const (
+	signatureMethodEd25519   = "Ed25519"
+	signatureMethodEd25519ph = "Ed25519ph"
+)
+
+func GetEdPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	//Lets check, if PEM file is added to env
+	if filename == "" {
+		log.Println("Ed25519 Private key is not loaded.")
+		return nil, nil
+	}
+
+	key, err := readPrivateKeyFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Ed25519 private key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("parsed key is not Ed25519 private key")
+	}
+
+	return edKey, nil
+}
+
+func getEdDSASignatureMethod(r *http.Request) (string, error) {
+	signatureMethod := r.URL.Query().Get("SignatureMethod")
+	if signatureMethod == "" {
+		signatureMethod = r.URL.Query().Get("signatureMethod")
+	}
+
+	switch signatureMethod {
+	case "", signatureMethodEd25519:
+		return signatureMethodEd25519, nil
+	case signatureMethodEd25519ph:
+		return signatureMethodEd25519ph, nil
+	default:
+		return "", fmt.Errorf("invalid signature method, use '%s' or '%s'", signatureMethodEd25519, signatureMethodEd25519ph)
+	}
+}
+
+// signEdDSA signs raw data with pure Ed25519 or a SHA-512 prehash with
+// Ed25519ph. For Ed25519ph the prehash is calculated from data if no hash
+// is provided. It returns the signature and the signed prehash.
+func signEdDSA(privateKey ed25519.PrivateKey, signatureMethod string, signEddsa requests.SignEddsa) ([]byte, string, error) {
+	if signatureMethod == signatureMethodEd25519ph && signEddsa.Hash != "" {
+		digest, err := decodeBase64(signEddsa.Hash)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(digest) != sha512.Size {
+			return nil, "", errors.New("hash for Ed25519ph shall be SHA-512 digest")
+		}
+		return signEdDSAPrehash(privateKey, digest)
+	}
+
+	if signEddsa.Data == "" {
+		if signatureMethod == signatureMethodEd25519ph {
+			return nil, "", errors.New("hash or data is required for Ed25519ph")
+		}
+		return nil, "", errors.New("data is required for Ed25519")
+	}
+	data, err := decodeData(signEddsa.Data)
+	if err != nil {
+		return nil, "", err
+	}
+	return signEdDSAData(privateKey, signatureMethod, data)
+}
+
+// signEdDSAData signs data with pure Ed25519 or its SHA-512 prehash with
+// Ed25519ph.
+func signEdDSAData(privateKey ed25519.PrivateKey, signatureMethod string, data []byte) ([]byte, string, error) {
+	switch signatureMethod {
+	case signatureMethodEd25519:
+		return ed25519.Sign(privateKey, data), "", nil
+	case signatureMethodEd25519ph:
+		sum := sha512.Sum512(data)
+		return signEdDSAPrehash(privateKey, sum[:])
+	default:
+		return nil, "", fmt.Errorf("invalid signature method: %s", signatureMethod)
+	}
+}
+
+func signEdDSAPrehash(privateKey ed25519.PrivateKey, digest []byte) ([]byte, string, error) {
+	signature, err := privateKey.Sign(rand.Reader, digest, &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		return nil, "", err
+	}
+	return signature, base64.StdEncoding.EncodeToString(digest), nil
+}
+
+// readRequestData reads binary request body. Pure Ed25519 needs the whole
+// data, so body is limited to maxDataSize bytes.
+func readRequestData(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDataSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return data, nil
+}
+
+func sendEdDSAResponse(w http.ResponseWriter, signEddsa requests.SignEddsa, hash string, signature []byte, signatureMethod string) {
+	hashSignature := responses.HashSignature{
+		SessionId:       signEddsa.SessionId,
+		SignatureMethod: signatureMethod,
+		Hash:            hash,
+		SignatureValue:  base64.StdEncoding.EncodeToString(signature),
+	}
+
+	log.Printf("Data signed using %s method", signatureMethod)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(hashSignature)
+	if err != nil {
+		log.Printf("Failed to encode JSON: %s", err)
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+	}
+}
+
+// verifyEd25519Signature checks pure Ed25519 over the value first and,
+// for 64 byte values, Ed25519ph with the value as SHA-512 prehash.
+func verifyEd25519Signature(pub ed25519.PublicKey, value, signatureBytes []byte) error {
+	if ed25519.Verify(pub, value, signatureBytes) {
+		return nil
+	}
+
+	if len(value) == sha512.Size {
+		if ed25519.VerifyWithOptions(pub, value, signatureBytes, &ed25519.Options{Hash: crypto.SHA512}) == nil {
+			return nil
+		}
+	}
+
+	return errors.New("Ed25519 verification failed")
+}
@@ -0,0 +1,268 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxDataSize limits binary request body signed with server-side hashing.
+const maxDataSize = 10 << 20
+
+const (
+	signatureMethodPKCS1v15 = "PKCS1v15"
+	signatureMethodPSS      = "PSS"
+)
+
+// rsaSignatureOptions holds the RSA padding scheme requested by the client.
+// SaltLength is used only with PSS. MGF1 always uses the hash of the digest,
+// crypto/rsa has no other mask generation hash.
+type rsaSignatureOptions struct {
+	Method     string
+	SaltLength int
+}
+
+// parseHashAlgorithm accepts names like "SHA-256", "sha256" or "SHA256".
+func parseHashAlgorithm(name string) (crypto.Hash, error) {
+	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(name), "-", ""))
+	switch normalized {
+	case "SHA224":
+		return crypto.SHA224, nil
+	case "SHA256":
+		return crypto.SHA256, nil
+	case "SHA384":
+		return crypto.SHA384, nil
+	case "SHA512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported hash algorithm: %s", name)
+	}
+}
+
+// resolveHashAlgorithm returns the hash the digest was made with. If the
+// algorithm is not named in the request it is inferred from the digest length.
+func resolveHashAlgorithm(name string, digest []byte) (crypto.Hash, error) {
+	if name == "" {
+		switch len(digest) {
+		case crypto.SHA224.Size():
+			return crypto.SHA224, nil
+		case crypto.SHA256.Size():
+			return crypto.SHA256, nil
+		case crypto.SHA384.Size():
+			return crypto.SHA384, nil
+		case crypto.SHA512.Size():
+			return crypto.SHA512, nil
+		default:
+			return 0, fmt.Errorf("cannot infer hash algorithm from digest length %d", len(digest))
+		}
+	}
+
+	hash, err := parseHashAlgorithm(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(digest) != hash.Size() {
+		return 0, fmt.Errorf("digest length %d does not match %s", len(digest), hash)
+	}
+	return hash, nil
+}
+
+// decodeDigest decodes a base64 digest and resolves its hash algorithm.
+func decodeDigest(encodedDigest, hashAlgorithm string) (crypto.Hash, []byte, error) {
+	digest, err := base64.StdEncoding.DecodeString(encodedDigest)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to decode hash from base64: %w", err)
+	}
+
+	hash, err := resolveHashAlgorithm(hashAlgorithm, digest)
+	if err != nil {
+		return 0, nil, err
+	}
+	return hash, digest, nil
+}
+
+// requestDigest returns the digest to sign. It is either the decoded hash or,
+// if data is sent instead, the digest of data calculated on the server.
+func requestDigest(encodedHash, encodedData, hashAlgorithm string) (crypto.Hash, []byte, error) {
+	if encodedData == "" {
+		return decodeDigest(encodedHash, hashAlgorithm)
+	}
+	if encodedHash != "" {
+		return 0, nil, errors.New("use either hash or data, not both")
+	}
+
+	data, err := decodeData(encodedData)
+	if err != nil {
+		return 0, nil, err
+	}
+	return hashData(data, hashAlgorithm)
+}
+
+// decodeData decodes base64 data of JSON request. Data is limited to
+// maxDataSize bytes, the same as binary body.
+func decodeData(encodedData string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encodedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data from base64: %w", err)
+	}
+	if len(data) > maxDataSize {
+		return nil, fmt.Errorf("data is larger than %d bytes", maxDataSize)
+	}
+	return data, nil
+}
+
+// hashData calculates digest of data, SHA-256 is used if algorithm is not set.
+func hashData(data []byte, hashAlgorithm string) (crypto.Hash, []byte, error) {
+	hash := crypto.SHA256
+	if hashAlgorithm != "" {
+		var err error
+		hash, err = parseHashAlgorithm(hashAlgorithm)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	h := hash.New()
+	h.Write(data)
+	return hash, h.Sum(nil), nil
+}
+
+func isBinaryRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/octet-stream"
+}
+
+// hashRequestBody hashes binary request body with algorithm from hashAlgorithm
+// query parameter. Body is limited to maxDataSize bytes.
+func hashRequestBody(w http.ResponseWriter, r *http.Request) (crypto.Hash, []byte, error) {
+	hash := crypto.SHA256
+	if hashAlgorithm := r.URL.Query().Get("hashAlgorithm"); hashAlgorithm != "" {
+		var err error
+		hash, err = parseHashAlgorithm(hashAlgorithm)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	h := hash.New()
+	if _, err := io.Copy(h, http.MaxBytesReader(w, r.Body, maxDataSize)); err != nil {
+		return 0, nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return hash, h.Sum(nil), nil
+}
+
+func getRSASignatureOptions(r *http.Request) (rsaSignatureOptions, error) {
+	query := r.URL.Query()
+
+	method := query.Get("SignatureMethod")
+	if method == "" {
+		method = query.Get("signatureMethod")
+	}
+
+	return parseRSASignatureOptions(method, query.Get("saltLength"))
+}
+
+// parseRSASignatureOptions reads the padding scheme. Salt length is read only
+// for PSS.
+func parseRSASignatureOptions(method, saltLength string) (rsaSignatureOptions, error) {
+	options := rsaSignatureOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+
+	switch strings.ToUpper(method) {
+	case "", strings.ToUpper(signatureMethodPKCS1v15):
+		options.Method = signatureMethodPKCS1v15
+		return options, nil
+	case signatureMethodPSS:
+		options.Method = signatureMethodPSS
+	default:
+		return options, fmt.Errorf("invalid signature method, use '%s' or '%s'", signatureMethodPKCS1v15, signatureMethodPSS)
+	}
+
+	if saltLength != "" {
+		// crypto/rsa treats salt length 0 as auto detected length, so empty
+		// salt can't be requested
+		value, err := strconv.Atoi(saltLength)
+		if err != nil || value < 1 {
+			return options, fmt.Errorf("invalid saltLength: %s, salt length shall be at least 1", saltLength)
+		}
+		options.SaltLength = value
+	}
+
+	return options, nil
+}
+
+// resolve fills in the PSS default for the hash the digest was made with:
+// the salt is as long as the digest.
+func (o rsaSignatureOptions) resolve(hash crypto.Hash) rsaSignatureOptions {
+	if o.Method != signatureMethodPSS {
+		return o
+	}
+	if o.SaltLength == rsa.PSSSaltLengthEqualsHash {
+		o.SaltLength = hash.Size()
+	}
+	return o
+}
+
+// check tells if PSS options can be used with the RSA key and the hash. The
+// salt shall fit into the key. Options of other keys are not checked.
+func (o rsaSignatureOptions) check(publicKey crypto.PublicKey, hash crypto.Hash) error {
+	pub, ok := publicKey.(*rsa.PublicKey)
+	if !ok || o.Method != signatureMethodPSS {
+		return nil
+	}
+
+	o = o.resolve(hash)
+	maxSaltLength := (pub.N.BitLen()-1+7)/8 - hash.Size() - 2
+	if o.SaltLength > maxSaltLength {
+		return fmt.Errorf("invalid saltLength: %d, maximum for %d bit key and %s is %d", o.SaltLength, pub.N.BitLen(), hash, maxSaltLength)
+	}
+	return nil
+}
+
+func signRSA(privateKey *rsa.PrivateKey, hash crypto.Hash, digest []byte, options rsaSignatureOptions) ([]byte, error) {
+	if err := options.check(&privateKey.PublicKey, hash); err != nil {
+		return nil, err
+	}
+	options = options.resolve(hash)
+
+	switch options.Method {
+	case signatureMethodPKCS1v15:
+		return rsa.SignPKCS1v15(rand.Reader, privateKey, hash, digest)
+	case signatureMethodPSS:
+		return rsa.SignPSS(rand.Reader, privateKey, hash, digest, &rsa.PSSOptions{
+			SaltLength: options.SaltLength,
+			Hash:       hash,
+		})
+	default:
+		return nil, fmt.Errorf("invalid signature method: %s", options.Method)
+	}
+}
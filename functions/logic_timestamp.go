@@ -0,0 +1,354 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	timestampQueryMimeType = "application/timestamp-query"
+	timestampReplyMimeType = "application/timestamp-reply"
+
+	timestampTimeout      = 30 * time.Second
+	maxTimestampReplySize = 1 << 20
+)
+
+var oidTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+// errTimestampNotConfigured is returned when timestamp is requested without TSA.
+var errTimestampNotConfigured = errors.New("timestamping is not configured, TSA_URL is not set")
+
+// PKIStatus values of the time-stamp response
+const (
+	pkiStatusGranted         = 0
+	pkiStatusGrantedWithMods = 1
+)
+
+// timeStampReq is RFC 3161 TimeStampReq.
+type timeStampReq struct {
+	Version        int
+	MessageImprint tstMessageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+// timeStampResp is RFC 3161 TimeStampResp, token is CMS ContentInfo.
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+// tstInfo is RFC 3161 TSTInfo, content of the time-stamp token.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tstMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       tstAccuracy      `asn1:"optional"`
+	Ordering       bool             `asn1:"optional"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,explicit,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+type tstMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tstAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// isTimestampToken checks if the SignedData holds TSTInfo.
+func isTimestampToken(signedData *cmsSignedData) bool {
+	return signedData.EncapContentInfo.EContentType.Equal(oidTSTInfo)
+}
+
+// parseTSTInfo returns TSTInfo of the time-stamp token.
+func parseTSTInfo(signedData *cmsSignedData) (*tstInfo, error) {
+	if !isTimestampToken(signedData) {
+		return nil, errors.New("CMS content is not TSTInfo")
+	}
+
+	var content []byte
+	if _, err := asn1.Unmarshal(signedData.EncapContentInfo.EContent.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("invalid TSTInfo content: %w", err)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return nil, fmt.Errorf("invalid TSTInfo: %w", err)
+	}
+	return &info, nil
+}
+
+// isTimestampRequested checks if the signature shall get a signature
+// time-stamp, CAdES-T or XAdES-T.
+func isTimestampRequested(r *http.Request) bool {
+	return r.URL.Query().Get("timestamp") == "true"
+}
+
+// checkTimestampRequest answers with 400 if timestamp is requested, but TSA
+// is not configured.
+func checkTimestampRequest(w http.ResponseWriter, r *http.Request, timestamps *TimestampClient) bool {
+	if isTimestampRequested(r) && timestamps == nil {
+		http.Error(w, errTimestampNotConfigured.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeTimestampError answers with 502 as the signature could not be
+// timestamped by the TSA.
+func writeTimestampError(w http.ResponseWriter, err error) {
+	log.Printf("Error timestamping signature: %s", err)
+	http.Error(w, fmt.Sprintf("Error timestamping signature: %s", err), http.StatusBadGateway)
+}
+
+// TimestampClient requests RFC 3161 time-stamp tokens from the TSA.
+type TimestampClient struct {
+	URL        string
+	HTTPClient *http.Client
+	// TrustedCertificate is TSA certificate or its issuer. If set, TSA
+	// certificate of the token shall match or chain to it.
+	TrustedCertificate *x509.Certificate
+	// TrustStore is used when TrustedCertificate is not set, TSA certificate
+	// of the token shall chain to one of its roots.
+	TrustStore *x509.CertPool
+}
+
+// NewTimestampClient returns client for the TSA, nil if url is empty.
+// trustedCertificate is base64 encoded DER, same form as certificates in
+// environment. Without it TSA certificates are checked against trustStore,
+// error is returned if neither is set.
+func NewTimestampClient(url, trustedCertificate string, trustStore *x509.CertPool) (*TimestampClient, error) {
+	if url == "" {
+		return nil, nil
+	}
+
+	client := &TimestampClient{URL: url, HTTPClient: &http.Client{Timeout: timestampTimeout}, TrustStore: trustStore}
+	if trustedCertificate != "" {
+		certificate, err := parseCertificate(trustedCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TSA_CERT: %w", err)
+		}
+		client.TrustedCertificate = certificate
+	}
+	if client.TrustedCertificate == nil && client.TrustStore == nil {
+		return nil, errors.New("TSA_CERT or TRUST_STORE shall be set to check TSA certificates")
+	}
+	return client, nil
+}
+
+// Timestamp requests time-stamp token for the digest and returns the token
+// after checking response status, message imprint, nonce and TSA signature.
+func (c *TimestampClient) Timestamp(hash crypto.Hash, digest []byte) ([]byte, *tstInfo, error) {
+	if c == nil {
+		return nil, nil, errTimestampNotConfigured
+	}
+	if len(digest) != hash.Size() {
+		return nil, nil, errors.New("digest length does not match hash algorithm")
+	}
+
+	request, nonce, err := newTimestampRequest(hash, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := c.HTTPClient.Post(c.URL, timestampQueryMimeType, bytes.NewReader(request))
+	if err != nil {
+		return nil, nil, fmt.Errorf("TSA request failed: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("TSA responded with status %s", response.Status)
+	}
+	reply, err := io.ReadAll(io.LimitReader(response.Body, maxTimestampReplySize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read TSA response: %w", err)
+	}
+
+	token, err := parseTimestampResponse(reply)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := verifyTimestampToken(token, hash, digest, nonce, c.TrustedCertificate, c.TrustStore)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, info, nil
+}
+
+// newTimestampRequest returns DER encoded TimeStampReq with a random nonce.
+// TSA certificate is requested to check signature of the token.
+func newTimestampRequest(hash crypto.Hash, digest []byte) ([]byte, *big.Int, error) {
+	hashAlgorithm, err := hashAlgorithmIdentifier(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: tstMessageImprint{HashAlgorithm: hashAlgorithm, HashedMessage: digest},
+		Nonce:          nonce,
+		CertReq:        true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return request, nonce, nil
+}
+
+// parseTimestampResponse returns the token of granted TimeStampResp.
+func parseTimestampResponse(reply []byte) ([]byte, error) {
+	var response timeStampResp
+	rest, err := asn1.Unmarshal(reply, &response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSA response: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("invalid TSA response: trailing data")
+	}
+
+	status := response.Status
+	if status.Status != pkiStatusGranted && status.Status != pkiStatusGrantedWithMods {
+		return nil, fmt.Errorf("TSA rejected the request with status %d %v", status.Status, status.StatusString)
+	}
+	if len(response.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("TSA response has no time-stamp token")
+	}
+	return response.TimeStampToken.FullBytes, nil
+}
+
+// verifyTimestampToken checks that the token is issued for the digest and
+// nonce and signed by a TSA certificate valid at the time of the token.
+func verifyTimestampToken(token []byte, hash crypto.Hash, digest []byte, nonce *big.Int, trustedCertificate *x509.Certificate, trustStore *x509.CertPool) (*tstInfo, error) {
+	signedData, err := parseSignedData(token)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseTSTInfo(signedData)
+	if err != nil {
+		return nil, err
+	}
+
+	hashAlgorithm, err := hashAlgorithmIdentifier(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(hashAlgorithm.Algorithm) || !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, errors.New("time-stamp token message imprint does not match the request")
+	}
+	if nonce != nil && (info.Nonce == nil || info.Nonce.Cmp(nonce) != 0) {
+		return nil, errors.New("time-stamp token nonce does not match the request")
+	}
+
+	if len(signedData.SignerInfos) != 1 {
+		return nil, errors.New("time-stamp token shall have exactly one signer")
+	}
+	signerInfo := signedData.SignerInfos[0]
+	certificate, err := signedData.signerCertificate(signerInfo)
+	if err != nil {
+		return nil, err
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(signedData.EncapContentInfo.EContent.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("invalid TSTInfo content: %w", err)
+	}
+	if err := verifySignerInfo(signerInfo, certificate, content); err != nil {
+		return nil, err
+	}
+	if err := checkTSACertificate(certificate, signedData, info.GenTime, trustedCertificate, trustStore); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// checkTSACertificate checks the TSA certificate is meant for timestamping,
+// valid at genTime and is the trusted certificate or issued by it. Without the
+// trusted certificate it shall chain to a root of the trust store.
+func checkTSACertificate(certificate *x509.Certificate, signedData *cmsSignedData, genTime time.Time, trustedCertificate *x509.Certificate, trustStore *x509.CertPool) error {
+	if !slices.Contains(certificate.ExtKeyUsage, x509.ExtKeyUsageTimeStamping) {
+		return errors.New("TSA certificate is not meant for timestamping")
+	}
+	if genTime.Before(certificate.NotBefore) || genTime.After(certificate.NotAfter) {
+		return errors.New("TSA certificate is not valid at the time of the token")
+	}
+
+	roots := trustStore
+	if trustedCertificate != nil {
+		if certificate.Equal(trustedCertificate) {
+			return nil
+		}
+		roots = x509.NewCertPool()
+		roots.AddCert(trustedCertificate)
+	}
+	if roots == nil {
+		return errors.New("TSA certificate is not trusted: no trusted certificates configured")
+	}
+	intermediates := x509.NewCertPool()
+	if certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes); err == nil {
+		for _, intermediate := range certificates {
+			intermediates.AddCert(intermediate)
+		}
+	}
+	_, err := certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   genTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	if err != nil {
+		return fmt.Errorf("TSA certificate is not trusted: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"archive/zip"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testTSA is a stand-in RFC 3161 time-stamp authority.
+type testTSA struct {
+	key         *SigningKey
+	certificate *x509.Certificate
+	status      int
+	modify      func(info *tstInfo)
+}
+
+func newTestTSA(t *testing.T, extKeyUsage ...x509.ExtKeyUsage) *testTSA {
+	privateKey := generateTestRSAKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Test TSA", Country: []string{"LV"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  extKeyUsage,
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testTSA{
+		key:         &SigningKey{ID: "tsa", Algorithm: KeyAlgorithmRSA, PrivateKey: privateKey},
+		certificate: certificate,
+	}
+}
+
+func (tsa *testTSA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var request timeStampReq
+	if _, err := asn1.Unmarshal(body, &request); err != nil || r.Header.Get("Content-Type") != timestampQueryMimeType {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	response := timeStampResp{Status: pkiStatusInfo{Status: tsa.status}}
+	if tsa.status == pkiStatusGranted {
+		info := tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3},
+			MessageImprint: request.MessageImprint,
+			SerialNumber:   big.NewInt(1),
+			GenTime:        time.Now().UTC().Truncate(time.Second),
+			Nonce:          request.Nonce,
+		}
+		if tsa.modify != nil {
+			tsa.modify(&info)
+		}
+		content, _ := asn1.Marshal(info)
+		digest := sha256.Sum256(content)
+		token, err := createSignedData(cmsSignerParameters{
+			Key:         tsa.key,
+			Certificate: tsa.certificate,
+			Hash:        crypto.SHA256,
+			Digest:      digest[:],
+			ContentType: oidTSTInfo,
+			Content:     content,
+			SigningTime: info.GenTime,
+			RSAOptions:  rsaSignatureOptions{Method: signatureMethodPKCS1v15},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.TimeStampToken = asn1.RawValue{FullBytes: token}
+	} else {
+		response.Status.StatusString = []string{"request rejected"}
+	}
+
+	reply, _ := asn1.Marshal(response)
+	w.Header().Set("Content-Type", timestampReplyMimeType)
+	w.Write(reply)
+}
+
+// newTestTimestampClient starts the TSA and returns client trusting its certificate.
+func newTestTimestampClient(t *testing.T, tsa *testTSA) *TimestampClient {
+	server := httptest.NewServer(tsa)
+	t.Cleanup(server.Close)
+	client, err := NewTimestampClient(server.URL, base64.StdEncoding.EncodeToString(tsa.certificate.Raw), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestTimestampClient(t *testing.T) {
+	fmt.Println("!!! Starting timestamping tests on logic_timestamp.go !!!")
+	client := newTestTimestampClient(t, newTestTSA(t, x509.ExtKeyUsageTimeStamping))
+	digest := sha256.Sum256([]byte("Hello, World!"))
+
+	token, info, err := client.Timestamp(crypto.SHA256, digest[:])
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, digest[:], info.MessageImprint.HashedMessage)
+	assert.NotNil(t, info.Nonce)
+	assert.WithinDuration(t, time.Now(), info.GenTime, time.Minute)
+
+	signedData, err := parseSignedData(token)
+	assert.NoError(t, err)
+	assert.True(t, isTimestampToken(signedData))
+	assert.Equal(t, 3, signedData.Version)
+}
+
+func TestTimestampClientChecks(t *testing.T) {
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	otherTSA := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+
+	tests := []struct {
+		name    string
+		tsa     *testTSA
+		trusted *x509.Certificate
+		err     string
+	}{
+		{
+			name: "rejected",
+			tsa:  &testTSA{status: 2},
+			err:  "TSA rejected the request with status 2",
+		},
+		{
+			name: "imprint",
+			tsa:  &testTSA{modify: func(info *tstInfo) { info.MessageImprint.HashedMessage = make([]byte, 32) }},
+			err:  "message imprint does not match",
+		},
+		{
+			name: "nonce",
+			tsa:  &testTSA{modify: func(info *tstInfo) { info.Nonce = big.NewInt(1) }},
+			err:  "nonce does not match",
+		},
+		{
+			name: "certificate usage",
+			tsa:  newTestTSA(t, x509.ExtKeyUsageCodeSigning),
+			err:  "TSA certificate is not meant for timestamping",
+		},
+		{
+			name:    "untrusted certificate",
+			tsa:     &testTSA{},
+			trusted: otherTSA.certificate,
+			err:     "TSA certificate is not trusted",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tsa := test.tsa
+			if tsa.key == nil {
+				validTSA := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+				tsa.key, tsa.certificate = validTSA.key, validTSA.certificate
+			}
+			client := newTestTimestampClient(t, tsa)
+			if test.trusted != nil {
+				client.TrustedCertificate = test.trusted
+			}
+
+			_, _, err := client.Timestamp(crypto.SHA256, digest[:])
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestTimestampClientTrustStore(t *testing.T) {
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	server := httptest.NewServer(tsa)
+	t.Cleanup(server.Close)
+
+	// TSA certificate can't be checked without trusted certificates
+	_, err := NewTimestampClient(server.URL, "", nil)
+	assert.ErrorContains(t, err, "TSA_CERT or TRUST_STORE")
+
+	// Self-signed timestamping certificate outside of the trust store is rejected
+	client, err := NewTimestampClient(server.URL, "", x509.NewCertPool())
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = client.Timestamp(crypto.SHA256, digest[:])
+	assert.ErrorContains(t, err, "TSA certificate is not trusted")
+
+	trustStore := x509.NewCertPool()
+	trustStore.AddCert(tsa.certificate)
+	client, err = NewTimestampClient(server.URL, "", trustStore)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _, err = client.Timestamp(crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+}
+
+func TestTimestampNotConfigured(t *testing.T) {
+	client, err := NewTimestampClient("", "", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, client)
+
+	req := httptest.NewRequest(http.MethodPost, "/cms/sign?timestamp=true", strings.NewReader(`{"digest": "aGFzaA=="}`))
+	rr := httptest.NewRecorder()
+	CmsSigningHandler(newTestCmsKeyRegistry(t, generateTestRSAKey(t)), nil)(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "TSA_URL")
+}
+
+func TestCmsSigningTimestamp(t *testing.T) {
+	client := newTestTimestampClient(t, newTestTSA(t, x509.ExtKeyUsageTimeStamping))
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	body := fmt.Sprintf(`{"digest": "%s"}`, base64.StdEncoding.EncodeToString(digest[:]))
+
+	req := httptest.NewRequest(http.MethodPost, "/cms/sign?timestamp=true", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	CmsSigningHandler(newTestCmsKeyRegistry(t, generateTestRSAKey(t)), client)(rr, req)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+
+	signedData, err := parseSignedData(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerInfo := signedData.SignerInfos[0]
+	certificate, err := signedData.signerCertificate(signerInfo)
+	assert.NoError(t, err)
+	assert.NoError(t, verifySignerInfo(signerInfo, certificate, []byte("Hello, World!")))
+
+	// Token covers the signature value
+	value, ok := signerInfo.unsignedAttribute(oidAttributeTimeStampToken)
+	if !assert.True(t, ok) {
+		return
+	}
+	signatureDigest := sha256.Sum256(signerInfo.Signature)
+	_, err = verifyTimestampToken(value.FullBytes, crypto.SHA256, signatureDigest[:], nil, client.TrustedCertificate, client.TrustStore)
+	assert.NoError(t, err)
+}
+
+func TestAsiceSigningTimestamp(t *testing.T) {
+	client := newTestTimestampClient(t, newTestTSA(t, x509.ExtKeyUsageTimeStamping))
+	privateKey := generateTestRSAKey(t)
+	container := newTestAsice(t, map[string]string{"test.txt": "Hello, World!"})
+	body := fmt.Sprintf(`{"container": "%s"}`, base64.StdEncoding.EncodeToString(container))
+
+	req := httptest.NewRequest(http.MethodPost, "/asice/sign?timestamp=true", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	AsiceSigningHandler(newTestCmsKeyRegistry(t, privateKey), client)(rr, req)
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	reader, err := zip.NewReader(strings.NewReader(rr.Body.String()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := verifyTestXadesSignature(t, reader, "META-INF/signatures0.xml", &privateKey.PublicKey)
+	signature := root.Element(xmlnsDS, "Signature")
+	timestamp := signature.FindByID("S0-T0")
+	if !assert.NotNil(t, timestamp) {
+		return
+	}
+	assert.Equal(t, "UnsignedProperties", timestamp.Parent.Parent.Local)
+	assert.Equal(t, xmlnsXAdES, timestamp.Namespace())
+
+	token, err := base64.StdEncoding.DecodeString(timestamp.Element(xmlnsXAdES, "EncapsulatedTimeStamp").Content())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatureValue, err := canonicalize(signature.Element(xmlnsDS, "SignatureValue"), c14nExclusive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(signatureValue)
+	_, err = verifyTimestampToken(token, crypto.SHA256, digest[:], nil, client.TrustedCertificate, client.TrustStore)
+	assert.NoError(t, err)
+
+	validation := validateTestAsice(t, rr.Body.Bytes())
+	assert.True(t, validation.Valid)
+}
+
+func TestTimestampRequestEncoding(t *testing.T) {
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	request, nonce, err := newTimestampRequest(crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded timeStampReq
+	_, err = asn1.Unmarshal(request, &decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, decoded.Version)
+	assert.True(t, decoded.CertReq)
+	assert.Equal(t, 0, nonce.Cmp(decoded.Nonce))
+	assert.True(t, decoded.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256))
+}
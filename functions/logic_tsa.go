@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PKIFailureInfo bits of rejected time-stamp requests
+const (
+	pkiFailureBadAlg              = 0
+	pkiFailureBadRequest          = 2
+	pkiFailureBadDataFormat       = 5
+	pkiFailureUnacceptedPolicy    = 15
+	pkiFailureUnacceptedExtension = 16
+)
+
+const pkiStatusRejection = 2
+
+var errTimestampAuthorityNotConfigured = errors.New("TSA mode is not configured, TSA_POLICY_OID is not set")
+
+// TimestampAuthority issues RFC 3161 time-stamp tokens with a dedicated key.
+type TimestampAuthority struct {
+	Key         *SigningKey
+	Certificate *x509.Certificate
+	Policy      asn1.ObjectIdentifier
+	Accuracy    time.Duration
+
+	mu     sync.Mutex
+	serial *big.Int
+}
+
+// NewTimestampAuthority returns TSA using the key allowing tsa operation,
+// nil if policy is not set. keyID selects the key if there are many.
+func NewTimestampAuthority(keys *KeyRegistry, keyID, policy, accuracy string) (*TimestampAuthority, error) {
+	if policy == "" {
+		return nil, nil
+	}
+	policyOID, err := parseObjectIdentifier(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSA policy: %w", err)
+	}
+
+	var accuracyDuration time.Duration
+	if accuracy != "" {
+		if accuracyDuration, err = time.ParseDuration(accuracy); err != nil || accuracyDuration < 0 {
+			return nil, fmt.Errorf("invalid TSA accuracy %q", accuracy)
+		}
+	}
+
+	key, err := timestampAuthorityKey(keys, keyID)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := keyCertificate(key)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 3161 requires the only extended key usage to be timeStamping
+	if !slices.Equal(certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}) {
+		return nil, fmt.Errorf("certificate of key %s is not a TSA certificate, timeStamping shall be its only extended key usage", key.ID)
+	}
+
+	return &TimestampAuthority{
+		Key:         key,
+		Certificate: certificate,
+		Policy:      policyOID,
+		Accuracy:    accuracyDuration,
+		// Serial starts from the start time, so it keeps growing after restart
+		serial: big.NewInt(time.Now().UnixNano()),
+	}, nil
+}
+
+func timestampAuthorityKey(keys *KeyRegistry, keyID string) (*SigningKey, error) {
+	if keyID != "" {
+		key, ok := keys.Get(keyID)
+		if !ok {
+			return nil, fmt.Errorf("TSA key %s not found", keyID)
+		}
+		if !key.Allows(OperationTSA) {
+			return nil, fmt.Errorf("key %s is not allowed for %s operation", keyID, OperationTSA)
+		}
+		return key, nil
+	}
+
+	for _, key := range keys.Keys() {
+		if key.Allows(OperationTSA) {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no key allowed for %s operation", OperationTSA)
+}
+
+// parseObjectIdentifier parses dotted OID, for example 1.2.3.4.
+func parseObjectIdentifier(value string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%q is not an object identifier", value)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.Atoi(part)
+		if err != nil || arc < 0 || strings.HasPrefix(part, "+") {
+			return nil, fmt.Errorf("%q is not an object identifier", value)
+		}
+		oid[i] = arc
+	}
+	return oid, nil
+}
+
+// nextSerial returns serial number of the next token, greater than all
+// numbers issued before.
+func (a *TimestampAuthority) nextSerial() *big.Int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.serial.Add(a.serial, big.NewInt(1))
+	return new(big.Int).Set(a.serial)
+}
+
+// accuracy returns TSTInfo accuracy, empty if it is not configured.
+func (a *TimestampAuthority) accuracy() tstAccuracy {
+	micros := a.Accuracy.Microseconds()
+	return tstAccuracy{
+		Seconds: int(micros / 1000000),
+		Millis:  int(micros / 1000 % 1000),
+		Micros:  int(micros % 1000),
+	}
+}
+
+// Respond returns DER encoded TimeStampResp for the DER encoded request.
+// Requests the TSA can't serve are answered with rejection status.
+func (a *TimestampAuthority) Respond(requestBytes []byte, now time.Time) ([]byte, error) {
+	response, err := a.respond(requestBytes, now)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(response)
+}
+
+func (a *TimestampAuthority) respond(requestBytes []byte, now time.Time) (timeStampResp, error) {
+	var request timeStampReq
+	rest, err := asn1.Unmarshal(requestBytes, &request)
+	if err != nil || len(rest) > 0 {
+		return timestampRejection(pkiFailureBadDataFormat, "request is not a valid TimeStampReq"), nil
+	}
+	if request.Version != 1 {
+		return timestampRejection(pkiFailureBadRequest, "unsupported request version"), nil
+	}
+	hash, err := hashAlgorithmFromOID(request.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return timestampRejection(pkiFailureBadAlg, err.Error()), nil
+	}
+	if len(request.MessageImprint.HashedMessage) != hash.Size() {
+		return timestampRejection(pkiFailureBadDataFormat, "message imprint length does not match hash algorithm"), nil
+	}
+	if request.ReqPolicy != nil && !request.ReqPolicy.Equal(a.Policy) {
+		return timestampRejection(pkiFailureUnacceptedPolicy, "requested policy is not supported"), nil
+	}
+	if len(request.Extensions) > 0 {
+		return timestampRejection(pkiFailureUnacceptedExtension, "request extensions are not supported"), nil
+	}
+
+	info := tstInfo{
+		Version:        1,
+		Policy:         a.Policy,
+		MessageImprint: request.MessageImprint,
+		SerialNumber:   a.nextSerial(),
+		GenTime:        now.UTC().Truncate(time.Second),
+		Accuracy:       a.accuracy(),
+		Nonce:          request.Nonce,
+	}
+	content, err := asn1.Marshal(info)
+	if err != nil {
+		return timeStampResp{}, err
+	}
+	digest := sha256.Sum256(content)
+	token, err := createSignedData(cmsSignerParameters{
+		Key:             a.Key,
+		Certificate:     a.Certificate,
+		Hash:            crypto.SHA256,
+		Digest:          digest[:],
+		ContentType:     oidTSTInfo,
+		Content:         content,
+		SigningTime:     info.GenTime,
+		RSAOptions:      rsaSignatureOptions{Method: signatureMethodPKCS1v15},
+		OmitCertificate: !request.CertReq,
+	})
+	if err != nil {
+		return timeStampResp{}, err
+	}
+
+	log.Printf("Time-stamp token %s issued with key %s", info.SerialNumber, a.Key.ID)
+	return timeStampResp{
+		Status:         pkiStatusInfo{Status: pkiStatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	}, nil
+}
+
+// timestampRejection returns response with the PKIFailureInfo bit set.
+func timestampRejection(failure int, reason string) timeStampResp {
+	log.Printf("Time-stamp request rejected: %s", reason)
+	failInfo := asn1.BitString{Bytes: make([]byte, failure/8+1), BitLength: failure + 1}
+	failInfo.Bytes[failure/8] = 0x80 >> (failure % 8)
+	return timeStampResp{Status: pkiStatusInfo{
+		Status:       pkiStatusRejection,
+		StatusString: []string{reason},
+		FailInfo:     failInfo,
+	}}
+}
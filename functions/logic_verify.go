@@ -0,0 +1,478 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// Checks reported by /digest/verify
+const (
+	verifyCheckSignature   = "signature"
+	verifyCheckValidity    = "validityPeriod"
+	verifyCheckKeyUsage    = "keyUsage"
+	verifyCheckExtKeyUsage = "extendedKeyUsage"
+	verifyCheckChain       = "chain"
+)
+
+var extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+	x509.ExtKeyUsageAny:             {2, 5, 29, 37, 0},
+	x509.ExtKeyUsageServerAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	x509.ExtKeyUsageClientAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	x509.ExtKeyUsageCodeSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	x509.ExtKeyUsageEmailProtection: {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	x509.ExtKeyUsageTimeStamping:    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	x509.ExtKeyUsageOCSPSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+}
+
+// extKeyUsageNames can be used in request instead of OIDs.
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// verifyOptions are certificate checks requested in addition to the signature.
+type verifyOptions struct {
+	Intermediates     []*x509.Certificate
+	ValidationTime    time.Time
+	ExtendedKeyUsages []asn1.ObjectIdentifier
+	SkipChain         bool
+}
+
+// LoadTrustStore reads root CA certificates from PEM bundle or from PEM and
+// DER certificate files in a directory. Returns nil if path is empty.
+func LoadTrustStore(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust store: %w", err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trust store: %w", err)
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".pem", ".crt", ".cer", ".der":
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	count := 0
+	for _, file := range files {
+		certificates, err := readCertificates(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, certificate := range certificates {
+			pool.AddCert(certificate)
+			count++
+		}
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no certificates found in trust store %s", path)
+	}
+
+	log.Printf("%d trusted certificates loaded from %s", count, path)
+	return pool, nil
+}
+
+// readCertificates reads all certificates of PEM file or a DER certificate.
+func readCertificates(filename string) ([]*x509.Certificate, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+
+	var certificates []*x509.Certificate
+	rest := content
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in %s: %w", filename, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) > 0 {
+		return certificates, nil
+	}
+
+	certificate, err := x509.ParseCertificate(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate in %s: %w", filename, err)
+	}
+	return []*x509.Certificate{certificate}, nil
+}
+
+// parseVerifyOptions reads intermediates, validation time and required
+// extended key usages of the request.
+func parseVerifyOptions(verifyBody requests.VerifyBody, certificates *certificateCache) (verifyOptions, error) {
+	options := verifyOptions{ValidationTime: time.Now(), SkipChain: verifyBody.SkipChainValidation}
+
+	for i, intermediate := range verifyBody.Intermediates {
+		certificate, err := certificates.parse(intermediate)
+		if err != nil {
+			return options, fmt.Errorf("intermediate %d: %w", i, err)
+		}
+		options.Intermediates = append(options.Intermediates, certificate)
+	}
+
+	if verifyBody.ValidationTime != "" {
+		validationTime, err := time.Parse(time.RFC3339, verifyBody.ValidationTime)
+		if err != nil {
+			return options, errors.New("validationTime shall be in RFC 3339 format, for example 2024-05-01T10:00:00Z")
+		}
+		options.ValidationTime = validationTime
+	}
+
+	for _, usage := range verifyBody.ExtendedKeyUsages {
+		if known, ok := extKeyUsageNames[usage]; ok {
+			options.ExtendedKeyUsages = append(options.ExtendedKeyUsages, extKeyUsageOIDs[known])
+			continue
+		}
+		oid, err := parseObjectIdentifier(usage)
+		if err != nil {
+			return options, fmt.Errorf("unknown extended key usage %s", usage)
+		}
+		options.ExtendedKeyUsages = append(options.ExtendedKeyUsages, oid)
+	}
+
+	return options, nil
+}
+
+// verifyMethod is the hash algorithm and signature method the signature is
+// verified with. Hash is zero if it is not known for ECDSA or not used for
+// Ed25519. Empty Method of ECDSA and Ed25519 keys means the format is
+// detected from the signature.
+type verifyMethod struct {
+	Hash   crypto.Hash
+	Method string
+	RSA    rsaSignatureOptions
+}
+
+// parseVerifyMethod checks the hash algorithm and signature method of the
+// request against the certificate key and the digest.
+func parseVerifyMethod(publicKey interface{}, verifyBody requests.VerifyBody, digestValue []byte) (verifyMethod, error) {
+	method := verifyMethod{Method: verifyBody.SignatureMethod}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		hash, err := resolveHashAlgorithm(verifyBody.HashAlgorithm, digestValue)
+		if err != nil {
+			return method, err
+		}
+		saltLength := ""
+		if verifyBody.SaltLength != nil {
+			saltLength = strconv.Itoa(*verifyBody.SaltLength)
+		}
+		options, err := parseRSASignatureOptions(verifyBody.SignatureMethod, saltLength)
+		if err != nil {
+			return method, err
+		}
+		method.Hash = hash
+		method.Method = options.Method
+		method.RSA = options.resolve(hash)
+		if err := options.check(publicKey, hash); err != nil {
+			return method, err
+		}
+	case *ecdsa.PublicKey:
+		if method.Method != "" && method.Method != "DER" && method.Method != "P1363" {
+			return method, errors.New("invalid signature method for ECDSA key, use 'P1363' or 'DER'")
+		}
+		// ECDSA digest of any length can be verified, hash is only reported
+		hash, err := resolveHashAlgorithm(verifyBody.HashAlgorithm, digestValue)
+		if err != nil && verifyBody.HashAlgorithm != "" {
+			return method, err
+		}
+		method.Hash = hash
+	case ed25519.PublicKey:
+		switch method.Method {
+		case "", signatureMethodEd25519:
+		case signatureMethodEd25519ph:
+			if len(digestValue) != sha512.Size {
+				return method, errors.New("Ed25519ph requires SHA-512 prehash as digestValue")
+			}
+			method.Hash = crypto.SHA512
+		default:
+			return method, fmt.Errorf("invalid signature method for Ed25519 key, use '%s' or '%s'", signatureMethodEd25519, signatureMethodEd25519ph)
+		}
+	}
+
+	return method, nil
+}
+
+// verifySignatureValue checks signature of the digest with the public key.
+// Returns format of the signature value.
+func verifySignatureValue(publicKey interface{}, digestValue, signatureBytes []byte, method verifyMethod) (string, error) {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		return method.Method, verifyRSASignature(pub, method.Hash, digestValue, signatureBytes, method.RSA)
+	case *ecdsa.PublicKey:
+		format := method.Method
+		if format == "" {
+			format = ecdsaSignatureFormat(signatureBytes)
+		}
+		return format, verifyECDSASignatureFormat(pub, digestValue, signatureBytes, format)
+	case ed25519.PublicKey:
+		switch method.Method {
+		case signatureMethodEd25519:
+			if !ed25519.Verify(pub, digestValue, signatureBytes) {
+				return method.Method, errors.New("Ed25519 verification failed")
+			}
+			return method.Method, nil
+		case signatureMethodEd25519ph:
+			if ed25519.VerifyWithOptions(pub, digestValue, signatureBytes, &ed25519.Options{Hash: crypto.SHA512}) != nil {
+				return method.Method, errors.New("Ed25519ph verification failed")
+			}
+			return method.Method, nil
+		}
+		if ed25519.Verify(pub, digestValue, signatureBytes) {
+			return signatureMethodEd25519, nil
+		}
+		if err := verifyEd25519Signature(pub, digestValue, signatureBytes); err != nil {
+			return signatureMethodEd25519, err
+		}
+		return signatureMethodEd25519ph, nil
+	default:
+		return "", fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+}
+
+// verifyRSASignature is the counterpart of signRSA.
+func verifyRSASignature(pub *rsa.PublicKey, hash crypto.Hash, digestValue, signatureBytes []byte, options rsaSignatureOptions) error {
+	switch options.Method {
+	case signatureMethodPKCS1v15:
+		return rsa.VerifyPKCS1v15(pub, hash, digestValue, signatureBytes)
+	case signatureMethodPSS:
+		// Salt length is at least 1, so crypto/rsa requires exactly that length
+		return rsa.VerifyPSS(pub, hash, digestValue, signatureBytes, &rsa.PSSOptions{
+			SaltLength: options.SaltLength,
+			Hash:       hash,
+		})
+	default:
+		return fmt.Errorf("invalid signature method: %s", options.Method)
+	}
+}
+
+// publicKeyAlgorithm names the algorithm of the certificate public key.
+func publicKeyAlgorithm(publicKey interface{}) string {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return KeyAlgorithmRSA
+	case *ecdsa.PublicKey:
+		return KeyAlgorithmECDSA
+	case ed25519.PublicKey:
+		return KeyAlgorithmEd25519
+	default:
+		return ""
+	}
+}
+
+// verifyDigestSignature verifies signature of the request and checks the
+// signer certificate. Certificates are parsed through the cache, if it is
+// set. Error is returned only if the request is invalid.
+func verifyDigestSignature(verifyBody requests.VerifyBody, certificates *certificateCache, trustStore *x509.CertPool) (responses.VerifyResult, error) {
+	certificate, err := certificates.parse(verifyBody.Certificate)
+	if err != nil {
+		return responses.VerifyResult{}, err
+	}
+
+	signatureBytes, err := decodeBase64(verifyBody.SignatureValue)
+	if err != nil {
+		return responses.VerifyResult{}, fmt.Errorf("Invalid signature value: %v", err)
+	}
+
+	digestValue, err := decodeBase64(verifyBody.DigestValue)
+	if err != nil {
+		return responses.VerifyResult{}, fmt.Errorf("Invalid digest value: %v", err)
+	}
+
+	options, err := parseVerifyOptions(verifyBody, certificates)
+	if err != nil {
+		return responses.VerifyResult{}, err
+	}
+
+	method, err := parseVerifyMethod(certificate.PublicKey, verifyBody, digestValue)
+	if err != nil {
+		return responses.VerifyResult{}, err
+	}
+
+	signatureFormat, err := verifySignatureValue(certificate.PublicKey, digestValue, signatureBytes, method)
+	checks := []responses.VerifyCheck{newVerifyCheck(verifyCheckSignature, err)}
+	result := verifyResult(certificate, method, signatureFormat, append(checks, certificateChecks(certificate, options, trustStore)...))
+	for _, check := range result.Checks {
+		if check.Name == verifyCheckChain {
+			result.ChainValidationSkipped = check.Status == responses.CheckSkipped
+		}
+	}
+	return result, nil
+}
+
+// certificateChecks checks the signer certificate. Chain is checked only if
+// trust store is configured and the check is not skipped on request.
+func certificateChecks(certificate *x509.Certificate, options verifyOptions, trustStore *x509.CertPool) []responses.VerifyCheck {
+	return []responses.VerifyCheck{
+		newVerifyCheck(verifyCheckValidity, checkValidityPeriod(certificate, options.ValidationTime)),
+		newVerifyCheck(verifyCheckKeyUsage, checkKeyUsage(certificate)),
+		checkExtendedKeyUsage(certificate, options.ExtendedKeyUsages),
+		checkChain(certificate, options, trustStore),
+	}
+}
+
+func newVerifyCheck(name string, err error) responses.VerifyCheck {
+	if err != nil {
+		return responses.VerifyCheck{Name: name, Status: responses.CheckFailed, Message: err.Error()}
+	}
+	return responses.VerifyCheck{Name: name, Status: responses.CheckPassed}
+}
+
+func checkValidityPeriod(certificate *x509.Certificate, validationTime time.Time) error {
+	if validationTime.Before(certificate.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", certificate.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if validationTime.After(certificate.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", certificate.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkKeyUsage requires digitalSignature or nonRepudiation, if certificate
+// has key usage extension.
+func checkKeyUsage(certificate *x509.Certificate) error {
+	if certificate.KeyUsage == 0 {
+		return nil
+	}
+	if certificate.KeyUsage&(x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment) == 0 {
+		return errors.New("certificate key usage allows neither digitalSignature nor nonRepudiation")
+	}
+	return nil
+}
+
+// checkExtendedKeyUsage requires one of the requested extended key usages.
+func checkExtendedKeyUsage(certificate *x509.Certificate, required []asn1.ObjectIdentifier) responses.VerifyCheck {
+	if len(required) == 0 {
+		return responses.VerifyCheck{Name: verifyCheckExtKeyUsage, Status: responses.CheckSkipped, Message: "no extended key usage requested"}
+	}
+
+	usages := slices.Clone(certificate.UnknownExtKeyUsage)
+	for _, usage := range certificate.ExtKeyUsage {
+		if oid, ok := extKeyUsageOIDs[usage]; ok {
+			usages = append(usages, oid)
+		}
+	}
+	for _, usage := range usages {
+		if usage.Equal(extKeyUsageOIDs[x509.ExtKeyUsageAny]) {
+			return newVerifyCheck(verifyCheckExtKeyUsage, nil)
+		}
+		for _, oid := range required {
+			if usage.Equal(oid) {
+				return newVerifyCheck(verifyCheckExtKeyUsage, nil)
+			}
+		}
+	}
+	return newVerifyCheck(verifyCheckExtKeyUsage, errors.New("certificate has none of requested extended key usages"))
+}
+
+// checkChain builds the chain from intermediates to a root of the trust store
+// at validation time. Without trust store the check fails, unless the chain
+// check is skipped on request.
+func checkChain(certificate *x509.Certificate, options verifyOptions, trustStore *x509.CertPool) responses.VerifyCheck {
+	if options.SkipChain {
+		return responses.VerifyCheck{Name: verifyCheckChain, Status: responses.CheckSkipped, Message: "chain validation skipped on request"}
+	}
+	if trustStore == nil {
+		return responses.VerifyCheck{Name: verifyCheckChain, Status: responses.CheckSkipped, Message: "trust store is not configured"}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range options.Intermediates {
+		intermediates.AddCert(intermediate)
+	}
+	_, err := certificate.Verify(x509.VerifyOptions{
+		Roots:         trustStore,
+		Intermediates: intermediates,
+		CurrentTime:   options.ValidationTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return newVerifyCheck(verifyCheckChain, err)
+}
+
+// verifyResult is valid if none of the checks failed. Messages of failed
+// checks are the reasons.
+func verifyResult(certificate *x509.Certificate, method verifyMethod, signatureFormat string, checks []responses.VerifyCheck) responses.VerifyResult {
+	result := responses.VerifyResult{
+		Valid:           true,
+		Algorithm:       publicKeyAlgorithm(certificate.PublicKey),
+		SignatureFormat: signatureFormat,
+		Signer:          certificateInfo(certificate),
+		Checks:          checks,
+	}
+	if method.Hash != 0 {
+		result.HashAlgorithm = method.Hash.String()
+	}
+	for _, check := range checks {
+		if check.Status == responses.CheckFailed {
+			result.Valid = false
+			result.Reasons = append(result.Reasons, fmt.Sprintf("%s: %s", check.Name, check.Message))
+		}
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// maxVerifyBatchSize limits number of items in one batch request.
+const maxVerifyBatchSize = 10000
+
+// maxVerifyBatchItemSize is JSON size of one item with signer certificate and
+// a few intermediates, batch body is limited to maxVerifyBatchSize such items.
+const maxVerifyBatchItemSize = 16 << 10
+
+// certificateCache keeps parsed certificates by SHA-256 fingerprint, so a
+// certificate repeated in the batch is parsed once. Nil cache parses every
+// certificate.
+type certificateCache struct {
+	mu           sync.Mutex
+	certificates map[[sha256.Size]byte]*x509.Certificate
+}
+
+func newCertificateCache() *certificateCache {
+	return &certificateCache{certificates: make(map[[sha256.Size]byte]*x509.Certificate)}
+}
+
+// parse decodes base64 certificate or returns it from the cache.
+func (c *certificateCache) parse(certificateStr string) (*x509.Certificate, error) {
+	if c == nil {
+		return parseCertificate(certificateStr)
+	}
+
+	certificateBytes, err := base64.StdEncoding.DecodeString(certificateStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+	fingerprint := sha256.Sum256(certificateBytes)
+
+	c.mu.Lock()
+	certificate, ok := c.certificates[fingerprint]
+	c.mu.Unlock()
+	if ok {
+		return certificate, nil
+	}
+
+	certificate, err = x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	c.mu.Lock()
+	c.certificates[fingerprint] = certificate
+	c.mu.Unlock()
+	return certificate, nil
+}
+
+// verifyBatch verifies items with at most workers goroutines. Results are in
+// the order of items, invalid item gets an error instead of the result.
+func verifyBatch(items []requests.VerifyBatchItem, trustStore *x509.CertPool, workers int) []responses.VerifyBatchResult {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(items))
+
+	certificates := newCertificateCache()
+	results := make([]responses.VerifyBatchResult, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].Id = items[i].Id
+				result, err := verifyDigestSignature(items[i].VerifyBody, certificates, trustStore)
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].VerifyResult = &result
+			}
+		}()
+	}
+
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,403 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// testCertificateChain is root CA, intermediate CA and signer certificate.
+type testCertificateChain struct {
+	root, intermediate, signer *x509.Certificate
+	signerKey                  *rsa.PrivateKey
+}
+
+func createTestCertificate(t *testing.T, template, parent *x509.Certificate, publicKey crypto.PublicKey, signerKey crypto.Signer) *x509.Certificate {
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	if parent == nil {
+		parent = template
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+// newTestCertificateChain issues signer certificate with the template
+// settings through an intermediate CA.
+func newTestCertificateChain(t *testing.T, signerTemplate *x509.Certificate) testCertificateChain {
+	rootKey := generateTestRSAKey(t)
+	intermediateKey := generateTestRSAKey(t)
+	chain := testCertificateChain{signerKey: generateTestRSAKey(t)}
+
+	caTemplate := func(name string) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:               pkix.Name{CommonName: name},
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+	chain.root = createTestCertificate(t, caTemplate("Test Root CA"), nil, rootKey.Public(), rootKey)
+	chain.intermediate = createTestCertificate(t, caTemplate("Test Intermediate CA"), chain.root, intermediateKey.Public(), rootKey)
+	signerTemplate.Subject = pkix.Name{CommonName: "Test Signer", Country: []string{"LV"}}
+	chain.signer = createTestCertificate(t, signerTemplate, chain.intermediate, chain.signerKey.Public(), intermediateKey)
+	return chain
+}
+
+// verifyTestSignature signs digest with the signer key and verifies it.
+func verifyTestSignature(t *testing.T, chain testCertificateChain, trustStore *x509.CertPool, options map[string]interface{}) (int, responses.VerifyResult) {
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, chain.signerKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{
+		"digestValue":    base64.StdEncoding.EncodeToString(digest[:]),
+		"signatureValue": base64.StdEncoding.EncodeToString(signature),
+		"certificate":    base64.StdEncoding.EncodeToString(chain.signer.Raw),
+	}
+	for key, value := range options {
+		body[key] = value
+	}
+	return postVerifyRequest(t, trustStore, body)
+}
+
+// postVerifyRequest sends the body to /digest/verify.
+func postVerifyRequest(t *testing.T, trustStore *x509.CertPool, body map[string]interface{}) (int, responses.VerifyResult) {
+	bodyBytes, _ := json.Marshal(body)
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/verify", strings.NewReader(string(bodyBytes)))
+	rr := httptest.NewRecorder()
+	VerifyHandler(trustStore)(rr, req)
+
+	// Request errors are answered with text
+	var result responses.VerifyResult
+	if rr.Header().Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code, result
+}
+
+// checkStatuses returns status of each check by name.
+func checkStatuses(result responses.VerifyResult) map[string]string {
+	statuses := map[string]string{}
+	for _, check := range result.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestVerifyHandlerChain(t *testing.T) {
+	fmt.Println("!!! Starting certificate validation tests on logic_verify.go !!!")
+	chain := newTestCertificateChain(t, &x509.Certificate{KeyUsage: x509.KeyUsageContentCommitment})
+	trustStore := x509.NewCertPool()
+	trustStore.AddCert(chain.root)
+
+	intermediates := []string{base64.StdEncoding.EncodeToString(chain.intermediate.Raw)}
+	code, result := verifyTestSignature(t, chain, trustStore, map[string]interface{}{"intermediates": intermediates})
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Valid)
+	assert.Equal(t, KeyAlgorithmRSA, result.Algorithm)
+	assert.Equal(t, signatureMethodPKCS1v15, result.SignatureFormat)
+	assert.Equal(t, "CN=Test Signer,C=LV", result.Signer.Subject)
+	assert.Equal(t, "CN=Test Intermediate CA", result.Signer.Issuer)
+	assert.Equal(t, chain.signer.SerialNumber.String(), result.Signer.SerialNumber)
+	assert.Empty(t, result.Reasons)
+	assert.Equal(t, map[string]string{
+		verifyCheckSignature:   responses.CheckPassed,
+		verifyCheckValidity:    responses.CheckPassed,
+		verifyCheckKeyUsage:    responses.CheckPassed,
+		verifyCheckExtKeyUsage: responses.CheckSkipped,
+		verifyCheckChain:       responses.CheckPassed,
+	}, checkStatuses(result))
+
+	// Without intermediate chain can't be built, signature itself is valid
+	code, result = verifyTestSignature(t, chain, trustStore, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, result.Valid)
+	assert.Len(t, result.Reasons, 1)
+	assert.True(t, strings.HasPrefix(result.Reasons[0], verifyCheckChain+": "))
+	assert.Equal(t, responses.CheckPassed, checkStatuses(result)[verifyCheckSignature])
+	assert.Equal(t, responses.CheckFailed, checkStatuses(result)[verifyCheckChain])
+}
+
+func TestVerifyHandlerValidationTime(t *testing.T) {
+	chain := newTestCertificateChain(t, &x509.Certificate{KeyUsage: x509.KeyUsageDigitalSignature})
+
+	code, result := verifyTestSignature(t, chain, nil, map[string]interface{}{
+		"validationTime": time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339),
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, result.Valid)
+	statuses := checkStatuses(result)
+	assert.Equal(t, responses.CheckPassed, statuses[verifyCheckSignature])
+	assert.Equal(t, responses.CheckFailed, statuses[verifyCheckValidity])
+	assert.Equal(t, responses.CheckSkipped, statuses[verifyCheckChain])
+
+	code, _ = verifyTestSignature(t, chain, nil, map[string]interface{}{"validationTime": "yesterday"})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestVerifyHandlerKeyUsage(t *testing.T) {
+	chain := newTestCertificateChain(t, &x509.Certificate{
+		KeyUsage:    x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	_, result := verifyTestSignature(t, chain, nil, map[string]interface{}{"extendedKeyUsages": []string{"emailProtection"}})
+	statuses := checkStatuses(result)
+	assert.Equal(t, responses.CheckFailed, statuses[verifyCheckKeyUsage])
+	assert.Equal(t, responses.CheckFailed, statuses[verifyCheckExtKeyUsage])
+
+	_, result = verifyTestSignature(t, chain, nil, map[string]interface{}{"extendedKeyUsages": []string{"emailProtection", "1.3.6.1.5.5.7.3.2"}})
+	assert.Equal(t, responses.CheckPassed, checkStatuses(result)[verifyCheckExtKeyUsage])
+}
+
+func TestVerifySignatureValueFormat(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	der, _ := ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+	r, s, _ := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	p1363 := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	format, err := verifySignatureValue(&privateKey.PublicKey, digest[:], der, verifyMethod{})
+	assert.NoError(t, err)
+	assert.Equal(t, "DER", format)
+
+	format, err = verifySignatureValue(&privateKey.PublicKey, digest[:], p1363, verifyMethod{})
+	assert.NoError(t, err)
+	assert.Equal(t, "P1363", format)
+
+	_, err = verifySignatureValue("key", digest[:], der, verifyMethod{})
+	assert.Error(t, err)
+}
+
+func TestVerifyHandlerSignatureMethod(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	certificate := generateTestCertificate(t, privateKey)
+	digest := sha512.Sum384([]byte("Hello, World!"))
+
+	tests := []struct {
+		name       string
+		options    rsaSignatureOptions
+		parameters map[string]interface{}
+	}{
+		{
+			name:       "PKCS1v15 SHA-384",
+			options:    rsaSignatureOptions{Method: signatureMethodPKCS1v15},
+			parameters: map[string]interface{}{"hashAlgorithm": "SHA-384"},
+		},
+		{
+			name:       "PSS default parameters",
+			options:    rsaSignatureOptions{Method: signatureMethodPSS, SaltLength: rsa.PSSSaltLengthEqualsHash},
+			parameters: map[string]interface{}{"signatureMethod": "PSS"},
+		},
+		{
+			name:       "PSS with salt length",
+			options:    rsaSignatureOptions{Method: signatureMethodPSS, SaltLength: 20},
+			parameters: map[string]interface{}{"signatureMethod": "PSS", "saltLength": 20},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, err := signRSA(privateKey, crypto.SHA384, digest[:], tt.options)
+			assert.NoError(t, err)
+
+			body := map[string]interface{}{
+				"digestValue":    base64.StdEncoding.EncodeToString(digest[:]),
+				"signatureValue": base64.StdEncoding.EncodeToString(signature),
+				"certificate":    certificate,
+			}
+			for key, value := range tt.parameters {
+				body[key] = value
+			}
+			code, result := postVerifyRequest(t, nil, body)
+			assert.Equal(t, http.StatusOK, code)
+			assert.True(t, result.Valid, result.Reasons)
+			assert.Equal(t, tt.options.Method, result.SignatureFormat)
+			assert.Equal(t, "SHA-384", result.HashAlgorithm)
+
+			// PKCS1v15 is the default method
+			if tt.options.Method == signatureMethodPSS {
+				delete(body, "signatureMethod")
+				_, result = postVerifyRequest(t, nil, body)
+				assert.False(t, result.Valid)
+			}
+		})
+	}
+}
+
+func TestVerifyHandlerSignatureMethodErrors(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	signature, _ := ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+	body := map[string]interface{}{
+		"digestValue":    base64.StdEncoding.EncodeToString(digest[:]),
+		"signatureValue": base64.StdEncoding.EncodeToString(signature),
+		"certificate":    generateTestCertificate(t, privateKey),
+	}
+
+	body["signatureMethod"] = "DER"
+	code, result := postVerifyRequest(t, nil, body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Valid)
+	assert.Equal(t, "DER", result.SignatureFormat)
+	assert.Equal(t, "SHA-256", result.HashAlgorithm)
+
+	// Signature is not verified in other format than requested
+	body["signatureMethod"] = "P1363"
+	code, result = postVerifyRequest(t, nil, body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, result.Valid)
+
+	body["signatureMethod"] = "PSS"
+	code, _ = postVerifyRequest(t, nil, body)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	body["signatureMethod"] = "DER"
+	body["hashAlgorithm"] = "SHA-512"
+	code, _ = postVerifyRequest(t, nil, body)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestVerifyHandlerPSSSaltLength(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	signature, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: 20})
+	assert.NoError(t, err)
+	body := map[string]interface{}{
+		"digestValue":     base64.StdEncoding.EncodeToString(digest[:]),
+		"signatureValue":  base64.StdEncoding.EncodeToString(signature),
+		"certificate":     generateTestCertificate(t, privateKey),
+		"signatureMethod": "PSS",
+		"saltLength":      20,
+	}
+
+	code, result := postVerifyRequest(t, nil, body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, responses.CheckPassed, checkStatuses(result)[verifyCheckSignature])
+
+	// Requested salt length is enforced, default is hash length
+	for _, saltLength := range []interface{}{32, 19, nil} {
+		body["saltLength"] = saltLength
+		code, result = postVerifyRequest(t, nil, body)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, responses.CheckFailed, checkStatuses(result)[verifyCheckSignature], saltLength)
+	}
+
+	// Empty salt can't be enforced
+	body["saltLength"] = 0
+	code, _ = postVerifyRequest(t, nil, body)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestVerifyHandlerSkipChainValidation(t *testing.T) {
+	chain := newTestCertificateChain(t, &x509.Certificate{KeyUsage: x509.KeyUsageDigitalSignature})
+
+	// Without trust store the chain is not checked and it is reported
+	_, result := verifyTestSignature(t, chain, nil, nil)
+	assert.True(t, result.Valid)
+	assert.True(t, result.ChainValidationSkipped)
+	assert.Equal(t, responses.CheckSkipped, checkStatuses(result)[verifyCheckChain])
+	for _, check := range result.Checks {
+		if check.Name == verifyCheckChain {
+			assert.Equal(t, "trust store is not configured", check.Message)
+		}
+	}
+
+	// With trust store the chain shall build
+	_, result = verifyTestSignature(t, chain, x509.NewCertPool(), nil)
+	assert.False(t, result.Valid)
+	assert.False(t, result.ChainValidationSkipped)
+	assert.Equal(t, responses.CheckFailed, checkStatuses(result)[verifyCheckChain])
+
+	// Chain is not checked even with trust store if skipped on request
+	trustStore := x509.NewCertPool()
+	trustStore.AddCert(chain.root)
+	for _, trustStore := range []*x509.CertPool{nil, trustStore} {
+		_, result = verifyTestSignature(t, chain, trustStore, map[string]interface{}{"skipChainValidation": true})
+		assert.True(t, result.Valid)
+		assert.True(t, result.ChainValidationSkipped)
+		assert.Equal(t, responses.CheckSkipped, checkStatuses(result)[verifyCheckChain])
+	}
+}
+
+func TestLoadTrustStore(t *testing.T) {
+	chain := newTestCertificateChain(t, &x509.Certificate{})
+	dir := t.TempDir()
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.root.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain.intermediate.Raw})...)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "roots.pem"), bundle, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "root.der"), chain.root.Raw, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a certificate"), 0o600))
+
+	trustStore, err := LoadTrustStore(dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = chain.signer.Verify(x509.VerifyOptions{Roots: trustStore, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	assert.NoError(t, err)
+
+	trustStore, err = LoadTrustStore("")
+	assert.NoError(t, err)
+	assert.Nil(t, trustStore)
+
+	_, err = LoadTrustStore(filepath.Join(dir, "README.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWorkMemoryLimit = 8 << 20
+	workFilePrefix         = "hash-sign-"
+	workJanitorInterval    = 10 * time.Minute
+	workFileMaxAge         = time.Hour
+)
+
+var errStorageUnavailable = errors.New("work directory for container files is not available")
+
+// WorkStorage keeps container data of a request in memory, if request is not
+// larger than MemoryLimit, or in temporary files of Dir.
+type WorkStorage struct {
+	Dir         string
+	MemoryLimit int64
+}
+
+// defaultWorkStorage is used by handlers registered without storage.
+var defaultWorkStorage = NewWorkStorage("", 0)
+
+// NewWorkStorage returns storage for the directory, system temporary directory
+// if dir is empty. Zero memoryLimit uses the default limit, negative disables
+// in-memory mode.
+func NewWorkStorage(dir string, memoryLimit int64) *WorkStorage {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if memoryLimit == 0 {
+		memoryLimit = defaultWorkMemoryLimit
+	}
+	return &WorkStorage{Dir: dir, MemoryLimit: memoryLimit}
+}
+
+// Check returns error if temporary files can't be created in the directory.
+func (s *WorkStorage) Check() error {
+	file, err := os.CreateTemp(s.Dir, workFilePrefix+"check-*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", errStorageUnavailable, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// workBuffer holds container data in memory or in a temporary file. Close
+// removes the temporary file.
+type workBuffer interface {
+	io.Writer
+	io.ReaderAt
+	io.Closer
+	Size() int64
+	// Reader reads the data from the beginning
+	Reader() (io.Reader, error)
+}
+
+type memoryBuffer struct {
+	bytes.Buffer
+}
+
+func (b *memoryBuffer) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(b.Bytes()).ReadAt(p, off)
+}
+
+func (b *memoryBuffer) Size() int64 {
+	return int64(b.Len())
+}
+
+func (b *memoryBuffer) Reader() (io.Reader, error) {
+	return bytes.NewReader(b.Bytes()), nil
+}
+
+func (b *memoryBuffer) Close() error {
+	return nil
+}
+
+type fileBuffer struct {
+	*os.File
+}
+
+// Size is read from the file, as io.Copy writes using ReadFrom of the file.
+func (b *fileBuffer) Size() int64 {
+	info, err := b.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (b *fileBuffer) Reader() (io.Reader, error) {
+	info, err := b.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(b.File, 0, info.Size()), nil
+}
+
+func (b *fileBuffer) Close() error {
+	b.File.Close()
+	return os.Remove(b.File.Name())
+}
+
+// newBuffer returns memory buffer if expectedSize is known and within the
+// memory limit, otherwise temporary file in the work directory.
+func (s *WorkStorage) newBuffer(expectedSize int64) (workBuffer, error) {
+	if expectedSize >= 0 && expectedSize <= s.MemoryLimit {
+		return &memoryBuffer{}, nil
+	}
+
+	file, err := os.CreateTemp(s.Dir, workFilePrefix+"*")
+	if err != nil {
+		log.Printf("Error creating temporary file in %s: %v", s.Dir, err)
+		return nil, fmt.Errorf("%w, only requests up to %d bytes can be processed", errStorageUnavailable, s.MemoryLimit)
+	}
+	return &fileBuffer{File: file}, nil
+}
+
+// newArchive returns buffer with zip writer for the new container.
+func (s *WorkStorage) newArchive(expectedSize int64) (workBuffer, *zip.Writer, error) {
+	buffer, err := s.newBuffer(expectedSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buffer, zip.NewWriter(buffer), nil
+}
+
+// Cleanup removes temporary files older than maxAge, left by stopped or
+// crashed requests.
+func (s *WorkStorage) Cleanup(maxAge time.Duration) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		log.Printf("Error reading work directory %s: %v", s.Dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), workFilePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.Dir, entry.Name())); err != nil {
+			log.Printf("Error removing orphaned work file %s: %v", entry.Name(), err)
+			continue
+		}
+		log.Printf("Orphaned work file %s removed", entry.Name())
+	}
+}
+
+// StartJanitor cleans the work directory now and then periodically until
+// stop is called.
+func (s *WorkStorage) StartJanitor(interval time.Duration, maxAge time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = workJanitorInterval
+	}
+	if maxAge <= 0 {
+		maxAge = workFileMaxAge
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.Cleanup(maxAge)
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAddFileRequest(t *testing.T) *http.Request {
+	container := newTestAsice(t, map[string]string{"existing.txt": "Existing"})
+	body, contentType := newTestMultipartBody(t, container, map[string]string{"test.txt": "Hello, World!"})
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile?type=binary", body)
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func TestWorkStorageInMemory(t *testing.T) {
+	fmt.Println("!!! Starting work storage tests on logic_workdir.go !!!")
+	storage := NewWorkStorage(filepath.Join(t.TempDir(), "missing"), 0)
+	assert.Error(t, storage.Check())
+
+	rr := httptest.NewRecorder()
+	AddFileHandler(storage)(rr, newTestAddFileRequest(t))
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	reader, err := openAsice(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, findZipFile(reader, "test.txt"))
+}
+
+func TestWorkStorageUnavailable(t *testing.T) {
+	storage := NewWorkStorage(filepath.Join(t.TempDir(), "missing"), -1)
+
+	rr := httptest.NewRecorder()
+	AddFileHandler(storage)(rr, newTestAddFileRequest(t))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "work directory for container files is not available")
+}
+
+func TestWorkStorageFiles(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewWorkStorage(dir, -1)
+	assert.NoError(t, storage.Check())
+
+	rr := httptest.NewRecorder()
+	AddFileHandler(storage)(rr, newTestAddFileRequest(t))
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		return
+	}
+	_, err := openAsice(rr.Body.Bytes())
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "temporary files shall be removed after request")
+}
+
+func TestWorkStorageCleanup(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{workFilePrefix + "old", workFilePrefix + "new", "other"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if name != workFilePrefix+"new" {
+			assert.NoError(t, os.Chtimes(path, old, old))
+		}
+	}
+
+	NewWorkStorage(dir, 0).Cleanup(time.Hour)
+
+	assert.NoFileExists(t, filepath.Join(dir, workFilePrefix+"old"))
+	assert.FileExists(t, filepath.Join(dir, workFilePrefix+"new"))
+	assert.FileExists(t, filepath.Join(dir, "other"))
+}
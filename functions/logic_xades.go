@@ -0,0 +1,438 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// XML namespaces used in ASiC-E signatures
+const (
+	xmlnsDS    = "http://www.w3.org/2000/09/xmldsig#"
+	xmlnsXAdES = "http://uri.etsi.org/01903/v1.3.2#"
+	xmlnsASiC  = "http://uri.etsi.org/02918/v1.2.1#"
+
+	xadesSignedPropertiesType = "http://uri.etsi.org/01903#SignedProperties"
+)
+
+var xmlDigestMethods = map[crypto.Hash]string{
+	crypto.SHA224: "http://www.w3.org/2001/04/xmldsig-more#sha224",
+	crypto.SHA256: "http://www.w3.org/2001/04/xmlenc#sha256",
+	crypto.SHA384: "http://www.w3.org/2001/04/xmldsig-more#sha384",
+	crypto.SHA512: "http://www.w3.org/2001/04/xmlenc#sha512",
+}
+
+var xmlRSASignatureMethods = map[crypto.Hash]string{
+	crypto.SHA224: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha224",
+	crypto.SHA256: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+	crypto.SHA384: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha384",
+	crypto.SHA512: "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512",
+}
+
+var xmlRSAPSSSignatureMethods = map[crypto.Hash]string{
+	crypto.SHA224: "http://www.w3.org/2007/05/xmldsig-more#sha224-rsa-MGF1",
+	crypto.SHA256: "http://www.w3.org/2007/05/xmldsig-more#sha256-rsa-MGF1",
+	crypto.SHA384: "http://www.w3.org/2007/05/xmldsig-more#sha384-rsa-MGF1",
+	crypto.SHA512: "http://www.w3.org/2007/05/xmldsig-more#sha512-rsa-MGF1",
+}
+
+var xmlECDSASignatureMethods = map[crypto.Hash]string{
+	crypto.SHA224: "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha224",
+	crypto.SHA256: "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256",
+	crypto.SHA384: "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384",
+	crypto.SHA512: "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512",
+}
+
+// xadesDataFile is a signed data object of the container.
+type xadesDataFile struct {
+	Name     string
+	MimeType string
+	Digest   []byte
+}
+
+type xadesParameters struct {
+	SignatureID     string
+	Certificate     *x509.Certificate
+	Hash            crypto.Hash
+	SignatureMethod string
+	SigningTime     time.Time
+	DataFiles       []xadesDataFile
+}
+
+// xadesSignature is a signature document waiting for the signature value.
+type xadesSignature struct {
+	Root            *xmlNode
+	SignatureID     string
+	SignedInfoHash  crypto.Hash
+	SignedInfoBytes []byte
+}
+
+// xmlSignatureMethod returns XML signature algorithm URI for the public key.
+// RSASSA-PSS is available only with salt length matching the hash.
+func xmlSignatureMethod(publicKey crypto.PublicKey, hash crypto.Hash, options rsaSignatureOptions) (string, error) {
+	var methods map[crypto.Hash]string
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		methods = xmlRSASignatureMethods
+		if options.Method == signatureMethodPSS {
+			resolved := options.resolve(hash)
+			if resolved.SaltLength != hash.Size() {
+				return "", errors.New("XML signatures support PSS only with salt length equal to the hash")
+			}
+			methods = xmlRSAPSSSignatureMethods
+		}
+	case *ecdsa.PublicKey:
+		methods = xmlECDSASignatureMethods
+	default:
+		return "", errors.New("XML signatures can be created only with RSA or ECDSA keys")
+	}
+
+	method, ok := methods[hash]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash algorithm: %s", hash)
+	}
+	return method, nil
+}
+
+// xadesHashAlgorithm returns hash algorithm from request body or hashAlgorithm
+// query parameter, SHA-256 if it is not set.
+func xadesHashAlgorithm(r *http.Request, hashAlgorithm string) (crypto.Hash, error) {
+	if hashAlgorithm == "" {
+		hashAlgorithm = r.URL.Query().Get("hashAlgorithm")
+	}
+	if hashAlgorithm == "" {
+		return crypto.SHA256, nil
+	}
+	return parseHashAlgorithm(hashAlgorithm)
+}
+
+// xadesReferenceURI escapes the file name for the reference URI.
+func xadesReferenceURI(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), ":", "%3A")
+	}
+	return strings.Join(segments, "/")
+}
+
+// xmlEscape escapes text for XML content and attribute values.
+func xmlEscape(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// xadesIssuerSerialV2 returns DER encoded IssuerSerial of the certificate.
+func xadesIssuerSerialV2(certificate *x509.Certificate) (string, error) {
+	issuerSerial, err := asn1.Marshal(essIssuerSerial{
+		Issuer:       []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: certificate.RawIssuer}},
+		SerialNumber: certificate.SerialNumber,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(issuerSerial), nil
+}
+
+// buildXadesDocument writes XAdES-BES signature document. Digest of signed
+// properties and signature value are left empty.
+func buildXadesDocument(parameters xadesParameters) ([]byte, error) {
+	digestMethod, ok := xmlDigestMethods[parameters.Hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", parameters.Hash)
+	}
+	if parameters.Certificate == nil {
+		return nil, errors.New("signing certificate is required")
+	}
+	if len(parameters.DataFiles) == 0 {
+		return nil, errors.New("no data files to sign")
+	}
+
+	issuerSerial, err := xadesIssuerSerialV2(parameters.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	certificateHash := parameters.Hash.New()
+	certificateHash.Write(parameters.Certificate.Raw)
+
+	id := parameters.SignatureID
+	var document strings.Builder
+
+	document.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n")
+	fmt.Fprintf(&document, `<asic:XAdESSignatures xmlns:asic="%s" xmlns:ds="%s" xmlns:xades="%s">`, xmlnsASiC, xmlnsDS, xmlnsXAdES)
+	fmt.Fprintf(&document, `<ds:Signature Id="%s">`, id)
+
+	document.WriteString(`<ds:SignedInfo>`)
+	fmt.Fprintf(&document, `<ds:CanonicalizationMethod Algorithm="%s"/>`, c14nExclusive)
+	fmt.Fprintf(&document, `<ds:SignatureMethod Algorithm="%s"/>`, parameters.SignatureMethod)
+	for i, file := range parameters.DataFiles {
+		fmt.Fprintf(&document, `<ds:Reference Id="%s-RefId%d" URI="%s">`, id, i, xmlEscape(xadesReferenceURI(file.Name)))
+		fmt.Fprintf(&document, `<ds:DigestMethod Algorithm="%s"/>`, digestMethod)
+		fmt.Fprintf(&document, `<ds:DigestValue>%s</ds:DigestValue>`, base64.StdEncoding.EncodeToString(file.Digest))
+		document.WriteString(`</ds:Reference>`)
+	}
+	fmt.Fprintf(&document, `<ds:Reference Id="%s-RefId%d" Type="%s" URI="#%s-SignedProperties">`, id, len(parameters.DataFiles), xadesSignedPropertiesType, id)
+	fmt.Fprintf(&document, `<ds:Transforms><ds:Transform Algorithm="%s"/></ds:Transforms>`, c14nExclusive)
+	fmt.Fprintf(&document, `<ds:DigestMethod Algorithm="%s"/>`, digestMethod)
+	document.WriteString(`<ds:DigestValue></ds:DigestValue>`)
+	document.WriteString(`</ds:Reference>`)
+	document.WriteString(`</ds:SignedInfo>`)
+
+	fmt.Fprintf(&document, `<ds:SignatureValue Id="%s-SIG"></ds:SignatureValue>`, id)
+	fmt.Fprintf(&document, `<ds:KeyInfo><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo>`,
+		base64.StdEncoding.EncodeToString(parameters.Certificate.Raw))
+
+	fmt.Fprintf(&document, `<ds:Object><xades:QualifyingProperties Id="%s-QualifyingProperties" Target="#%s">`, id, id)
+	fmt.Fprintf(&document, `<xades:SignedProperties Id="%s-SignedProperties">`, id)
+	document.WriteString(`<xades:SignedSignatureProperties>`)
+	fmt.Fprintf(&document, `<xades:SigningTime>%s</xades:SigningTime>`, parameters.SigningTime.UTC().Format(time.RFC3339))
+	document.WriteString(`<xades:SigningCertificateV2><xades:Cert><xades:CertDigest>`)
+	fmt.Fprintf(&document, `<ds:DigestMethod Algorithm="%s"/>`, digestMethod)
+	fmt.Fprintf(&document, `<ds:DigestValue>%s</ds:DigestValue>`, base64.StdEncoding.EncodeToString(certificateHash.Sum(nil)))
+	fmt.Fprintf(&document, `</xades:CertDigest><xades:IssuerSerialV2>%s</xades:IssuerSerialV2></xades:Cert></xades:SigningCertificateV2>`, issuerSerial)
+	document.WriteString(`</xades:SignedSignatureProperties>`)
+	document.WriteString(`<xades:SignedDataObjectProperties>`)
+	for i, file := range parameters.DataFiles {
+		fmt.Fprintf(&document, `<xades:DataObjectFormat ObjectReference="#%s-RefId%d"><xades:MimeType>%s</xades:MimeType></xades:DataObjectFormat>`,
+			id, i, xmlEscape(file.MimeType))
+	}
+	document.WriteString(`</xades:SignedDataObjectProperties>`)
+	document.WriteString(`</xades:SignedProperties>`)
+	document.WriteString(`</xades:QualifyingProperties></ds:Object>`)
+
+	document.WriteString(`</ds:Signature>`)
+	document.WriteString(`</asic:XAdESSignatures>`)
+
+	return []byte(document.String()), nil
+}
+
+// prepareXadesSignature builds the signature document, digests signed
+// properties and returns the document with canonical SignedInfo to be signed.
+func prepareXadesSignature(parameters xadesParameters) (*xadesSignature, error) {
+	document, err := buildXadesDocument(parameters)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseXML(document)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := root.FindByID(parameters.SignatureID)
+	signedProperties := root.FindByID(parameters.SignatureID + "-SignedProperties")
+	if signature == nil || signedProperties == nil {
+		return nil, errors.New("signature document is not complete")
+	}
+
+	signedPropertiesBytes, err := canonicalize(signedProperties, c14nExclusive, nil)
+	if err != nil {
+		return nil, err
+	}
+	h := parameters.Hash.New()
+	h.Write(signedPropertiesBytes)
+
+	signedInfo := signature.Element(xmlnsDS, "SignedInfo")
+	for _, reference := range signedInfo.Elements(xmlnsDS, "Reference") {
+		if reference.Attr("Type") == xadesSignedPropertiesType {
+			reference.Element(xmlnsDS, "DigestValue").SetContent(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+		}
+	}
+
+	signedInfoBytes, err := canonicalize(signedInfo, c14nExclusive, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xadesSignature{
+		Root:            root,
+		SignatureID:     parameters.SignatureID,
+		SignedInfoHash:  parameters.Hash,
+		SignedInfoBytes: signedInfoBytes,
+	}, nil
+}
+
+// parseXadesSignature reads signature document prepared earlier and
+// canonicalizes its SignedInfo.
+func parseXadesSignature(document []byte, signatureID string, hash crypto.Hash) (*xadesSignature, error) {
+	root, err := parseXML(document)
+	if err != nil {
+		return nil, err
+	}
+	signature := root.FindByID(signatureID)
+	if signature == nil || signature.Element(xmlnsDS, "SignedInfo") == nil {
+		return nil, errors.New("signature document is not complete")
+	}
+
+	signedInfoBytes, err := canonicalize(signature.Element(xmlnsDS, "SignedInfo"), c14nExclusive, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &xadesSignature{
+		Root:            root,
+		SignatureID:     signatureID,
+		SignedInfoHash:  hash,
+		SignedInfoBytes: signedInfoBytes,
+	}, nil
+}
+
+// Digest returns digest of canonical SignedInfo.
+func (s *xadesSignature) Digest() []byte {
+	h := s.SignedInfoHash.New()
+	h.Write(s.SignedInfoBytes)
+	return h.Sum(nil)
+}
+
+// Finalize adds the signature value and returns the signature document.
+func (s *xadesSignature) Finalize(signatureValue []byte) ([]byte, error) {
+	signature := s.Root.FindByID(s.SignatureID)
+	if signature == nil {
+		return nil, errors.New("signature document is not complete")
+	}
+	signatureValueElement := signature.Element(xmlnsDS, "SignatureValue")
+	if signatureValueElement == nil {
+		return nil, errors.New("signature document is not complete")
+	}
+	signatureValueElement.SetContent(base64.StdEncoding.EncodeToString(signatureValue))
+
+	return serializeXML(s.Root)
+}
+
+// Document returns the signature document in its current state.
+func (s *xadesSignature) Document() ([]byte, error) {
+	return serializeXML(s.Root)
+}
+
+// AddTimestamp adds signature time-stamp over the signature value as unsigned
+// property, making XAdES-BES a XAdES-T. Signature value shall be set before.
+func (s *xadesSignature) AddTimestamp(timestamps *TimestampClient) error {
+	signature := s.Root.FindByID(s.SignatureID)
+	if signature == nil {
+		return errors.New("signature document is not complete")
+	}
+	signatureValue := signature.Element(xmlnsDS, "SignatureValue")
+	object := signature.Element(xmlnsDS, "Object")
+	if signatureValue == nil || signatureValue.Content() == "" || object == nil {
+		return errors.New("signature document is not complete")
+	}
+	qualifyingProperties := object.Element(xmlnsXAdES, "QualifyingProperties")
+	if qualifyingProperties == nil {
+		return errors.New("signature document is not complete")
+	}
+
+	signatureValueBytes, err := canonicalize(signatureValue, c14nExclusive, nil)
+	if err != nil {
+		return err
+	}
+	h := s.SignedInfoHash.New()
+	h.Write(signatureValueBytes)
+	token, _, err := timestamps.Timestamp(s.SignedInfoHash, h.Sum(nil))
+	if err != nil {
+		return err
+	}
+
+	// Prefixes are resolved from the document when the element is appended
+	var properties strings.Builder
+	properties.WriteString(`<xades:UnsignedProperties><xades:UnsignedSignatureProperties>`)
+	fmt.Fprintf(&properties, `<xades:SignatureTimeStamp Id="%s-T0">`, s.SignatureID)
+	fmt.Fprintf(&properties, `<ds:CanonicalizationMethod Algorithm="%s"/>`, c14nExclusive)
+	fmt.Fprintf(&properties, `<xades:EncapsulatedTimeStamp Id="%s-T0-TS">%s</xades:EncapsulatedTimeStamp>`, s.SignatureID, base64.StdEncoding.EncodeToString(token))
+	properties.WriteString(`</xades:SignatureTimeStamp>`)
+	properties.WriteString(`</xades:UnsignedSignatureProperties></xades:UnsignedProperties>`)
+
+	unsignedProperties, err := parseXML([]byte(properties.String()))
+	if err != nil {
+		return err
+	}
+	qualifyingProperties.AppendChild(unsignedProperties)
+	return nil
+}
+
+// timestampXadesSignature adds signature time-stamp to the finalized
+// signature and returns the signature document.
+func timestampXadesSignature(signature *xadesSignature, timestamps *TimestampClient) ([]byte, error) {
+	if err := signature.AddTimestamp(timestamps); err != nil {
+		return nil, err
+	}
+	return signature.Document()
+}
+
+// verifyXadesSignatureValue checks the signature value created outside of the
+// service and returns it in XML signature encoding. ECDSA signatures can be
+// DER or P1363 encoded, XML signatures use P1363.
+func verifyXadesSignatureValue(publicKey crypto.PublicKey, hash crypto.Hash, options rsaSignatureOptions, digest, signatureValue []byte) ([]byte, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		var err error
+		if options.Method == signatureMethodPSS {
+			err = rsa.VerifyPSS(key, hash, digest, signatureValue, &rsa.PSSOptions{SaltLength: hash.Size(), Hash: hash})
+		} else {
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signatureValue)
+		}
+		if err != nil {
+			return nil, errors.New("RSA verification failed")
+		}
+		return signatureValue, nil
+	case *ecdsa.PublicKey:
+		if err := verifyECDSASignature(key, digest, signatureValue); err != nil {
+			return nil, err
+		}
+		keyBytes := (key.Params().BitSize + 7) >> 3
+		if len(signatureValue) == 2*keyBytes {
+			return signatureValue, nil
+		}
+		var esig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(signatureValue, &esig); err != nil {
+			return nil, err
+		}
+		p1363 := make([]byte, 2*keyBytes)
+		esig.R.FillBytes(p1363[:keyBytes])
+		esig.S.FillBytes(p1363[keyBytes:])
+		return p1363, nil
+	default:
+		return nil, errors.New("XML signatures can be created only with RSA or ECDSA keys")
+	}
+}
+
+// serializeXML writes the document with XML declaration, comments are kept.
+func serializeXML(root *xmlNode) ([]byte, error) {
+	content, err := canonicalize(root, c14n10WithComments, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>`+"\n"), content...), nil
+}
@@ -0,0 +1,203 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+// Limits of container entries. Sizes are checked from zip headers, reading an
+// entry beyond its declared size fails in archive/zip.
+const (
+	maxEntrySize        = 1 << 30
+	maxEntriesSize      = 4 << 30
+	maxContainerEntries = 10000
+	maxCompressionRatio = 100
+	// Small entries, like manifest, compress well and are not checked for ratio
+	compressionRatioThreshold = 1 << 20
+)
+
+// Error codes of container and file name validation.
+const (
+	codeInvalidFileName          = "INVALID_FILE_NAME"
+	codePathTraversal            = "PATH_TRAVERSAL"
+	codeReservedFileName         = "RESERVED_FILE_NAME"
+	codeDuplicateFileName        = "DUPLICATE_FILE_NAME"
+	codeEntryTooLarge            = "ENTRY_TOO_LARGE"
+	codeContainerTooLarge        = "CONTAINER_TOO_LARGE"
+	codeTooManyEntries           = "TOO_MANY_ENTRIES"
+	codeCompressionRatioExceeded = "COMPRESSION_RATIO_EXCEEDED"
+)
+
+// containerError is a rejected file name or container entry.
+type containerError struct {
+	Code    string
+	Message string
+}
+
+func (e *containerError) Error() string {
+	return e.Message
+}
+
+func isContainerError(err error) bool {
+	var validationErr *containerError
+	return errors.As(err, &validationErr)
+}
+
+func newContainerError(code string, format string, args ...interface{}) *containerError {
+	return &containerError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+var windowsDrive = regexp.MustCompile(`^[A-Za-z]:[/\\]`)
+
+// checkPath rejects absolute paths and paths leaving the container root.
+func checkPath(name string) error {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || windowsDrive.MatchString(name) {
+		return newContainerError(codePathTraversal, "file name %q is an absolute path", name)
+	}
+	for _, segment := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return newContainerError(codePathTraversal, "file name %q points outside of the container", name)
+		}
+	}
+	return nil
+}
+
+// validateFileName checks name of a data file added to the container.
+func validateFileName(name string) error {
+	if name == "" {
+		return newContainerError(codeInvalidFileName, "file name is empty")
+	}
+	if err := checkPath(name); err != nil {
+		return err
+	}
+	if !utf8.ValidString(name) || strings.ContainsRune(name, '\\') || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return newContainerError(codeInvalidFileName, "file name %q has invalid characters", name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." {
+			return newContainerError(codeInvalidFileName, "file name %q has empty path segment", name)
+		}
+	}
+	if name == asiceMimetypeFile || strings.HasPrefix(strings.ToUpper(name), "META-INF/") {
+		return newContainerError(codeReservedFileName, "file name %q is reserved for container metadata", name)
+	}
+	return nil
+}
+
+// checkContainerEntries rejects containers with entries outside of the root,
+// duplicate names, too many or too large entries and zip bombs.
+func checkContainerEntries(reader *zip.Reader) error {
+	if len(reader.File) > maxContainerEntries {
+		return newContainerError(codeTooManyEntries, "container has %d entries, limit is %d", len(reader.File), maxContainerEntries)
+	}
+
+	names := map[string]bool{}
+	var total uint64
+	for _, file := range reader.File {
+		if err := checkPath(file.Name); err != nil {
+			return err
+		}
+		if names[file.Name] {
+			return newContainerError(codeDuplicateFileName, "file %s is in container more than once", file.Name)
+		}
+		names[file.Name] = true
+
+		if file.UncompressedSize64 > maxEntrySize {
+			return newContainerError(codeEntryTooLarge, "file %s is larger than %d bytes", file.Name, maxEntrySize)
+		}
+		total += file.UncompressedSize64
+		if total > maxEntriesSize {
+			return newContainerError(codeContainerTooLarge, "container content is larger than %d bytes", maxEntriesSize)
+		}
+		if file.UncompressedSize64 > compressionRatioThreshold &&
+			file.UncompressedSize64 > file.CompressedSize64*maxCompressionRatio {
+			return newContainerError(codeCompressionRatioExceeded, "file %s has compression ratio over %d", file.Name, maxCompressionRatio)
+		}
+	}
+	return nil
+}
+
+// fileNameSet keeps names written to the new container to reject duplicates.
+type fileNameSet map[string]bool
+
+// newFileNameSet returns names of the container entries.
+func newFileNameSet(reader *zip.Reader) fileNameSet {
+	names := fileNameSet{}
+	for _, file := range reader.File {
+		names[file.Name] = true
+	}
+	return names
+}
+
+// add validates the name and rejects it if it is already in the set.
+func (names fileNameSet) add(name string) error {
+	if err := validateFileName(name); err != nil {
+		return err
+	}
+	if names[name] {
+		return newContainerError(codeDuplicateFileName, "file %s is in container more than once", name)
+	}
+	names[name] = true
+	return nil
+}
+
+// copyLimited copies content of the added file up to maxEntrySize.
+func copyLimited(name string, dst io.Writer, content io.Reader) error {
+	written, err := io.Copy(dst, io.LimitReader(content, maxEntrySize+1))
+	if err != nil {
+		return err
+	}
+	if written > maxEntrySize {
+		return newContainerError(codeEntryTooLarge, "file %s is larger than %d bytes", name, maxEntrySize)
+	}
+	return nil
+}
+
+// writeContainerError writes validation error as JSON with error code, other
+// errors as plain text, both with status 400.
+func writeContainerError(w http.ResponseWriter, err error) {
+	var validationErr *containerError
+	if !errors.As(err, &validationErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Container rejected, %s: %s", validationErr.Code, validationErr.Message)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(responses.ErrorResponse{Error: validationErr.Message, Code: validationErr.Code})
+}
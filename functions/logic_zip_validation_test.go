@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+func assertContainerErrorCode(t *testing.T, err error, code string) {
+	validationErr, ok := err.(*containerError)
+	if assert.True(t, ok, "expected containerError, got %v", err) {
+		assert.Equal(t, code, validationErr.Code)
+	}
+}
+
+func TestValidateFileName(t *testing.T) {
+	fmt.Println("!!! Starting container validation tests on logic_zip_validation.go !!!")
+	valid := []string{"test.txt", "folder/test file.txt", "a:b.txt", "..hidden", "folder/META-INF/x.txt"}
+	for _, name := range valid {
+		assert.NoError(t, validateFileName(name), name)
+	}
+
+	invalid := map[string]string{
+		"":                      codeInvalidFileName,
+		"folder//test.txt":      codeInvalidFileName,
+		"./test.txt":            codeInvalidFileName,
+		"folder/":               codeInvalidFileName,
+		"folder\\test.txt":      codeInvalidFileName,
+		"test\x00.txt":          codeInvalidFileName,
+		"../test.txt":           codePathTraversal,
+		"folder/../../test.txt": codePathTraversal,
+		"/etc/passwd":           codePathTraversal,
+		"C:/test.txt":           codePathTraversal,
+		"..\\test.txt":          codePathTraversal,
+		"mimetype":              codeReservedFileName,
+		"META-INF/manifest.xml": codeReservedFileName,
+		"meta-inf/x.xml":        codeReservedFileName,
+	}
+	for name, code := range invalid {
+		assertContainerErrorCode(t, validateFileName(name), code)
+	}
+}
+
+func TestCheckContainerEntries(t *testing.T) {
+	newZip := func(write func(writer *zip.Writer)) *zip.Reader {
+		var buffer bytes.Buffer
+		writer := zip.NewWriter(&buffer)
+		write(writer)
+		assert.NoError(t, writer.Close())
+		reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reader
+	}
+
+	reader := newZip(func(writer *zip.Writer) {
+		assert.NoError(t, writeZipFile(writer, "test.txt", []byte("a")))
+		assert.NoError(t, writeZipFile(writer, "test.txt", []byte("b")))
+	})
+	assertContainerErrorCode(t, checkContainerEntries(reader), codeDuplicateFileName)
+
+	reader = newZip(func(writer *zip.Writer) {
+		assert.NoError(t, writeZipFile(writer, "../evil.txt", []byte("a")))
+	})
+	assertContainerErrorCode(t, checkContainerEntries(reader), codePathTraversal)
+
+	// 2 MiB of zeros deflate to a few kilobytes
+	reader = newZip(func(writer *zip.Writer) {
+		assert.NoError(t, writeZipFile(writer, "bomb.txt", make([]byte, 2<<20)))
+	})
+	assertContainerErrorCode(t, checkContainerEntries(reader), codeCompressionRatioExceeded)
+
+	reader = newZip(func(writer *zip.Writer) {
+		assert.NoError(t, writeZipFile(writer, asiceManifestFile, []byte(strings.Repeat("<a/>", 10000))))
+		assert.NoError(t, writeZipFile(writer, "test.txt", []byte("a")))
+	})
+	assert.NoError(t, checkContainerEntries(reader))
+}
+
+func TestAddFileValidationErrors(t *testing.T) {
+	container := base64.StdEncoding.EncodeToString(newTestAsice(t, map[string]string{"existing.txt": "Existing"}))
+	tests := map[string]string{
+		"../x.txt":          codePathTraversal,
+		"META-INF/evil.xml": codeReservedFileName,
+		"mimetype":          codeReservedFileName,
+		"existing.txt":      codeDuplicateFileName,
+		"folder\\evil.txt":  codeInvalidFileName,
+	}
+	for name, code := range tests {
+		body, _ := json.Marshal(map[string]interface{}{
+			"emptyAsice":  container,
+			"signedFiles": []map[string]string{{"fileName": name, "encodedFile": "SGVsbG8="}},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/asice/addFile", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		HandleAddFileToAsiceRequest(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, name)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"), name)
+		var response responses.ErrorResponse
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response), name)
+		assert.Equal(t, code, response.Code, name)
+		assert.NotEmpty(t, response.Error, name)
+	}
+
+	// Duplicates inside the request
+	body := fmt.Sprintf(`{"emptyAsice": "%s", "signedFiles": [{"fileName": "a.txt", "encodedFile": "SGVsbG8="}, {"fileName": "a.txt", "encodedFile": "SGVsbG8="}]}`, container)
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), codeDuplicateFileName)
+}
+
+func TestAddFileMultipartValidationErrors(t *testing.T) {
+	container := newTestAsice(t, map[string]string{"existing.txt": "Existing"})
+	body, contentType := newTestMultipartBody(t, container, map[string]string{"../evil.txt": "Evil"})
+	req := httptest.NewRequest(http.MethodPost, "/asice/addFile", body)
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	HandleAddFileToAsiceRequest(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), codePathTraversal)
+}
+
+func TestCreateAsiceValidationErrors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/asice/create", strings.NewReader(`{"signedFiles": [{"fileName": "/abs.txt", "encodedFile": "SGVsbG8="}]}`))
+	rr := httptest.NewRecorder()
+	HandleCreateAsiceRequest(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), codePathTraversal)
+}
@@ -23,42 +23,76 @@
 package functions
 
 import (
-	"crypto/ecdsa"
+	"encoding/json"
+	"io"
 	"net/http"
 
 	"github.com/unknovs/hash-sign/routes/requests"
 )
 
-func SigningHandlerEC(privateKey *ecdsa.PrivateKey) http.HandlerFunc {
+func SigningHandlerEC(keys *KeyRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !validateRequest(w, r, privateKey) {
+		privateKey, ok := validateRequest(w, r, keys)
+		if !ok {
 			return
 		}
 
-		var signEcdsa requests.SignEcdsa
-		if !decodeJSON(w, r, &signEcdsa) {
+		signatureMethod := getSignatureMethod(r)
+		if signatureMethod != "DER" && signatureMethod != "P1363" {
+			http.Error(w, "invalid signature method, use 'P1363' or 'DER'", http.StatusBadRequest)
 			return
 		}
 
-		hashBytes, err := decodeHash(signEcdsa)
-		if err != nil {
-			http.Error(w, "Failed to decode hash from base64", http.StatusBadRequest)
+		// Binary body is hashed and signed as a single request
+		if isBinaryRequest(r) {
+			hash, hashBytes, err := hashRequestBody(w, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			signature, err := signEcdsaHash(privateKey, hashBytes, signatureMethod)
+			if err != nil {
+				http.Error(w, "Error signing hash", http.StatusInternalServerError)
+				return
+			}
+
+			signEcdsa := requests.SignEcdsa{SessionId: r.URL.Query().Get("sessionId"), HashAlgorithm: hash.String()}
+			sendResponse(w, signEcdsa, hashBytes, signature, signatureMethod)
 			return
 		}
 
-		signatureR, signatureS, err := signHash(privateKey, hashBytes)
+		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Error signing hash", http.StatusInternalServerError)
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
 			return
 		}
 
-		signatureMethod := getSignatureMethod(r)
-		signature, err := encodeSignature(signatureMethod, signatureR, signatureS, privateKey)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		// Try to parse single request first
+		var signEcdsa requests.SignEcdsa
+		if err := json.Unmarshal(bodyBytes, &signEcdsa); err == nil && (signEcdsa.DigestToSign != "" || signEcdsa.Data != "") {
+			hashBytes, err := decodeHash(signEcdsa)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			signature, err := signEcdsaHash(privateKey, hashBytes, signatureMethod)
+			if err != nil {
+				http.Error(w, "Error signing hash", http.StatusInternalServerError)
+				return
+			}
+
+			sendResponse(w, signEcdsa, hashBytes, signature, signatureMethod)
+			return
+		}
+
+		// Try array format
+		var signEcdsaRequests []requests.SignEcdsa
+		if !decodeJSON(w, bodyBytes, &signEcdsaRequests) {
 			return
 		}
 
-		sendResponse(w, signEcdsa, signature, signatureMethod)
+		sendBatchResponse(w, signEcdsaBatch(privateKey, signEcdsaRequests, signatureMethod))
 	}
 }
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+func TestSigningHandlerECSingle(t *testing.T) {
+	fmt.Println("!!! Starting ECDSA signing tests on sign-ecc.go !!!")
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hash := sha256.Sum256([]byte("Hello, World!"))
+	body := fmt.Sprintf(`{"sessionId": "abc", "hash": "%s"}`, base64.StdEncoding.EncodeToString(hash[:]))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-ecc?SignatureMethod=P1363", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandlerEC(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "abc", response.SessionId)
+	assert.Equal(t, "P1363", response.SignatureMethod)
+
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, verifyECDSASignature(&privateKey.PublicKey, hash[:], signature))
+}
+
+func TestSigningHandlerECBatchWithFailedItem(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	hash := sha256.Sum256([]byte("Hello, World!"))
+	encodedHash := base64.StdEncoding.EncodeToString(hash[:])
+	body := fmt.Sprintf(`[{"sessionId": "1", "hash": "%s"}, {"sessionId": "2", "hash": "not base64!"}, {"sessionId": "3", "hash": "%s"}]`, encodedHash, encodedHash)
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-ecc", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandlerEC(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response []responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response, 3)
+
+	assert.Equal(t, "1", response[0].SessionId)
+	assert.Empty(t, response[0].Error)
+	signature, _ := base64.StdEncoding.DecodeString(response[0].SignatureValue)
+	assert.NoError(t, verifyECDSASignature(&privateKey.PublicKey, hash[:], signature))
+
+	assert.Equal(t, "2", response[1].SessionId)
+	assert.NotEmpty(t, response[1].Error)
+	assert.Empty(t, response[1].SignatureValue)
+
+	assert.Equal(t, "3", response[2].SessionId)
+	assert.Empty(t, response[2].Error)
+}
+
+func TestSigningHandlerECInvalidSignatureMethod(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-ecc?SignatureMethod=PSS", strings.NewReader(`{"hash": "aGFzaA=="}`))
+	rr := httptest.NewRecorder()
+	SigningHandlerEC(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestSigningHandlerECHashesData(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	data := []byte("Hello, World!")
+	body := fmt.Sprintf(`[{"sessionId": "1", "data": "%s"}]`, base64.StdEncoding.EncodeToString(data))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-ecc", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandlerEC(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response []responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	hash := sha256.Sum256(data)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(hash[:]), response[0].Hash)
+	assert.Equal(t, "SHA-256", response[0].HashAlgorithm)
+
+	signature, _ := base64.StdEncoding.DecodeString(response[0].SignatureValue)
+	assert.NoError(t, verifyECDSASignature(&privateKey.PublicKey, hash[:], signature))
+}
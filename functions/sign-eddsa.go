@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/unknovs/hash-sign/routes/requests"
+)
+
+func SigningHandlerEdDSA(keys *KeyRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		signingKey, ok := selectKey(w, r, keys, OperationSign, KeyAlgorithmEd25519)
+		if !ok {
+			return
+		}
+		privateKey := signingKey.PrivateKey.(ed25519.PrivateKey)
+
+		signatureMethod, err := getEdDSASignatureMethod(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Binary body is signed as data of a single request
+		if isBinaryRequest(r) {
+			data, err := readRequestData(w, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			signature, hash, err := signEdDSAData(privateKey, signatureMethod, data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			signEddsa := requests.SignEddsa{SessionId: r.URL.Query().Get("sessionId")}
+			sendEdDSAResponse(w, signEddsa, hash, signature, signatureMethod)
+			return
+		}
+
+		var signEddsa requests.SignEddsa
+		if err := json.NewDecoder(r.Body).Decode(&signEddsa); err != nil {
+			log.Printf("Failed to decode JSON: %s", err)
+			http.Error(w, "Failed to decode JSON", http.StatusBadRequest)
+			return
+		}
+
+		signature, hash, err := signEdDSA(privateKey, signatureMethod, signEddsa)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sendEdDSAResponse(w, signEddsa, hash, signature, signatureMethod)
+	}
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+func TestSigningHandlerEdDSAPure(t *testing.T) {
+	fmt.Println("!!! Starting Ed25519 signing tests on sign-eddsa.go !!!")
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	data := []byte("Hello, World!")
+	body := fmt.Sprintf(`{"data": "%s"}`, base64.StdEncoding.EncodeToString(data))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-eddsa", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandlerEdDSA(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "Ed25519", response.SignatureMethod)
+
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.True(t, ed25519.Verify(publicKey, data, signature))
+}
+
+func TestSigningHandlerEdDSAPrehash(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	digest := sha512.Sum512([]byte("Hello, World!"))
+	body := fmt.Sprintf(`{"hash": "%s"}`, base64.StdEncoding.EncodeToString(digest[:]))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-eddsa?signatureMethod=Ed25519ph", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandlerEdDSA(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, ed25519.VerifyWithOptions(publicKey, digest[:], signature, &ed25519.Options{Hash: crypto.SHA512}))
+}
+
+func TestSigningHandlerEdDSAPrehashWrongLength(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	body := fmt.Sprintf(`{"hash": "%s"}`, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-eddsa?signatureMethod=Ed25519ph", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandlerEdDSA(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestSigningHandlerEdDSABinaryBody(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	data := []byte("Hello, World!")
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-eddsa?sessionId=abc", strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rr := httptest.NewRecorder()
+	SigningHandlerEdDSA(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "abc", response.SessionId)
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.True(t, ed25519.Verify(publicKey, data, signature))
+
+	// Ed25519ph signs SHA-512 prehash of the body
+	req = httptest.NewRequest(http.MethodPost, "/digest/sign-eddsa?signatureMethod=Ed25519ph", strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rr = httptest.NewRecorder()
+	SigningHandlerEdDSA(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	digest := sha512.Sum512(data)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(digest[:]), response.Hash)
+	signature, _ = base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, ed25519.VerifyWithOptions(publicKey, digest[:], signature, &ed25519.Options{Hash: crypto.SHA512}))
+}
+
+func TestSigningHandlerEdDSADataLimit(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	data := make([]byte, maxDataSize+1)
+
+	body := fmt.Sprintf(`{"data": "%s"}`, base64.StdEncoding.EncodeToString(data))
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign-eddsa", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandlerEdDSA(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "data is larger than")
+
+	req = httptest.NewRequest(http.MethodPost, "/digest/sign-eddsa", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rr = httptest.NewRecorder()
+	SigningHandlerEdDSA(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "failed to read request body")
+}
+
+func TestVerifySignatureEd25519(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	data := []byte("Hello, World!")
+	signature := ed25519.Sign(privateKey, data)
+	body := fmt.Sprintf(`{"digestValue": "%s", "signatureValue": "%s", "certificate": "%s"}`,
+		base64.StdEncoding.EncodeToString(data), base64.StdEncoding.EncodeToString(signature), generateTestCertificate(t, privateKey))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/verify", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	VerifySignature(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var result responses.VerifyResult
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+	assert.True(t, result.Valid)
+	assert.Equal(t, KeyAlgorithmEd25519, result.Algorithm)
+	assert.Equal(t, signatureMethodEd25519, result.SignatureFormat)
+}
+
+func TestVerifySignatureEd25519ph(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	prehash := sha512.Sum512([]byte("Hello, World!"))
+	signature, _ := privateKey.Sign(rand.Reader, prehash[:], crypto.SHA512)
+	body := fmt.Sprintf(`{"digestValue": "%s", "signatureValue": "%s", "certificate": "%s", "signatureMethod": "Ed25519ph"}`,
+		base64.StdEncoding.EncodeToString(prehash[:]), base64.StdEncoding.EncodeToString(signature), generateTestCertificate(t, privateKey))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/verify", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	VerifySignature(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var result responses.VerifyResult
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+	assert.True(t, result.Valid)
+	assert.Equal(t, signatureMethodEd25519ph, result.SignatureFormat)
+	assert.Equal(t, "SHA-512", result.HashAlgorithm)
+}
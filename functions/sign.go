@@ -23,19 +23,14 @@
 package functions
 
 import (
-	"bufio"
 	"crypto"
-	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/unknovs/hash-sign/routes/responses"
 )
@@ -48,32 +43,13 @@ func GetPrivateKey(filename string) (*rsa.PrivateKey, error) {
 		return nil, nil
 	}
 
-	// Read the pem file
-	certData, err := os.Open(filename)
+	key, err := readPrivateKeyFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read RSA pem file: %w", err)
+		return nil, fmt.Errorf("failed to load RSA private key: %w", err)
 	}
-	defer certData.Close()
-
-	pemfileinfo, _ := certData.Stat()
-	var size int64 = pemfileinfo.Size()
-	pembytes := make([]byte, size)
-
-	buffer := bufio.NewReader(certData)
-	_, err = buffer.Read(pembytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read RSA pem file: %w", err)
-	}
-
-	block, _ := pem.Decode([]byte(pembytes))
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode RSA pem file")
-	}
-
-	// Extract the private key from the pem file
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse RSA pem file: %w", err)
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("parsed key is not RSA private key")
 	}
 
 	return privateKey, nil
@@ -81,25 +57,51 @@ func GetPrivateKey(filename string) (*rsa.PrivateKey, error) {
 
 // Single request without sessionId
 type SingleHashRequest struct {
-	SessionId string `json:"sessionId,omitempty"`
-	Hash      string `json:"hash"`
+	SessionId     string `json:"sessionId,omitempty"`
+	Hash          string `json:"hash"`
+	Data          string `json:"data,omitempty"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
 }
 
 // Array request with sessionId
 type HashSignatureRequest struct {
-	SessionId string `json:"sessionId"`
-	Hash      string `json:"hash"`
+	SessionId     string `json:"sessionId"`
+	Hash          string `json:"hash"`
+	Data          string `json:"data,omitempty"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
 }
 
-func SigningHandler(privateKey *rsa.PrivateKey) http.HandlerFunc {
+func SigningHandler(keys *KeyRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !isPostMethod(r) {
 			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if privateKey == nil {
-			http.Error(w, "RSA Private key not loaded", http.StatusNotFound)
+		signingKey, ok := selectKey(w, r, keys, OperationSign, KeyAlgorithmRSA)
+		if !ok {
+			return
+		}
+		privateKey := signingKey.PrivateKey.(*rsa.PrivateKey)
+
+		options, err := getRSASignatureOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Binary body is hashed and signed as a single request
+		if isBinaryRequest(r) {
+			hash, hashBytes, err := hashRequestBody(w, r)
+			if err == nil {
+				err = options.check(&privateKey.PublicKey, hash)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			signSingleRSAHash(w, privateKey, r.URL.Query().Get("sessionId"), hash, hashBytes, options)
 			return
 		}
 
@@ -113,32 +115,18 @@ func SigningHandler(privateKey *rsa.PrivateKey) http.HandlerFunc {
 		var singleRequest SingleHashRequest
 		var hashSignatureRequests []HashSignatureRequest
 		err = json.Unmarshal(bodyBytes, &singleRequest)
-		if err == nil && singleRequest.Hash != "" {
+		if err == nil && (singleRequest.Hash != "" || singleRequest.Data != "") {
 			// Single request handling
-			hashBytes, _ := base64.StdEncoding.DecodeString(singleRequest.Hash)
-			signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashBytes[:])
+			hash, hashBytes, err := requestDigest(singleRequest.Hash, singleRequest.Data, singleRequest.HashAlgorithm)
+			if err == nil {
+				err = options.check(&privateKey.PublicKey, hash)
+			}
 			if err != nil {
-				log.Printf("Error signing hash: %s", err)
-				http.Error(w, "Error signing hash", http.StatusInternalServerError)
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 
-			// Single response
-			hashSignatureResponse := responses.HashSignature{
-				SessionId:       singleRequest.SessionId,
-				SignatureMethod: "PKCS1v15",
-				Hash:            singleRequest.Hash,
-				SignatureValue:  base64.StdEncoding.EncodeToString(signature),
-			}
-
-			log.Printf("Hash value: %v signed", hashSignatureResponse.Hash)
-
-			w.Header().Set("Content-Type", "application/json")
-			err = json.NewEncoder(w).Encode(hashSignatureResponse) // Note: no array here
-			if err != nil {
-				log.Printf("Failed to encode JSON: %s", err)
-				http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
-			}
+			signSingleRSAHash(w, privateKey, singleRequest.SessionId, hash, hashBytes, options)
 		} else {
 			// Try array format
 			err = json.Unmarshal(bodyBytes, &hashSignatureRequests)
@@ -148,31 +136,37 @@ func SigningHandler(privateKey *rsa.PrivateKey) http.HandlerFunc {
 				return
 			}
 
+			// Validate every hash before signing anything
+			hashes := make([]crypto.Hash, len(hashSignatureRequests))
+			hashBytes := make([][]byte, len(hashSignatureRequests))
+			for i, request := range hashSignatureRequests {
+				hashes[i], hashBytes[i], err = requestDigest(request.Hash, request.Data, request.HashAlgorithm)
+				if err == nil {
+					err = options.check(&privateKey.PublicKey, hashes[i])
+				}
+				if err != nil {
+					http.Error(w, fmt.Sprintf("sessionId %s: %s", request.SessionId, err), http.StatusBadRequest)
+					return
+				}
+			}
+
 			var hashSignatureResponses []responses.HashSignature
 
 			// Process each hash in the array
-			for _, request := range hashSignatureRequests {
-				hashBytes, _ := base64.StdEncoding.DecodeString(request.Hash)
-				signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashBytes[:])
+			for i, request := range hashSignatureRequests {
+				hashSignatureResponse, err := signRSAHash(privateKey, request.SessionId, hashes[i], hashBytes[i], options)
 				if err != nil {
 					log.Printf("Error signing hash: %s", err)
 					http.Error(w, "Error signing hash", http.StatusInternalServerError)
 					return
 				}
 
-				hashSignatureResponse := responses.HashSignature{
-					SessionId:       request.SessionId,
-					SignatureMethod: "PKCS1v15",
-					Hash:            request.Hash,
-					SignatureValue:  base64.StdEncoding.EncodeToString(signature),
-				}
-
 				hashSignatureResponses = append(hashSignatureResponses, hashSignatureResponse)
 			}
 
 			// Log the signed hash values
 			for _, response := range hashSignatureResponses {
-				log.Printf("Hash value: %v signed", response.Hash)
+				log.Printf("Hash value: %v signed using %s method", response.Hash, response.SignatureMethod)
 			}
 
 			// Write the JSON response
@@ -185,3 +179,43 @@ func SigningHandler(privateKey *rsa.PrivateKey) http.HandlerFunc {
 		}
 	}
 }
+
+func signSingleRSAHash(w http.ResponseWriter, privateKey *rsa.PrivateKey, sessionId string, hash crypto.Hash, hashBytes []byte, options rsaSignatureOptions) {
+	hashSignatureResponse, err := signRSAHash(privateKey, sessionId, hash, hashBytes, options)
+	if err != nil {
+		log.Printf("Error signing hash: %s", err)
+		http.Error(w, "Error signing hash", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Hash value: %v signed using %s method", hashSignatureResponse.Hash, hashSignatureResponse.SignatureMethod)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(hashSignatureResponse) // Note: no array here
+	if err != nil {
+		log.Printf("Failed to encode JSON: %s", err)
+		http.Error(w, "Failed to encode JSON", http.StatusInternalServerError)
+	}
+}
+
+func signRSAHash(privateKey *rsa.PrivateKey, sessionId string, hash crypto.Hash, hashBytes []byte, options rsaSignatureOptions) (responses.HashSignature, error) {
+	signature, err := signRSA(privateKey, hash, hashBytes, options)
+	if err != nil {
+		return responses.HashSignature{}, err
+	}
+
+	hashSignature := responses.HashSignature{
+		SessionId:       sessionId,
+		SignatureMethod: options.Method,
+		HashAlgorithm:   hash.String(),
+		Hash:            base64.StdEncoding.EncodeToString(hashBytes),
+		SignatureValue:  base64.StdEncoding.EncodeToString(signature),
+	}
+
+	if options.Method == signatureMethodPSS {
+		resolved := options.resolve(hash)
+		hashSignature.SaltLength = &resolved.SaltLength
+	}
+
+	return hashSignature, nil
+}
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package functions
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+func generateTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey
+}
+
+func newTestKeyRegistry(t *testing.T, privateKeys ...crypto.Signer) *KeyRegistry {
+	registry := NewKeyRegistry()
+	for i, privateKey := range privateKeys {
+		if err := registry.Add(&SigningKey{ID: fmt.Sprintf("key%d", i), PrivateKey: privateKey}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return registry
+}
+
+func TestSigningHandlerPKCS1v15(t *testing.T) {
+	fmt.Println("!!! Starting RSA signing tests on sign.go !!!")
+	privateKey := generateTestRSAKey(t)
+	hash := sha256.Sum256([]byte("Hello, World!"))
+	body := fmt.Sprintf(`{"sessionId": "1", "hash": "%s"}`, base64.StdEncoding.EncodeToString(hash[:]))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "PKCS1v15", response.SignatureMethod)
+	assert.Nil(t, response.SaltLength)
+
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, hash[:], signature))
+}
+
+func TestSigningHandlerPSSArray(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	hash := sha256.Sum256([]byte("Hello, World!"))
+	encodedHash := base64.StdEncoding.EncodeToString(hash[:])
+	body := fmt.Sprintf(`[{"sessionId": "1", "hash": "%s"}, {"sessionId": "2", "hash": "%s"}]`, encodedHash, encodedHash)
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign?signatureMethod=PSS&saltLength=20", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response []responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response, 2)
+	for _, item := range response {
+		assert.Equal(t, "PSS", item.SignatureMethod)
+		assert.Equal(t, 20, *item.SaltLength)
+
+		signature, _ := base64.StdEncoding.DecodeString(item.SignatureValue)
+		err := rsa.VerifyPSS(&privateKey.PublicKey, crypto.SHA256, hash[:], signature, &rsa.PSSOptions{SaltLength: 20})
+		assert.NoError(t, err)
+	}
+}
+
+func TestSigningHandlerInvalidSignatureMethod(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign?signatureMethod=OAEP", strings.NewReader(`{"hash": "aGFzaA=="}`))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestSigningHandlerPSSSaltLength(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	hash := sha256.Sum256([]byte("Hello, World!"))
+	body := fmt.Sprintf(`{"hash": "%s"}`, base64.StdEncoding.EncodeToString(hash[:]))
+	maxSaltLength := privateKey.Size() - sha256.Size - 2
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/digest/sign?signatureMethod=PSS&saltLength=%d", maxSaltLength), strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, maxSaltLength, *response.SaltLength)
+
+	// Signature is verified only with the exact salt length of the response
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, rsa.VerifyPSS(&privateKey.PublicKey, crypto.SHA256, hash[:], signature, &rsa.PSSOptions{SaltLength: maxSaltLength}))
+	assert.Error(t, rsa.VerifyPSS(&privateKey.PublicKey, crypto.SHA256, hash[:], signature, &rsa.PSSOptions{SaltLength: maxSaltLength - 1}))
+
+	for _, query := range []string{
+		"saltLength=0",
+		fmt.Sprintf("saltLength=%d", maxSaltLength+1),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/digest/sign?signatureMethod=PSS&"+query, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
+
+func TestSigningHandlerInfersHashAlgorithm(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	hash := sha512.Sum384([]byte("Hello, World!"))
+	body := fmt.Sprintf(`{"hash": "%s"}`, base64.StdEncoding.EncodeToString(hash[:]))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "SHA-384", response.HashAlgorithm)
+
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA384, hash[:], signature))
+}
+
+func TestSigningHandlerHashLengthMismatch(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	hash := sha256.Sum256([]byte("Hello, World!"))
+	body := fmt.Sprintf(`[{"sessionId": "1", "hash": "%s", "hashAlgorithm": "SHA-512"}]`, base64.StdEncoding.EncodeToString(hash[:]))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestResolveHashAlgorithm(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		length    int
+		expected  crypto.Hash
+		wantError bool
+	}{
+		{name: "Inferred SHA-224", length: 28, expected: crypto.SHA224},
+		{name: "Inferred SHA-256", length: 32, expected: crypto.SHA256},
+		{name: "Inferred SHA-512", length: 64, expected: crypto.SHA512},
+		{name: "Explicit SHA-384", algorithm: "sha384", length: 48, expected: crypto.SHA384},
+		{name: "Unknown length", length: 20, wantError: true},
+		{name: "Unsupported algorithm", algorithm: "MD5", length: 16, wantError: true},
+		{name: "Length mismatch", algorithm: "SHA-256", length: 48, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := resolveHashAlgorithm(tt.algorithm, make([]byte, tt.length))
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, hash)
+		})
+	}
+}
+
+func TestSigningHandlerHashesData(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	data := []byte(`{"document": "Hello, World!"}`)
+	body := fmt.Sprintf(`{"data": "%s", "hashAlgorithm": "SHA-512"}`, base64.StdEncoding.EncodeToString(data))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	hash := sha512.Sum512(data)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(hash[:]), response.Hash)
+	assert.Equal(t, "SHA-512", response.HashAlgorithm)
+
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA512, hash[:], signature))
+}
+
+func TestSigningHandlerHashesBinaryBody(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	data := []byte("Hello, World!")
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign?sessionId=abc", strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response responses.HashSignature
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	hash := sha256.Sum256(data)
+	assert.Equal(t, "abc", response.SessionId)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(hash[:]), response.Hash)
+
+	signature, _ := base64.StdEncoding.DecodeString(response.SignatureValue)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, hash[:], signature))
+}
+
+func TestSigningHandlerDataLimit(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+	body := fmt.Sprintf(`{"data": "%s"}`, base64.StdEncoding.EncodeToString(make([]byte, maxDataSize+1)))
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "data is larger than")
+}
+
+func TestSigningHandlerHashAndDataTogether(t *testing.T) {
+	privateKey := generateTestRSAKey(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/sign", strings.NewReader(`{"hash": "aGFzaA==", "data": "ZGF0YQ=="}`))
+	rr := httptest.NewRecorder()
+	SigningHandler(newTestKeyRegistry(t, privateKey))(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const maxTimestampQuerySize = 64 << 10
+
+// TimestampAuthorityHandler answers RFC 3161 time-stamp queries sent over HTTP.
+func TimestampAuthorityHandler(authority *TimestampAuthority) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isPostMethod(r) {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if authority == nil {
+			http.Error(w, errTimestampAuthorityNotConfigured.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.Header.Get("Content-Type") != timestampQueryMimeType {
+			http.Error(w, "Content-Type shall be "+timestampQueryMimeType, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		query, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTimestampQuerySize))
+		if err != nil {
+			http.Error(w, "Failed to read time-stamp query", http.StatusBadRequest)
+			return
+		}
+
+		reply, err := authority.Respond(query, time.Now())
+		if err != nil {
+			log.Printf("Error issuing time-stamp token: %s", err)
+			http.Error(w, "Error issuing time-stamp token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", timestampReplyMimeType)
+		if _, err := w.Write(reply); err != nil {
+			log.Printf("Error writing time-stamp reply: %v", err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestTimestampAuthority registers dedicated TSA key and returns the TSA.
+func newTestTimestampAuthority(t *testing.T) *TimestampAuthority {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	keys := NewKeyRegistry()
+	err := keys.Add(&SigningKey{
+		ID:          "tsa",
+		PrivateKey:  tsa.key.PrivateKey,
+		Certificate: base64.StdEncoding.EncodeToString(tsa.certificate.Raw),
+		Operations:  []string{OperationTSA},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authority, err := NewTimestampAuthority(keys, "", "1.2.3.4.1", "1.5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authority
+}
+
+// requestTestTimestamp sends the query to TSA handler and returns the parsed response.
+func requestTestTimestamp(t *testing.T, authority *TimestampAuthority, query []byte) timeStampResp {
+	req := httptest.NewRequest(http.MethodPost, "/tsa", bytes.NewReader(query))
+	req.Header.Set("Content-Type", timestampQueryMimeType)
+	rr := httptest.NewRecorder()
+	TimestampAuthorityHandler(authority)(rr, req)
+
+	if !assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+		t.FailNow()
+	}
+	assert.Equal(t, timestampReplyMimeType, rr.Header().Get("Content-Type"))
+	var response timeStampResp
+	if _, err := asn1.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestTimestampAuthorityHandler(t *testing.T) {
+	fmt.Println("!!! Starting TSA tests on tsa.go !!!")
+	authority := newTestTimestampAuthority(t)
+	server := httptest.NewServer(TimestampAuthorityHandler(authority))
+	defer server.Close()
+	client, err := NewTimestampClient(server.URL, base64.StdEncoding.EncodeToString(authority.Certificate.Raw), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	_, first, err := client.Timestamp(crypto.SHA256, digest[:])
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, first.Policy.Equal(asn1.ObjectIdentifier{1, 2, 3, 4, 1}))
+	assert.Equal(t, tstAccuracy{Seconds: 1, Millis: 500}, first.Accuracy)
+	assert.WithinDuration(t, time.Now(), first.GenTime, time.Minute)
+
+	_, second, err := client.Timestamp(crypto.SHA256, digest[:])
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 1, second.SerialNumber.Cmp(first.SerialNumber), "serial numbers shall increase")
+}
+
+func TestTimestampAuthorityCertReq(t *testing.T) {
+	authority := newTestTimestampAuthority(t)
+	digest := sha256.Sum256([]byte("Hello, World!"))
+	query, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: tstMessageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: digest[:]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := requestTestTimestamp(t, authority, query)
+	assert.Equal(t, pkiStatusGranted, response.Status.Status)
+	signedData, err := parseSignedData(response.TimeStampToken.FullBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, signedData.Certificates.Bytes, "certificate is included only if requested")
+	info, err := parseTSTInfo(signedData)
+	assert.NoError(t, err)
+	assert.Nil(t, info.Nonce)
+}
+
+func TestTimestampAuthorityRejects(t *testing.T) {
+	authority := newTestTimestampAuthority(t)
+	digest := sha256.Sum256([]byte("Hello, World!"))
+
+	tests := []struct {
+		name    string
+		request interface{}
+		failure int
+	}{
+		{
+			name:    "bad data format",
+			request: []byte("not a request"),
+			failure: pkiFailureBadDataFormat,
+		},
+		{
+			name: "bad algorithm",
+			request: timeStampReq{Version: 1, MessageImprint: tstMessageImprint{
+				HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}},
+				HashedMessage: digest[:16],
+			}},
+			failure: pkiFailureBadAlg,
+		},
+		{
+			name: "imprint length",
+			request: timeStampReq{Version: 1, MessageImprint: tstMessageImprint{
+				HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+				HashedMessage: digest[:20],
+			}},
+			failure: pkiFailureBadDataFormat,
+		},
+		{
+			name: "unaccepted policy",
+			request: timeStampReq{
+				Version:        1,
+				MessageImprint: tstMessageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: digest[:]},
+				ReqPolicy:      asn1.ObjectIdentifier{1, 2, 3, 4, 2},
+			},
+			failure: pkiFailureUnacceptedPolicy,
+		},
+		{
+			name:    "bad request version",
+			request: timeStampReq{Version: 2, MessageImprint: tstMessageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: digest[:]}},
+			failure: pkiFailureBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, ok := test.request.([]byte)
+			if !ok {
+				var err error
+				if query, err = asn1.Marshal(test.request); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			response := requestTestTimestamp(t, authority, query)
+			assert.Equal(t, pkiStatusRejection, response.Status.Status)
+			assert.Equal(t, 1, response.Status.FailInfo.At(test.failure))
+			assert.Empty(t, response.TimeStampToken.FullBytes)
+		})
+	}
+}
+
+func TestTimestampAuthorityNotConfigured(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/tsa", bytes.NewReader([]byte{}))
+	req.Header.Set("Content-Type", timestampQueryMimeType)
+	rr := httptest.NewRecorder()
+	TimestampAuthorityHandler(nil)(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/tsa", bytes.NewReader([]byte{}))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	TimestampAuthorityHandler(newTestTimestampAuthority(t))(rr, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+}
+
+func TestNewTimestampAuthority(t *testing.T) {
+	authority, err := NewTimestampAuthority(NewKeyRegistry(), "", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, authority)
+
+	// Keys without configured operations are not TSA keys
+	_, err = NewTimestampAuthority(newTestCmsKeyRegistry(t, generateTestRSAKey(t)), "", "1.2.3", "")
+	assert.ErrorContains(t, err, "no key allowed for tsa operation")
+
+	keys := NewKeyRegistry()
+	privateKey := generateTestRSAKey(t)
+	err = keys.Add(&SigningKey{ID: "seal", PrivateKey: privateKey, Certificate: generateTestCertificate(t, privateKey), Operations: []string{OperationTSA}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewTimestampAuthority(keys, "seal", "1.2.3", "")
+	assert.ErrorContains(t, err, "is not a TSA certificate")
+
+	_, err = NewTimestampAuthority(keys, "", "1.2.x", "")
+	assert.ErrorContains(t, err, "invalid TSA policy")
+	_, err = NewTimestampAuthority(keys, "", "1.2.3", "-1s")
+	assert.ErrorContains(t, err, "invalid TSA accuracy")
+	_, err = NewTimestampAuthority(keys, "missing", "1.2.3", "")
+	assert.ErrorContains(t, err, "TSA key missing not found")
+}
@@ -23,9 +23,7 @@
 package functions
 
 import (
-	"crypto"
 	"crypto/ecdsa"
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/base64"
@@ -62,69 +60,70 @@ func decodeBase64(s string) ([]byte, error) {
 }
 
 func verifyECDSASignature(pub *ecdsa.PublicKey, digestValue, signatureBytes []byte) error {
+	return verifyECDSASignatureFormat(pub, digestValue, signatureBytes, ecdsaSignatureFormat(signatureBytes))
+}
+
+// ecdsaSignatureFormat tells ASN.1 DER signature from raw r||s (P1363).
+func ecdsaSignatureFormat(signatureBytes []byte) string {
+	var esig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(signatureBytes, &esig); err == nil {
+		return "DER"
+	}
+	return "P1363"
+}
+
+func verifyECDSASignatureFormat(pub *ecdsa.PublicKey, digestValue, signatureBytes []byte, format string) error {
 	var esig struct {
 		R, S *big.Int
 	}
-	_, errAsn1 := asn1.Unmarshal(signatureBytes, &esig)
-	if errAsn1 == nil {
-		if !ecdsa.Verify(pub, digestValue, esig.R, esig.S) {
-			return errors.New("ECDSA verification failed")
+	switch format {
+	case "DER":
+		if _, err := asn1.Unmarshal(signatureBytes, &esig); err != nil {
+			return errors.New("invalid ECDSA DER signature")
 		}
-	} else {
+	case "P1363":
 		keyBytes := (pub.Params().BitSize + 7) >> 3
 		if len(signatureBytes) != 2*keyBytes {
 			return errors.New("invalid ECDSA signature length")
 		}
-		r := new(big.Int).SetBytes(signatureBytes[:keyBytes])
-		s := new(big.Int).SetBytes(signatureBytes[keyBytes:])
-		if !ecdsa.Verify(pub, digestValue, r, s) {
-			return errors.New("ECDSA verification failed")
-		}
+		esig.R = new(big.Int).SetBytes(signatureBytes[:keyBytes])
+		esig.S = new(big.Int).SetBytes(signatureBytes[keyBytes:])
+	default:
+		return fmt.Errorf("invalid signature method, use 'P1363' or 'DER'")
 	}
 
+	if !ecdsa.Verify(pub, digestValue, esig.R, esig.S) {
+		return errors.New("ECDSA verification failed")
+	}
 	return nil
 }
 
 func VerifySignature(w http.ResponseWriter, r *http.Request) {
-	var verifyBody requests.VerifyBody
-	err := json.NewDecoder(r.Body).Decode(&verifyBody)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusUnprocessableEntity)
-		return
-	}
-
-	certificate, err := parseCertificate(verifyBody.Certificate)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	signatureBytes, err := decodeBase64(verifyBody.SignatureValue)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid signature value: %v", err), http.StatusBadRequest)
-		return
-	}
+	VerifyHandler(nil)(w, r)
+}
 
-	digestValue, err := decodeBase64(verifyBody.DigestValue)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid digest value: %v", err), http.StatusBadRequest)
-		return
-	}
+// VerifyHandler verifies the signature and signer certificate. Certificate
+// chain is built to roots of the trust store. Result is returned with status
+// 200 whether the signature is valid or not.
+func VerifyHandler(trustStore *x509.CertPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var verifyBody requests.VerifyBody
+		err := json.NewDecoder(r.Body).Decode(&verifyBody)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
 
-	switch pub := certificate.PublicKey.(type) {
-	case *rsa.PublicKey:
-		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digestValue, signatureBytes)
-	case *ecdsa.PublicKey:
-		err = verifyECDSASignature(pub, digestValue, signatureBytes)
-	default:
-		http.Error(w, fmt.Sprintf("Unsupported public key type: %T", certificate.PublicKey), http.StatusBadRequest)
-		return
-	}
+		result, err := verifyDigestSignature(verifyBody, nil, trustStore)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to verify signature: %v", err), http.StatusBadRequest)
-		return
+		// Verification outcome is in the result, status tells only request errors
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
 	}
-
-	fmt.Fprintln(w, "Signature is valid!")
 }
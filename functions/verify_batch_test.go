@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package functions
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unknovs/hash-sign/routes/responses"
+)
+
+func TestVerifyBatchHandler(t *testing.T) {
+	fmt.Println("!!! Starting batch verification tests on verify_batch.go !!!")
+	privateKey := generateTestRSAKey(t)
+	certificate := generateTestCertificate(t, privateKey)
+
+	signerCertificate, err := parseCertificate(certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustStore := x509.NewCertPool()
+	trustStore.AddCert(signerCertificate)
+
+	var items []map[string]interface{}
+	for i := range 20 {
+		digest := sha256.Sum256([]byte(fmt.Sprintf("document %d", i)))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+		// Every third signature is for another digest
+		if i%3 == 1 {
+			digest = sha256.Sum256([]byte("another document"))
+		}
+		items = append(items, map[string]interface{}{
+			"id":             fmt.Sprintf("item-%d", i),
+			"digestValue":    base64.StdEncoding.EncodeToString(digest[:]),
+			"signatureValue": base64.StdEncoding.EncodeToString(signature),
+			"certificate":    certificate,
+		})
+	}
+	items = append(items, map[string]interface{}{"id": "broken", "digestValue": "%%%", "signatureValue": "", "certificate": certificate})
+	body, _ := json.Marshal(items)
+
+	req := httptest.NewRequest(http.MethodPost, "/digest/verify/batch", strings.NewReader(string(body)))
+	rr := httptest.NewRecorder()
+	VerifyBatchHandler(trustStore, 4)(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var results []responses.VerifyBatchResult
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&results))
+	assert.Len(t, results, len(items))
+	for i, result := range results[:20] {
+		assert.Equal(t, fmt.Sprintf("item-%d", i), result.Id)
+		assert.Empty(t, result.Error)
+		assert.Equal(t, i%3 != 1, result.Valid, result.Id)
+	}
+	assert.Equal(t, "broken", results[20].Id)
+	assert.Contains(t, results[20].Error, "Invalid digest value")
+	assert.Nil(t, results[20].VerifyResult)
+}
+
+func TestVerifyBatchHandlerRequestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{name: "Invalid method", method: http.MethodGet, body: "[]", status: http.StatusMethodNotAllowed},
+		{name: "Not an array", method: http.MethodPost, body: `{"digestValue": "aGFzaA=="}`, status: http.StatusUnprocessableEntity},
+		{name: "Empty batch", method: http.MethodPost, body: "[]", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/digest/verify/batch", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			VerifyBatchHandler(nil, 0)(rr, req)
+			assert.Equal(t, tt.status, rr.Code)
+		})
+	}
+}
+
+func TestVerifyBatchHandlerBodyLimit(t *testing.T) {
+	// Body is refused while reading, before the array is decoded
+	item := `{"digestValue": "` + strings.Repeat("A", maxVerifyBatchItemSize) + `"},`
+	body := io.MultiReader(strings.NewReader("["), &repeatReader{data: []byte(item), limit: maxVerifyBatchSize + 1})
+	req := httptest.NewRequest(http.MethodPost, "/digest/verify/batch", body)
+	rr := httptest.NewRecorder()
+	VerifyBatchHandler(nil, 0)(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Request body is larger than")
+}
+
+// repeatReader returns data limit times.
+type repeatReader struct {
+	data   []byte
+	limit  int
+	offset int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.limit == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.offset:])
+	r.offset += n
+	if r.offset == len(r.data) {
+		r.offset = 0
+		r.limit--
+	}
+	return n, nil
+}
+
+func TestCertificateCache(t *testing.T) {
+	certificate := generateTestCertificate(t, generateTestRSAKey(t))
+	certificates := newCertificateCache()
+
+	first, err := certificates.parse(certificate)
+	assert.NoError(t, err)
+	second, err := certificates.parse(certificate)
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Len(t, certificates.certificates, 1)
+
+	_, err = certificates.parse(base64.StdEncoding.EncodeToString([]byte("not a certificate")))
+	assert.Error(t, err)
+	assert.Len(t, certificates.certificates, 1)
+}
@@ -26,6 +26,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/unknovs/hash-sign/env"
 	"github.com/unknovs/hash-sign/functions"
@@ -44,11 +46,18 @@ func main() {
 		log.Println("API key not set in environment. Continuing without API key")
 	}
 
-	// Check if the volume is mounted
-	volumePath := "/tmp"
-	if !functions.CheckVolumeMounted(volumePath) {
-		log.Println("Volume is not available or mounted. asice/addFile method wont be available")
+	// Work directory for container files too large to be kept in memory
+	workMemoryLimit, err := strconv.ParseInt(env.WorkMemoryLimit, 10, 64)
+	if env.WorkMemoryLimit != "" && err != nil {
+		log.Printf("Invalid WORK_MEMORY_LIMIT: %s", err)
 	}
+	storage := functions.NewWorkStorage(env.WorkDir, workMemoryLimit)
+	if err := storage.Check(); err != nil {
+		log.Printf("%s: %s", storage.Dir, err)
+		log.Printf("asice/addFile and asics/addFile will process only requests up to %d bytes", storage.MemoryLimit)
+	}
+	stopJanitor := storage.StartJanitor(0, 0)
+	defer stopJanitor()
 
 	// Read the PEM file and extract the private key
 	privateKey, err := functions.GetPrivateKey(env.PemFile)
@@ -64,13 +73,81 @@ func main() {
 		log.Printf("signing using ecc key /digest/sign-ecc wont be possible")
 	}
 
+	// Read the Ed25519 PEM file and extract the private key
+	privateKeyEd, err := functions.GetEdPrivateKey(env.EdPemFile)
+	if err != nil {
+		log.Printf("Failed to parse Ed25519 pem file: %s", err)
+		log.Printf("signing using Ed25519 key /digest/sign-eddsa wont be possible")
+	}
+
+	// Register keys from PEM files, key directory and key config
+	keys, err := functions.LoadKeyRegistry(privateKey, privateKeyEC, privateKeyEd)
+	if err != nil {
+		log.Printf("Failed to load keys: %s", err)
+	}
+
+	// Prepared ASiC-E signatures waiting for external signature value
+	sessionTTL, err := time.ParseDuration(env.AsiceSessionTTL)
+	if env.AsiceSessionTTL != "" && err != nil {
+		log.Printf("Invalid ASICE_SESSION_TTL: %s", err)
+	}
+	sessions := functions.NewAsiceSessionStore(sessionTTL)
+
+	// Root CA certificates for certificate chain validation
+	trustStore, err := functions.LoadTrustStore(env.TrustStore)
+	if err != nil {
+		log.Printf("Failed to load trust store: %s", err)
+	}
+	if trustStore == nil {
+		log.Println("Trust store not loaded. /digest/verify and /asice/validate can not check certificate chains")
+	}
+
+	// Time-stamp authority for CAdES-T and XAdES-T signatures
+	timestamps, err := functions.NewTimestampClient(env.TsaUrl, env.TsaCert, trustStore)
+	if err != nil {
+		log.Fatalf("Failed to configure TSA_URL: %s", err)
+	} else if timestamps == nil {
+		log.Println("TSA_URL not set. Signatures can't be timestamped")
+	}
+
+	// Local time-stamp authority
+	authority, err := functions.NewTimestampAuthority(keys, env.TsaKeyId, env.TsaPolicyOid, env.TsaAccuracy)
+	if err != nil {
+		log.Printf("Failed to configure TSA: %s", err)
+		log.Println("/tsa method wont be available")
+	} else if authority != nil {
+		log.Printf("TSA mode enabled with key %s and policy %s", authority.Key.ID, authority.Policy)
+	}
+
+	// Concurrent verifications of /digest/verify/batch request
+	verifyWorkers, err := strconv.Atoi(env.VerifyWorkers)
+	if env.VerifyWorkers != "" && err != nil {
+		log.Printf("Invalid VERIFY_WORKERS: %s", err)
+	}
+
 	// Router
-	http.HandleFunc("/digest/sign", functions.APIKeyAuthorization(functions.SigningHandler(privateKey)))
-	http.HandleFunc("/digest/sign-ecc", functions.APIKeyAuthorization(functions.SigningHandlerEC(privateKeyEC)))
-	http.HandleFunc("/digest/verify", functions.APIKeyAuthorization(functions.VerifySignature))
+	http.HandleFunc("/digest/sign", functions.APIKeyAuthorization(functions.SigningHandler(keys)))
+	http.HandleFunc("/digest/sign-ecc", functions.APIKeyAuthorization(functions.SigningHandlerEC(keys)))
+	http.HandleFunc("/digest/sign-eddsa", functions.APIKeyAuthorization(functions.SigningHandlerEdDSA(keys)))
+	http.HandleFunc("/cms/sign", functions.APIKeyAuthorization(functions.CmsSigningHandler(keys, timestamps)))
+	http.HandleFunc("/digest/verify", functions.APIKeyAuthorization(functions.VerifyHandler(trustStore)))
+	http.HandleFunc("/digest/verify/batch", functions.APIKeyAuthorization(functions.VerifyBatchHandler(trustStore, verifyWorkers)))
 	http.HandleFunc("/digest/calculateSummary", functions.APIKeyAuthorization(functions.HandleDigest))
-	http.HandleFunc("/certificates", functions.APIKeyAuthorization(functions.HandleCertificatesRequest))
-	http.HandleFunc("/asice/addFile", functions.APIKeyAuthorization(functions.HandleAddFileToAsiceRequest))
+	http.HandleFunc("/certificates", functions.APIKeyAuthorization(functions.CertificatesHandler(keys)))
+	http.HandleFunc("/asice/addFile", functions.APIKeyAuthorization(functions.AddFileHandler(storage)))
+	http.HandleFunc("/asice/create", functions.APIKeyAuthorization(functions.HandleCreateAsiceRequest))
+	http.HandleFunc("/asice/inspect", functions.APIKeyAuthorization(functions.HandleInspectAsiceRequest))
+	http.HandleFunc("/asice/validate", functions.APIKeyAuthorization(functions.ValidateAsiceHandler(trustStore)))
+	http.HandleFunc("/asice/sign", functions.APIKeyAuthorization(functions.AsiceSigningHandler(keys, timestamps)))
+	http.HandleFunc("/asice/prepare", functions.APIKeyAuthorization(functions.AsicePrepareHandler(sessions)))
+	http.HandleFunc("/asice/finalize", functions.APIKeyAuthorization(functions.AsiceFinalizeHandler(sessions, timestamps)))
+	http.HandleFunc("/asice/remove", functions.APIKeyAuthorization(functions.HandleRemoveAsiceFileRequest))
+	http.HandleFunc("/asice/replace", functions.APIKeyAuthorization(functions.HandleReplaceAsiceFileRequest))
+	http.HandleFunc("/asice/extract", functions.APIKeyAuthorization(functions.HandleExtractAsiceFileRequest))
+	http.HandleFunc("/asics/addFile", functions.APIKeyAuthorization(functions.AddFileToAsicsHandler(storage)))
+	http.HandleFunc("/asics/create", functions.APIKeyAuthorization(functions.HandleCreateAsicsRequest))
+	http.HandleFunc("/asics/inspect", functions.APIKeyAuthorization(functions.HandleInspectAsicsRequest))
+	http.HandleFunc("/tsa", functions.APIKeyAuthorization(functions.TimestampAuthorityHandler(authority)))
 	http.HandleFunc("/encrypt/publicKey", functions.APIKeyAuthorization(functions.EncryptWithPublicKeyHandler))
 	http.HandleFunc("/digest/verificationCode", functions.APIKeyAuthorization(functions.CalculateVerificationCode))
 	http.HandleFunc("/jwt/generate", functions.APIKeyAuthorization(functions.JwtGenerateHandler))
@@ -30,4 +30,5 @@ type Request struct {
 type SignedFile struct {
 	FileName    string `json:"fileName"`
 	EncodedFile string `json:"encodedFile"`
+	MimeType    string `json:"mimeType,omitempty"`
 }
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package requests
+
+type AsiceSign struct {
+	Container     string `json:"container"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+}
+
+type AsicePrepare struct {
+	SignedFiles   []SignedFile `json:"signedFiles,omitempty"`
+	Container     string       `json:"container,omitempty"`
+	Certificate   string       `json:"certificate"`
+	HashAlgorithm string       `json:"hashAlgorithm,omitempty"`
+}
+
+type AsiceFinalize struct {
+	Token          string `json:"token"`
+	SignatureValue string `json:"signatureValue"`
+}
+
+type AsiceCreate struct {
+	SignedFiles []SignedFile `json:"signedFiles"`
+}
+
+type AsiceInspect struct {
+	Container     string `json:"container"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+}
+
+type AsiceValidate struct {
+	Container string `json:"container"`
+}
+
+type AsiceRemove struct {
+	Container      string `json:"container"`
+	FileName       string `json:"fileName"`
+	DropSignatures bool   `json:"dropSignatures,omitempty"`
+}
+
+type AsiceReplace struct {
+	Container      string     `json:"container"`
+	SignedFile     SignedFile `json:"signedFile"`
+	DropSignatures bool       `json:"dropSignatures,omitempty"`
+}
+
+type AsiceExtract struct {
+	Container string `json:"container"`
+	FileName  string `json:"fileName,omitempty"`
+}
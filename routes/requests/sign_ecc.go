@@ -23,5 +23,8 @@
 package requests
 
 type SignEcdsa struct {
-	DigestToSign string `json:"hash"`
+	SessionId     string `json:"sessionId,omitempty"`
+	DigestToSign  string `json:"hash"`
+	Data          string `json:"data,omitempty"`
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
 }
@@ -23,7 +23,21 @@
 package requests
 
 type VerifyBody struct {
-	SignatureValue string `json:"signatureValue"`
-	Certificate    string `json:"certificate"`
-	DigestValue    string `json:"digestValue"`
+	SignatureValue    string   `json:"signatureValue"`
+	Certificate       string   `json:"certificate"`
+	DigestValue       string   `json:"digestValue"`
+	Intermediates     []string `json:"intermediates,omitempty"`
+	ValidationTime    string   `json:"validationTime,omitempty"`
+	ExtendedKeyUsages []string `json:"extendedKeyUsages,omitempty"`
+	HashAlgorithm     string   `json:"hashAlgorithm,omitempty"`
+	SignatureMethod   string   `json:"signatureMethod,omitempty"`
+	SaltLength        *int     `json:"saltLength,omitempty"`
+	// SkipChainValidation accepts signer certificate without checking its chain
+	SkipChainValidation bool `json:"skipChainValidation,omitempty"`
+}
+
+// VerifyBatchItem is one signature of /digest/verify/batch with client id.
+type VerifyBatchItem struct {
+	Id string `json:"id,omitempty"`
+	VerifyBody
 }
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package responses
+
+type AsicePrepare struct {
+	Token           string `json:"token"`
+	Digest          string `json:"digest"`
+	HashAlgorithm   string `json:"hashAlgorithm"`
+	SignatureMethod string `json:"signatureMethod"`
+	SignedInfo      string `json:"signedInfo"`
+	ExpiresAt       string `json:"expiresAt"`
+}
+
+type AsiceInspection struct {
+	MimeType      string               `json:"mimeType"`
+	Profile       string               `json:"profile,omitempty"`
+	ProfileErrors []string             `json:"profileErrors,omitempty"`
+	DataFiles     []AsiceDataFile      `json:"dataFiles"`
+	Signatures    []AsiceSignatureInfo `json:"signatures"`
+}
+
+type AsiceDataFile struct {
+	Name            string `json:"name"`
+	Size            uint64 `json:"size"`
+	MimeType        string `json:"mimeType,omitempty"`
+	Digest          string `json:"digest"`
+	DigestAlgorithm string `json:"digestAlgorithm"`
+}
+
+type AsiceSignatureInfo struct {
+	File            string           `json:"file"`
+	Format          string           `json:"format"`
+	Id              string           `json:"id,omitempty"`
+	Signer          *CertificateInfo `json:"signer,omitempty"`
+	SigningTime     string           `json:"signingTime,omitempty"`
+	SignatureMethod string           `json:"signatureMethod,omitempty"`
+	SignedFiles     []string         `json:"signedFiles"`
+	Error           string           `json:"error,omitempty"`
+}
+
+type CertificateInfo struct {
+	Subject      string `json:"subject"`
+	Issuer       string `json:"issuer"`
+	SerialNumber string `json:"serialNumber"`
+	NotBefore    string `json:"notBefore"`
+	NotAfter     string `json:"notAfter"`
+}
+
+type AsiceValidation struct {
+	Valid               bool                       `json:"valid"`
+	SignatureCount      int                        `json:"signatureCount"`
+	ValidSignatureCount int                        `json:"validSignatureCount"`
+	ContainerErrors     []string                   `json:"containerErrors"`
+	ContainerWarnings   []string                   `json:"containerWarnings"`
+	Signatures          []AsiceSignatureValidation `json:"signatures"`
+}
+
+type AsiceSignatureValidation struct {
+	File          string           `json:"file"`
+	Id            string           `json:"id,omitempty"`
+	Signer        *CertificateInfo `json:"signer,omitempty"`
+	SigningTime   string           `json:"signingTime,omitempty"`
+	SignedFiles   []string         `json:"signedFiles"`
+	Indication    string           `json:"indication"`
+	SubIndication string           `json:"subIndication,omitempty"`
+	Errors        []string         `json:"errors"`
+	Warnings      []string         `json:"warnings"`
+}
+
+type AsiceFile struct {
+	FileName    string `json:"fileName"`
+	EncodedFile string `json:"encodedFile"`
+	MimeType    string `json:"mimeType"`
+}
+
+type AsiceFiles struct {
+	Files map[string]string `json:"files"`
+}
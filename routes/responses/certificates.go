@@ -23,8 +23,16 @@
 package responses
 
 type CertificatesResponse struct {
-	RsaAuthenticationCertificate   string `json:"rsa_authentication_certificate,omitempty"`
-	RsaSigningCertificate          string `json:"rsa_signing_certificate,omitempty"`
-	EcdsaAuthenticationCertificate string `json:"ecdsa_authentication_certificate,omitempty"`
-	EcdsaSigningCertificate        string `json:"ecdsa_signing_certificate,omitempty"`
+	RsaAuthenticationCertificate   string           `json:"rsa_authentication_certificate,omitempty"`
+	RsaSigningCertificate          string           `json:"rsa_signing_certificate,omitempty"`
+	EcdsaAuthenticationCertificate string           `json:"ecdsa_authentication_certificate,omitempty"`
+	EcdsaSigningCertificate        string           `json:"ecdsa_signing_certificate,omitempty"`
+	Keys                           []KeyCertificate `json:"keys,omitempty"`
+}
+
+type KeyCertificate struct {
+	KeyId       string   `json:"keyId"`
+	Algorithm   string   `json:"algorithm"`
+	Certificate string   `json:"certificate"`
+	Operations  []string `json:"operations"`
 }
@@ -25,6 +25,9 @@ package responses
 type HashSignature struct {
 	SessionId       string `json:"sessionId"`
 	SignatureMethod string `json:"signatureMethod"`
+	HashAlgorithm   string `json:"hashAlgorithm,omitempty"`
 	Hash            string `json:"hash"`
 	SignatureValue  string `json:"signatureValue"`
+	SaltLength      *int   `json:"saltLength,omitempty"`
+	Error           string `json:"error,omitempty"`
 }
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: MIT
+
+// Copyright (c) 2024 Gatis Beikerts
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package responses
+
+// Status values of a verification check
+const (
+	CheckPassed  = "passed"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+type VerifyResult struct {
+	Valid           bool             `json:"valid"`
+	Algorithm       string           `json:"algorithm,omitempty"`
+	HashAlgorithm   string           `json:"hashAlgorithm,omitempty"`
+	SignatureFormat string           `json:"signatureFormat,omitempty"`
+	Signer          *CertificateInfo `json:"signer,omitempty"`
+	Checks          []VerifyCheck    `json:"checks"`
+	Reasons         []string         `json:"reasons,omitempty"`
+	// ChainValidationSkipped is set if chain check was skipped on request
+	ChainValidationSkipped bool `json:"chainValidationSkipped,omitempty"`
+}
+
+type VerifyCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// VerifyBatchResult is the result of a batch item. Error is set instead of
+// the result if the item could not be verified.
+type VerifyBatchResult struct {
+	Id    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+	*VerifyResult
+}